@@ -0,0 +1,259 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// addressPollInterval is how often a nat gateway is described while its
+// public address is not listed yet
+var addressPollInterval = time.Second
+
+// addressPollAttempts bounds how many times the nat gateway is described
+// waiting for its public address
+var addressPollAttempts = 10
+
+const (
+	// byoipWithdrawalWarn warns when the elastic ip comes from a byoip
+	// cidr that is withdrawn or being reclaimed
+	byoipWithdrawalWarn = "warn"
+	// byoipWithdrawalFail fails creating a nat gateway on such an address
+	byoipWithdrawalFail = "fail"
+)
+
+// ErrByoipWithdrawalPolicyInvalid ...
+var ErrByoipWithdrawalPolicyInvalid = errors.New("Byoip withdrawal policy must be warn or fail")
+
+// byoipWithdrawnStates are the states of a byoip cidr aws doesn't
+// advertise, or is taking back, so its addresses can't be reached
+var byoipWithdrawnStates = map[string]bool{
+	ec2.ByoipCidrStateProvisioned:        true,
+	ec2.ByoipCidrStatePendingDeprovision: true,
+	ec2.ByoipCidrStateDeprovisioned:      true,
+}
+
+// suggester is an error that knows how the operator can solve it
+type suggester interface {
+	Suggestion() string
+}
+
+// AddressLimitError : the account has no elastic ips left in the region.
+// The nat gateway can only use an address of its own region, so there is
+// no falling back to another one.
+type AddressLimitError struct {
+	Region string
+	Err    error
+}
+
+func (e *AddressLimitError) Error() string {
+	return fmt.Sprintf("Elastic ip limit reached on %s: %s", e.Region, e.Err.Error())
+}
+
+// Suggestion : how to get more elastic ips
+func (e *AddressLimitError) Suggestion() string {
+	return fmt.Sprintf("Release unused elastic ips on %s, or request an increase of its EC2-VPC Elastic IPs service quota", e.Region)
+}
+
+// allocateAddress allocates the nat gateway elastic ip
+func (ev *Event) allocateAddress(svc ec2iface.EC2API) error {
+	req := ec2.AllocateAddressInput{}
+	if ev.NetworkBorderGroup != "" {
+		req.NetworkBorderGroup = aws.String(ev.NetworkBorderGroup)
+	}
+
+	resp, err := svc.AllocateAddress(&req)
+	if isAWSError(err, "AddressLimitExceeded") {
+		return &AddressLimitError{Region: ev.DatacenterRegion, Err: err}
+	}
+	if err != nil {
+		return err
+	}
+
+	if resp.AllocationId == nil || resp.PublicIp == nil {
+		return ErrAllocationIncomplete
+	}
+
+	ev.NatGatewayAllocationID = *resp.AllocationId
+	ev.NatGatewayAllocationIP = *resp.PublicIp
+	ev.track("elastic_ip", ev.NatGatewayAllocationID, ev.rollbackAddress)
+
+	if nameTemplate == "" {
+		return nil
+	}
+
+	tags, err := ev.namedTags(svc, nil, "eip", ev.PublicNetworkAWSID)
+	if err != nil {
+		return err
+	}
+
+	return ev.applyTags(svc, resp.AllocationId, nil, tags)
+}
+
+// hasPublicAddress checks if the nat gateway lists its public address
+func hasPublicAddress(gw *ec2.NatGateway) bool {
+	for _, addr := range gw.NatGatewayAddresses {
+		if aws.StringValue(addr.PublicIp) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// waitNatGatewayAddresses describes an available nat gateway until it
+// lists its public address, as aws can report it available before
+// associating the elastic ip. A gateway still missing it is returned as
+// is, with a warning.
+func (ev *Event) waitNatGatewayAddresses(svc ec2iface.EC2API, gw *ec2.NatGateway) (*ec2.NatGateway, error) {
+	var err error
+
+	for i := 1; !hasPublicAddress(gw); i++ {
+		if i >= addressPollAttempts {
+			ev.warn(fmt.Sprintf("Nat gateway %s does not list its public address yet", aws.StringValue(gw.NatGatewayId)))
+			return gw, nil
+		}
+
+		debugf("Nat gateway %s does not list its public address yet, checking again in %s", aws.StringValue(gw.NatGatewayId), addressPollInterval)
+		sleep(addressPollInterval)
+
+		gw, err = ev.natGatewayByID(svc, aws.StringValue(gw.NatGatewayId))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return gw, nil
+}
+
+// reportAddress sets the elastic ip the nat gateway lists
+func (ev *Event) reportAddress(gw *ec2.NatGateway) {
+	for _, addr := range gw.NatGatewayAddresses {
+		if aws.StringValue(addr.PublicIp) == "" {
+			continue
+		}
+
+		ev.NatGatewayAllocationID = aws.StringValue(addr.AllocationId)
+		ev.NatGatewayAllocationIP = aws.StringValue(addr.PublicIp)
+		return
+	}
+}
+
+// GatewayAddress : an address of the nat gateway, its private ip and the
+// elastic ip mapped to it, if any
+type GatewayAddress struct {
+	AllocationID string `json:"allocation_id,omitempty"`
+	PublicIP     string `json:"public_ip,omitempty"`
+	PrivateIP    string `json:"private_ip,omitempty"`
+	IsPrimary    bool   `json:"is_primary"`
+}
+
+// reportAddresses sets every address of the nat gateway, the primary one
+// and the secondary ones. Aws only flags the primary address since nat
+// gateways can have several, the first one being primary otherwise.
+func (ev *Event) reportAddresses(gw *ec2.NatGateway) {
+	ev.NatGatewayAddresses = nil
+
+	for i, addr := range gw.NatGatewayAddresses {
+		primary := i == 0
+		if addr.IsPrimary != nil {
+			primary = *addr.IsPrimary
+		}
+
+		ev.NatGatewayAddresses = append(ev.NatGatewayAddresses, GatewayAddress{
+			AllocationID: aws.StringValue(addr.AllocationId),
+			PublicIP:     aws.StringValue(addr.PublicIp),
+			PrivateIP:    aws.StringValue(addr.PrivateIp),
+			IsPrimary:    primary,
+		})
+	}
+}
+
+// reportAddressPool sets the public ipv4 pool the elastic ip comes from,
+// amazon or the pool of an address brought to aws. It only warns when aws
+// doesn't describe them, as the nat gateway works regardless.
+func (ev *Event) reportAddressPool(svc ec2iface.EC2API) {
+	ev.PublicIPv4Pool = ""
+	ev.ByoipCidr = ""
+	ev.ByoipCidrState = ""
+
+	if ev.NatGatewayAllocationID == "" {
+		return
+	}
+
+	req := ec2.DescribeAddressesInput{
+		AllocationIds: []*string{aws.String(ev.NatGatewayAllocationID)},
+	}
+
+	resp, err := svc.DescribeAddresses(&req)
+	if err != nil {
+		ev.warn("Could not report the public ipv4 pool of elastic ip " + ev.NatGatewayAllocationID + ": " + err.Error())
+		return
+	}
+
+	for _, addr := range resp.Addresses {
+		ev.PublicIPv4Pool = aws.StringValue(addr.PublicIpv4Pool)
+	}
+
+	if ev.PublicIPv4Pool == "" || ev.PublicIPv4Pool == "amazon" {
+		return
+	}
+
+	err = ev.reportByoipCidr(svc)
+	if err != nil {
+		ev.warn("Could not report the byoip cidr of elastic ip " + ev.NatGatewayAllocationID + ": " + err.Error())
+	}
+}
+
+// reportByoipCidr sets the byoip cidr an elastic ip brought to aws comes
+// from, and its state
+func (ev *Event) reportByoipCidr(svc ec2iface.EC2API) error {
+	ip := net.ParseIP(ev.NatGatewayAllocationIP)
+	if ip == nil {
+		return nil
+	}
+
+	req := ec2.DescribeByoipCidrsInput{
+		MaxResults: aws.Int64(100),
+	}
+
+	for {
+		resp, err := svc.DescribeByoipCidrs(&req)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range resp.ByoipCidrs {
+			_, cidr, err := net.ParseCIDR(aws.StringValue(c.Cidr))
+			if err == nil && cidr.Contains(ip) {
+				ev.ByoipCidr = aws.StringValue(c.Cidr)
+				ev.ByoipCidrState = aws.StringValue(c.State)
+				return nil
+			}
+		}
+
+		if aws.StringValue(resp.NextToken) == "" {
+			return nil
+		}
+		req.NextToken = resp.NextToken
+	}
+}
+
+// byoipWithdrawal explains why the elastic ip can't be reached, when the
+// byoip cidr it comes from is not advertised or being taken back by aws
+func (ev *Event) byoipWithdrawal() error {
+	if !byoipWithdrawnStates[ev.ByoipCidrState] {
+		return nil
+	}
+
+	return fmt.Errorf("Elastic ip %s is from byoip cidr %s, which is %s", ev.NatGatewayAllocationIP, ev.ByoipCidr, ev.ByoipCidrState)
+}
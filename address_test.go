@@ -0,0 +1,381 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAddressLimit(t *testing.T) {
+	Convey("Given the account has no elastic ips left", t, func() {
+		log.SetOutput(ioutil.Discard)
+		data, _ := json.Marshal(testEvent)
+		f := newFakeEC2()
+		useFake(f)
+		f.errors["AllocateAddress"] = awserr.New("AddressLimitExceeded", "The maximum number of addresses has been reached.", nil)
+
+		var published []byte
+		publish = func(subject string, data []byte) error {
+			published = data
+			return nil
+		}
+
+		Convey("When creating the event", func() {
+			e := New("nat.create.aws", data)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should fail before creating the nat gateway", func() {
+				So(err, ShouldHaveSameTypeAs, &AddressLimitError{})
+				So(err.Error(), ShouldStartWith, "Elastic ip limit reached on eu-west-1: AddressLimitExceeded")
+				So(f.Count("CreateNatGateway"), ShouldEqual, 0)
+			})
+
+			Convey("It should suggest how to get more elastic ips", func() {
+				e.Error(err)
+				So(e.Suggestion, ShouldEqual, "Release unused elastic ips on eu-west-1, or request an increase of its EC2-VPC Elastic IPs service quota")
+				So(string(published), ShouldContainSubstring, `"suggestion":"Release unused elastic ips on eu-west-1`)
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stdout)
+			publish = func(subject string, data []byte) error {
+				return nc.Publish(subject, data)
+			}
+		})
+	})
+}
+
+func TestNatGatewayAddresses(t *testing.T) {
+	Convey("Given a nat gateway that lists its elastic ip on the second describe", t, func() {
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+
+		var waits []time.Duration
+		sleep = func(d time.Duration) { waits = append(waits, d) }
+
+		describes := 0
+		address := &ec2.NatGatewayAddress{AllocationId: aws.String("eipalloc-00000001")}
+		f.hooks["DescribeNatGateways"] = func(input interface{}) (interface{}, error) {
+			describes++
+			if describes > 1 {
+				address.PublicIp = aws.String("52.0.0.7")
+			}
+			return &ec2.DescribeNatGatewaysOutput{NatGateways: []*ec2.NatGateway{
+				&ec2.NatGateway{
+					NatGatewayId:        aws.String(testEvent.NatGatewayAWSID),
+					SubnetId:            aws.String(testEvent.PublicNetworkAWSID),
+					State:               aws.String(ec2.NatGatewayStateAvailable),
+					NatGatewayAddresses: []*ec2.NatGatewayAddress{address},
+				},
+			}}, nil
+		}
+
+		Convey("When getting it", func() {
+			data, _ := json.Marshal(testEvent)
+			e := New("nat.get.aws", data)
+			e.Process()
+			err := e.Get()
+
+			Convey("It should wait to report its public ip", func() {
+				So(err, ShouldBeNil)
+				So(describes, ShouldEqual, 2)
+				So(waits, ShouldResemble, []time.Duration{addressPollInterval})
+				So(e.NatGatewayAllocationID, ShouldEqual, "eipalloc-00000001")
+				So(e.NatGatewayAllocationIP, ShouldEqual, "52.0.0.7")
+				So(e.Warnings, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When creating it", func() {
+			ev := testEvent
+			ev.NatGatewayAWSID = ""
+			ev.BatchID = ""
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should report the public ip it lists", func() {
+				So(err, ShouldBeNil)
+				So(describes, ShouldBeGreaterThan, 1)
+				So(len(waits), ShouldEqual, 1)
+				So(e.NatGatewayAllocationIP, ShouldEqual, "52.0.0.7")
+			})
+		})
+
+		Convey("When it never lists it", func() {
+			f.hooks["DescribeNatGateways"] = func(input interface{}) (interface{}, error) {
+				describes++
+				return &ec2.DescribeNatGatewaysOutput{NatGateways: []*ec2.NatGateway{
+					&ec2.NatGateway{
+						NatGatewayId:        aws.String(testEvent.NatGatewayAWSID),
+						SubnetId:            aws.String(testEvent.PublicNetworkAWSID),
+						State:               aws.String(ec2.NatGatewayStateAvailable),
+						NatGatewayAddresses: []*ec2.NatGatewayAddress{address},
+					},
+				}}, nil
+			}
+			data, _ := json.Marshal(testEvent)
+			e := New("nat.get.aws", data)
+			e.Process()
+			err := e.Get()
+
+			Convey("It should give up with a warning", func() {
+				So(err, ShouldBeNil)
+				So(describes, ShouldEqual, addressPollAttempts)
+				So(e.NatGatewayAllocationIP, ShouldBeEmpty)
+				So(e.Warnings, ShouldContain, "Nat gateway "+testEvent.NatGatewayAWSID+" does not list its public address yet")
+			})
+		})
+
+		Reset(func() {
+			sleep = time.Sleep
+		})
+	})
+}
+
+func TestAddressPool(t *testing.T) {
+	Convey("Given a create event", t, func() {
+		data, _ := json.Marshal(testEvent)
+		f := newFakeEC2()
+		useFake(f)
+
+		e := New("nat.create.aws", data)
+		e.Process()
+
+		Convey("When the elastic ip is allocated from amazon", func() {
+			err := e.Create()
+
+			Convey("It should report the amazon pool", func() {
+				So(err, ShouldBeNil)
+				So(e.PublicIPv4Pool, ShouldEqual, "amazon")
+			})
+		})
+
+		Convey("When aws doesn't describe the elastic ip", func() {
+			f.errors["DescribeAddresses"] = awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil)
+			err := e.Create()
+
+			Convey("It should create the nat gateway and warn", func() {
+				So(err, ShouldBeNil)
+				So(e.NatGatewayAWSID, ShouldNotBeEmpty)
+				So(e.PublicIPv4Pool, ShouldBeEmpty)
+				So(e.Warnings, ShouldContain, "Could not report the public ipv4 pool of elastic ip "+e.NatGatewayAllocationID+": UnauthorizedOperation: You are not authorized to perform this operation.")
+			})
+		})
+
+		Convey("When the elastic ip comes from an address pool brought to aws", func() {
+			var req *ec2.DescribeAddressesInput
+			f.hooks["DescribeAddresses"] = func(input interface{}) (interface{}, error) {
+				req = input.(*ec2.DescribeAddressesInput)
+				return &ec2.DescribeAddressesOutput{Addresses: []*ec2.Address{
+					&ec2.Address{AllocationId: req.AllocationIds[0], PublicIpv4Pool: aws.String("ipv4pool-ec2-012345abcdef67890")},
+				}}, nil
+			}
+			err := e.Create()
+
+			Convey("It should report that pool", func() {
+				So(err, ShouldBeNil)
+				So(aws.StringValueSlice(req.AllocationIds), ShouldResemble, []string{e.NatGatewayAllocationID})
+				So(e.PublicIPv4Pool, ShouldEqual, "ipv4pool-ec2-012345abcdef67890")
+
+				payload, _ := json.Marshal(e)
+				So(string(payload), ShouldContainSubstring, `"public_ipv4_pool":"ipv4pool-ec2-012345abcdef67890"`)
+			})
+		})
+	})
+}
+
+func TestByoipWithdrawal(t *testing.T) {
+	Convey("Given an elastic ip from an address pool brought to aws", t, func() {
+		f := newFakeEC2()
+		useFake(f)
+		f.hooks["AllocateAddress"] = func(input interface{}) (interface{}, error) {
+			f.addresses["eipalloc-byoip"] = "198.51.100.10"
+			f.addressPools["eipalloc-byoip"] = "ipv4pool-ec2-012345abcdef67890"
+			return &ec2.AllocateAddressOutput{
+				AllocationId: aws.String("eipalloc-byoip"),
+				PublicIp:     aws.String("198.51.100.10"),
+			}, nil
+		}
+
+		byoip := func(state string) {
+			f.byoipCidrs = []*ec2.ByoipCidr{
+				&ec2.ByoipCidr{Cidr: aws.String("203.0.113.0/24"), State: aws.String(ec2.ByoipCidrStateAdvertised)},
+				&ec2.ByoipCidr{Cidr: aws.String("198.51.100.0/24"), State: aws.String(state)},
+			}
+		}
+
+		create := func(policy string) (Event, error) {
+			ev := testEvent
+			ev.NatGatewayAWSID = ""
+			ev.ByoipWithdrawalPolicy = policy
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+			if err := e.Validate(); err != nil {
+				return e, err
+			}
+			return e, e.Create()
+		}
+
+		Convey("When its cidr is advertised", func() {
+			byoip(ec2.ByoipCidrStateAdvertised)
+			e, err := create("")
+
+			Convey("It should report the cidr without warnings", func() {
+				So(err, ShouldBeNil)
+				So(e.ByoipCidr, ShouldEqual, "198.51.100.0/24")
+				So(e.ByoipCidrState, ShouldEqual, "advertised")
+				So(e.Warnings, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When its cidr is being deprovisioned", func() {
+			byoip(ec2.ByoipCidrStatePendingDeprovision)
+			e, err := create("")
+
+			Convey("It should warn", func() {
+				So(err, ShouldBeNil)
+				So(e.ByoipCidrState, ShouldEqual, "pending-deprovision")
+				So(e.Warnings, ShouldContain, "Elastic ip 198.51.100.10 is from byoip cidr 198.51.100.0/24, which is pending-deprovision")
+			})
+
+			Convey("It should warn when getting the nat gateway", func() {
+				payload, _ := json.Marshal(e)
+				g := New("nat.get.aws", payload)
+				g.Process()
+				So(g.Execute("get"), ShouldBeNil)
+				So(g.ByoipCidrState, ShouldEqual, "pending-deprovision")
+				So(g.Warnings, ShouldContain, "Elastic ip 198.51.100.10 is from byoip cidr 198.51.100.0/24, which is pending-deprovision")
+			})
+		})
+
+		Convey("When its cidr is no longer advertised and the policy is fail", func() {
+			byoip(ec2.ByoipCidrStateProvisioned)
+			_, err := create("fail")
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Elastic ip 198.51.100.10 is from byoip cidr 198.51.100.0/24, which is provisioned")
+			})
+		})
+
+		Convey("When aws doesn't describe its cidr", func() {
+			f.errors["DescribeByoipCidrs"] = awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil)
+			e, err := create("fail")
+
+			Convey("It should create the nat gateway and warn", func() {
+				So(err, ShouldBeNil)
+				So(e.PublicIPv4Pool, ShouldEqual, "ipv4pool-ec2-012345abcdef67890")
+				So(e.ByoipCidr, ShouldBeEmpty)
+				So(e.Warnings, ShouldContain, "Could not report the byoip cidr of elastic ip eipalloc-byoip: UnauthorizedOperation: You are not authorized to perform this operation.")
+			})
+		})
+
+		Convey("When a resent payload reports a previous cidr", func() {
+			byoip(ec2.ByoipCidrStateAdvertised)
+			e, _ := create("")
+			f.byoipCidrs = nil
+			payload, _ := json.Marshal(e)
+			g := New("nat.get.aws", payload)
+			g.Process()
+
+			Convey("It should only report the current one", func() {
+				So(g.Execute("get"), ShouldBeNil)
+				So(g.ByoipCidr, ShouldBeEmpty)
+				So(g.ByoipCidrState, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the policy is unknown", func() {
+			_, err := create("ignore")
+
+			Convey("It should not validate", func() {
+				So(err, ShouldEqual, ErrByoipWithdrawalPolicyInvalid)
+			})
+		})
+	})
+}
+
+func TestGatewayAddressList(t *testing.T) {
+	Convey("Given a nat gateway", t, func() {
+		f := newFakeEC2()
+		useFake(f)
+		gw := withNatGateway(f)
+
+		get := func() (Event, error) {
+			data, _ := json.Marshal(testEvent)
+			e := New("nat.get.aws", data)
+			e.Process()
+			return e, e.Get()
+		}
+
+		Convey("When it has a single address", func() {
+			gw.NatGatewayAddresses[0].PrivateIp = aws.String("10.0.0.10")
+			e, err := get()
+
+			Convey("It should report it as the primary one", func() {
+				So(err, ShouldBeNil)
+				So(e.NatGatewayAddresses, ShouldResemble, []GatewayAddress{
+					{AllocationID: "eipalloc-00000000", PublicIP: "52.0.0.1", PrivateIP: "10.0.0.10", IsPrimary: true},
+				})
+			})
+		})
+
+		Convey("When it has secondary addresses", func() {
+			gw.NatGatewayAddresses = []*ec2.NatGatewayAddress{
+				&ec2.NatGatewayAddress{AllocationId: aws.String("eipalloc-00000000"), PublicIp: aws.String("52.0.0.1"), PrivateIp: aws.String("10.0.0.10"), IsPrimary: aws.Bool(true)},
+				&ec2.NatGatewayAddress{AllocationId: aws.String("eipalloc-00000001"), PublicIp: aws.String("52.0.0.2"), PrivateIp: aws.String("10.0.0.11"), IsPrimary: aws.Bool(false)},
+				&ec2.NatGatewayAddress{PrivateIp: aws.String("10.0.0.12"), IsPrimary: aws.Bool(false)},
+			}
+			e, err := get()
+
+			Convey("It should report every one of them", func() {
+				So(err, ShouldBeNil)
+				So(e.NatGatewayAddresses, ShouldResemble, []GatewayAddress{
+					{AllocationID: "eipalloc-00000000", PublicIP: "52.0.0.1", PrivateIP: "10.0.0.10", IsPrimary: true},
+					{AllocationID: "eipalloc-00000001", PublicIP: "52.0.0.2", PrivateIP: "10.0.0.11"},
+					{PrivateIP: "10.0.0.12"},
+				})
+
+				payload, _ := json.Marshal(e)
+				So(string(payload), ShouldContainSubstring, `{"private_ip":"10.0.0.12","is_primary":false}`)
+			})
+		})
+
+		Convey("When creating one", func() {
+			ev := testEvent
+			ev.NatGatewayAWSID = ""
+			ev.BatchID = ""
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should report its address", func() {
+				So(err, ShouldBeNil)
+				So(len(e.NatGatewayAddresses), ShouldEqual, 1)
+				So(e.NatGatewayAddresses[0].AllocationID, ShouldEqual, e.NatGatewayAllocationID)
+				So(e.NatGatewayAddresses[0].PublicIP, ShouldEqual, e.NatGatewayAllocationIP)
+				So(e.NatGatewayAddresses[0].IsPrimary, ShouldBeTrue)
+			})
+		})
+	})
+}
@@ -0,0 +1,97 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// ErrAllowedSubnetTagInvalid ...
+var ErrAllowedSubnetTagInvalid = errors.New("Allowed subnet tag must be formatted as key=value")
+
+// subnetAllowlist : the subnets the connector may route, by id or by tag
+type subnetAllowlist struct {
+	ids map[string]bool
+	tag *ec2.Tag
+}
+
+// allowedSubnets restricts the routed networks an event can modify, so a
+// bad event can't rewrite unrelated route tables. When nil every network
+// can be routed.
+var allowedSubnets *subnetAllowlist
+
+// parseSubnetAllowlist parses the comma separated subnet ids and the
+// key=value tag of the subnets the connector may route. It returns no
+// allowlist when neither is configured.
+func parseSubnetAllowlist(ids, tag string) (*subnetAllowlist, error) {
+	if ids == "" && tag == "" {
+		return nil, nil
+	}
+
+	a := subnetAllowlist{ids: make(map[string]bool)}
+	for _, id := range strings.Split(ids, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			a.ids[id] = true
+		}
+	}
+
+	t, err := parseScopeTag(tag)
+	if err != nil {
+		return nil, ErrAllowedSubnetTagInvalid
+	}
+	a.tag = t
+
+	return &a, nil
+}
+
+// allows checks if a subnet is on the allowlist
+func (a *subnetAllowlist) allows(s *ec2.Subnet) bool {
+	if a.ids[aws.StringValue(s.SubnetId)] {
+		return true
+	}
+
+	return a.tag != nil && hasTag(s.Tags, aws.StringValue(a.tag.Key), aws.StringValue(a.tag.Value))
+}
+
+// checkAllowedSubnets rejects the event when any of its routed networks
+// is outside the allowed subnets
+func (ev *Event) checkAllowedSubnets(svc ec2iface.EC2API) error {
+	if allowedSubnets == nil || len(ev.RoutedNetworkAWSIDs) == 0 {
+		return nil
+	}
+
+	allowed := make(map[string]bool)
+	for _, id := range ev.RoutedNetworkAWSIDs {
+		allowed[id] = allowedSubnets.ids[id]
+	}
+
+	// subnets are only described when their tags are needed
+	if allowedSubnets.tag != nil {
+		resp, err := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{
+			SubnetIds: aws.StringSlice(ev.RoutedNetworkAWSIDs),
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, s := range resp.Subnets {
+			allowed[aws.StringValue(s.SubnetId)] = allowedSubnets.allows(s)
+		}
+	}
+
+	for _, id := range ev.RoutedNetworkAWSIDs {
+		if !allowed[id] {
+			return fmt.Errorf("Routed network %s is not on the allowed subnets", id)
+		}
+	}
+
+	return nil
+}
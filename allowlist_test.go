@@ -0,0 +1,110 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseSubnetAllowlist(t *testing.T) {
+	Convey("Given allowed subnet settings", t, func() {
+		Convey("When none is configured", func() {
+			a, err := parseSubnetAllowlist("", "")
+
+			Convey("It should allow every subnet", func() {
+				So(err, ShouldBeNil)
+				So(a, ShouldBeNil)
+			})
+		})
+
+		Convey("When subnet ids and a tag are configured", func() {
+			a, err := parseSubnetAllowlist("subnet-00000001, subnet-00000002", "nat=allowed")
+
+			Convey("It should allow them", func() {
+				So(err, ShouldBeNil)
+				So(a.ids, ShouldResemble, map[string]bool{"subnet-00000001": true, "subnet-00000002": true})
+				So(aws.StringValue(a.tag.Key), ShouldEqual, "nat")
+				So(aws.StringValue(a.tag.Value), ShouldEqual, "allowed")
+			})
+		})
+
+		Convey("When the tag has no value", func() {
+			_, err := parseSubnetAllowlist("", "nat")
+
+			Convey("It should error", func() {
+				So(err, ShouldEqual, ErrAllowedSubnetTagInvalid)
+			})
+		})
+	})
+}
+
+func TestAllowedSubnets(t *testing.T) {
+	Convey("Given the connector may only route some subnets", t, func() {
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+
+		update := func(routed ...string) error {
+			ev := testEvent
+			ev.RoutedNetworkAWSIDs = routed
+			data, _ := json.Marshal(ev)
+			e := New("nat.update.aws", data)
+			e.Process()
+			return e.Update()
+		}
+
+		Convey("When they are allowed by id", func() {
+			allowedSubnets, _ = parseSubnetAllowlist("subnet-00000001", "")
+
+			Convey("It should route an allowed subnet", func() {
+				So(update("subnet-00000001"), ShouldBeNil)
+				So(f.Count("CreateRoute"), ShouldEqual, 1)
+			})
+
+			Convey("It should reject any other without changing routes", func() {
+				err := update("subnet-00000001", "subnet-00000002")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Routed network subnet-00000002 is not on the allowed subnets")
+				So(f.Count("CreateRouteTable"), ShouldEqual, 0)
+				So(f.Count("CreateRoute"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When they are allowed by tag", func() {
+			allowedSubnets, _ = parseSubnetAllowlist("", "nat=allowed")
+			f.subnets["subnet-00000001"] = &ec2.Subnet{
+				SubnetId: aws.String("subnet-00000001"),
+				VpcId:    aws.String(testEvent.VPCID),
+				Tags: []*ec2.Tag{
+					&ec2.Tag{Key: aws.String("nat"), Value: aws.String("allowed")},
+				},
+			}
+			f.subnets["subnet-00000002"] = &ec2.Subnet{
+				SubnetId: aws.String("subnet-00000002"),
+				VpcId:    aws.String(testEvent.VPCID),
+			}
+
+			Convey("It should route a tagged subnet", func() {
+				So(update("subnet-00000001"), ShouldBeNil)
+			})
+
+			Convey("It should reject an untagged one", func() {
+				err := update("subnet-00000002")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Routed network subnet-00000002 is not on the allowed subnets")
+			})
+		})
+
+		Reset(func() {
+			allowedSubnets = nil
+		})
+	})
+}
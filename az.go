@@ -0,0 +1,100 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// reportAvailabilityZones reports the availability zones of the routed
+// networks once they are routed, only warning when aws doesn't describe
+// them, as the nat gateway works regardless
+func (ev *Event) reportAvailabilityZones(svc ec2iface.EC2API) {
+	err := ev.describeAvailabilityZones(svc)
+	if err != nil {
+		ev.warn("Could not report the availability zones of the routed networks: " + err.Error())
+	}
+}
+
+// describeAvailabilityZones reports which availability zone each routed
+// network is on, warning when the networks are unevenly spread across
+// zones, as losing the most loaded zone then affects more networks. It
+// also reports the networks routed across zones, as the nat gateway is on
+// the zone of its network and cross zone traffic is charged.
+func (ev *Event) describeAvailabilityZones(svc ec2iface.EC2API) error {
+	if len(ev.RoutedNetworkAWSIDs) == 0 {
+		return nil
+	}
+
+	routed := make(map[string]bool)
+	for _, id := range ev.RoutedNetworkAWSIDs {
+		routed[id] = true
+	}
+
+	ids := ev.RoutedNetworkAWSIDs
+	if ev.PublicNetworkAWSID != "" && !routed[ev.PublicNetworkAWSID] {
+		ids = append(append([]string{}, ids...), ev.PublicNetworkAWSID)
+	}
+
+	req := ec2.DescribeSubnetsInput{
+		SubnetIds: aws.StringSlice(ids),
+	}
+
+	resp, err := svc.DescribeSubnets(&req)
+	if err != nil {
+		return err
+	}
+
+	var gatewayAZ string
+	ev.AvailabilityZones = make(map[string][]string)
+	for _, s := range resp.Subnets {
+		id := aws.StringValue(s.SubnetId)
+		az := aws.StringValue(s.AvailabilityZone)
+		if id == ev.PublicNetworkAWSID {
+			gatewayAZ = az
+		}
+		if routed[id] {
+			ev.AvailabilityZones[az] = append(ev.AvailabilityZones[az], id)
+		}
+	}
+
+	ev.CrossAZNetworks = nil
+	for az, subnets := range ev.AvailabilityZones {
+		if gatewayAZ != "" && az != gatewayAZ {
+			ev.CrossAZNetworks = append(ev.CrossAZNetworks, subnets...)
+		}
+	}
+
+	var zones []string
+	min, max := -1, 0
+	for az, subnets := range ev.AvailabilityZones {
+		sort.Strings(subnets)
+		zones = append(zones, fmt.Sprintf("%s: %d", az, len(subnets)))
+		if min < 0 || len(subnets) < min {
+			min = len(subnets)
+		}
+		if len(subnets) > max {
+			max = len(subnets)
+		}
+	}
+
+	if max > min {
+		sort.Strings(zones)
+		ev.warn("Routed networks are unevenly spread across availability zones (" + strings.Join(zones, ", ") + ")")
+	}
+
+	if len(ev.CrossAZNetworks) > 0 {
+		sort.Strings(ev.CrossAZNetworks)
+		ev.warn("Routed networks " + strings.Join(ev.CrossAZNetworks, ", ") + " are not on the nat gateway availability zone " + gatewayAZ + ", their traffic is charged as cross zone")
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// zonedSubnets places the given subnets on availability zones
+func zonedSubnets(f *fakeEC2, zones map[string]string) {
+	for id, az := range zones {
+		f.subnets[id] = &ec2.Subnet{
+			SubnetId:         aws.String(id),
+			VpcId:            aws.String(testEvent.VPCID),
+			State:            aws.String(ec2.SubnetStateAvailable),
+			AvailabilityZone: aws.String(az),
+		}
+	}
+}
+
+func TestAvailabilityZoneLayout(t *testing.T) {
+	Convey("Given an update event routing three networks", t, func() {
+		log.SetOutput(ioutil.Discard)
+		ev := testEvent
+		ev.RoutedNetworkAWSIDs = []string{"subnet-a1", "subnet-b1", "subnet-a2"}
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+
+		e := New("nat.update.aws", data)
+		e.Process()
+
+		Convey("When the networks are evenly spread", func() {
+			zonedSubnets(f, map[string]string{
+				"subnet-a1": "eu-west-1a",
+				"subnet-b1": "eu-west-1b",
+				"subnet-a2": "eu-west-1c",
+			})
+			err := e.Update()
+
+			Convey("It should report the layout without an uneven spread warning", func() {
+				So(err, ShouldBeNil)
+				So(e.AvailabilityZones, ShouldResemble, map[string][]string{
+					"eu-west-1a": {"subnet-a1"},
+					"eu-west-1b": {"subnet-b1"},
+					"eu-west-1c": {"subnet-a2"},
+				})
+				So(e.Warnings, ShouldResemble, []string{
+					"Routed networks subnet-a2, subnet-b1 are not on the nat gateway availability zone eu-west-1a, their traffic is charged as cross zone",
+				})
+			})
+		})
+
+		Convey("When the networks are unevenly spread", func() {
+			zonedSubnets(f, map[string]string{
+				"subnet-a1": "eu-west-1a",
+				"subnet-b1": "eu-west-1b",
+				"subnet-a2": "eu-west-1a",
+			})
+			err := e.Update()
+
+			Convey("It should report the layout and warn", func() {
+				So(err, ShouldBeNil)
+				So(e.AvailabilityZones, ShouldResemble, map[string][]string{
+					"eu-west-1a": {"subnet-a1", "subnet-a2"},
+					"eu-west-1b": {"subnet-b1"},
+				})
+				So(e.Warnings, ShouldResemble, []string{
+					"Routed networks are unevenly spread across availability zones (eu-west-1a: 2, eu-west-1b: 1)",
+					"Routed networks subnet-b1 are not on the nat gateway availability zone eu-west-1a, their traffic is charged as cross zone",
+				})
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stdout)
+		})
+	})
+}
+
+func TestCrossAvailabilityZoneNetworks(t *testing.T) {
+	Convey("Given an update event routing networks on several zones", t, func() {
+		log.SetOutput(ioutil.Discard)
+		ev := testEvent
+		ev.RoutedNetworkAWSIDs = []string{"subnet-a1", "subnet-b1", "subnet-b2"}
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+
+		zonedSubnets(f, map[string]string{
+			"subnet-a1": "eu-west-1a",
+			"subnet-b1": "eu-west-1b",
+			"subnet-b2": "eu-west-1b",
+		})
+
+		e := New("nat.update.aws", data)
+		e.Process()
+
+		Convey("When the nat gateway is on one of those zones", func() {
+			zonedSubnets(f, map[string]string{testEvent.PublicNetworkAWSID: "eu-west-1b"})
+			err := e.Update()
+
+			Convey("It should report the networks on the other zones", func() {
+				So(err, ShouldBeNil)
+				So(e.CrossAZNetworks, ShouldResemble, []string{"subnet-a1"})
+				So(e.AvailabilityZones, ShouldNotContainKey, "")
+				So(e.Warnings, ShouldContain, "Routed networks subnet-a1 are not on the nat gateway availability zone eu-west-1b, their traffic is charged as cross zone")
+			})
+		})
+
+		Convey("When the nat gateway is on another zone", func() {
+			zonedSubnets(f, map[string]string{testEvent.PublicNetworkAWSID: "eu-west-1c"})
+			err := e.Update()
+
+			Convey("It should report every routed network", func() {
+				So(err, ShouldBeNil)
+				So(e.CrossAZNetworks, ShouldResemble, []string{"subnet-a1", "subnet-b1", "subnet-b2"})
+				So(e.AvailabilityZones, ShouldNotContainKey, "eu-west-1c")
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stdout)
+		})
+	})
+}
+
+func TestAvailabilityZoneReportFailure(t *testing.T) {
+	Convey("Given a create event", t, func() {
+		log.SetOutput(ioutil.Discard)
+		ev := testEvent
+		ev.NatGatewayAWSID = ""
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		useFake(f)
+
+		Convey("When aws doesn't describe the zones of its networks", func() {
+			// the zones are described along with the nat gateway network
+			f.hooks["DescribeSubnets"] = func(input interface{}) (interface{}, error) {
+				in := input.(*ec2.DescribeSubnetsInput)
+				if len(in.SubnetIds) == 2 {
+					return nil, awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil)
+				}
+
+				out := &ec2.DescribeSubnetsOutput{}
+				for _, id := range in.SubnetIds {
+					out.Subnets = append(out.Subnets, f.subnet(aws.StringValue(id)))
+				}
+				return out, nil
+			}
+
+			e := New("nat.create.aws", data)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should create the nat gateway and warn", func() {
+				So(err, ShouldBeNil)
+				So(e.NatGatewayAWSID, ShouldNotBeEmpty)
+				So(e.AvailabilityZones, ShouldBeNil)
+				So(e.Warnings, ShouldContain, "Could not report the availability zones of the routed networks: UnauthorizedOperation: You are not authorized to perform this operation.")
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stdout)
+		})
+	})
+}
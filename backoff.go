@@ -0,0 +1,123 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// sleep pauses the current operation, tests replace it to observe waits
+var sleep = time.Sleep
+
+// deletePollMaxInterval bounds how slow polling gets while throttled
+var deletePollMaxInterval = time.Minute
+
+// defaultDeleteGracePeriod is how long a deleting nat gateway is left
+// before it is first checked, unless configured otherwise
+const defaultDeleteGracePeriod = time.Second * 5
+
+// deleteWaitRetries bounds how many transient errors waiting for a nat
+// gateway to be deleted are retried
+var deleteWaitRetries = defaultDeleteWaitRetries
+
+// defaultDeleteWaitRetries is how many transient errors are retried while
+// waiting for a deletion, unless configured otherwise
+const defaultDeleteWaitRetries = 3
+
+// transientCodes are the aws error codes of failures that may not happen
+// again, as when aws or the network to it fails briefly
+var transientCodes = map[string]bool{
+	"RequestError":       true,
+	"InternalError":      true,
+	"ServiceUnavailable": true,
+	"Unavailable":        true,
+}
+
+// isTransient checks if an aws request failed in a way worth retrying
+func isTransient(err error) bool {
+	if errorClass(err) == errorClassServer {
+		return true
+	}
+
+	aerr, ok := err.(awserr.Error)
+
+	return ok && transientCodes[aerr.Code()]
+}
+
+// throttleCodes are the aws error codes returned when throttling requests
+var throttleCodes = map[string]bool{
+	"Throttling":               true,
+	"ThrottlingException":      true,
+	"RequestLimitExceeded":     true,
+	"TooManyRequestsException": true,
+}
+
+// isThrottled checks if aws rejected a request due to rate limits
+func isThrottled(err error) bool {
+	aerr, ok := err.(awserr.Error)
+
+	return ok && throttleCodes[aerr.Code()]
+}
+
+// backoff paces a sequence of aws calls, slowing all of them down once
+// any of them gets throttled
+type backoff struct {
+	interval time.Duration
+	max      time.Duration
+}
+
+func newBackoff(interval, max time.Duration) *backoff {
+	return &backoff{interval: interval, max: max}
+}
+
+// throttled doubles the interval, up to the maximum
+func (b *backoff) throttled(err error) {
+	b.interval = b.interval * 2
+	if b.interval > b.max {
+		b.interval = b.max
+	}
+
+	log.Printf("Throttled by aws (%s), waiting %s between requests", err.Error(), b.interval)
+}
+
+// wait pauses for the current interval
+func (b *backoff) wait() {
+	sleep(b.interval)
+}
+
+// deleteGrace parses the configured delete grace period, like 10s, zero
+// meaning deleting nat gateways are checked right away
+func deleteGrace(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultDeleteGracePeriod, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err == nil && d < 0 {
+		err = fmt.Errorf("Delete grace period %s is negative", s)
+	}
+
+	return d, err
+}
+
+// deleteWaitRetryCount parses the configured number of transient errors
+// retried while waiting for a deletion
+func deleteWaitRetryCount(s string) (int, error) {
+	if s == "" {
+		return defaultDeleteWaitRetries, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err == nil && n < 0 {
+		err = fmt.Errorf("Delete wait retries %s is negative", s)
+	}
+
+	return n, err
+}
@@ -0,0 +1,223 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeleteThrottling(t *testing.T) {
+	Convey("Given a delete event", t, func() {
+		log.SetOutput(ioutil.Discard)
+		deletePollInterval = time.Second
+		deletePollMaxInterval = time.Second * 3
+		deleteGracePeriod = 0
+
+		var waits []time.Duration
+		sleep = func(d time.Duration) {
+			waits = append(waits, d)
+		}
+
+		data, _ := json.Marshal(testEvent)
+		f := newFakeEC2()
+		useFake(f)
+
+		e := New("nat.delete.aws", data)
+		e.Process()
+
+		Convey("When describing the nat gateway is throttled", func() {
+			polls := 0
+			f.hooks["DescribeNatGateways"] = func(input interface{}) (interface{}, error) {
+				polls++
+				if polls <= 3 {
+					return nil, awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil)
+				}
+				state := ec2.NatGatewayStateDeleting
+				if polls > 4 {
+					state = ec2.NatGatewayStateDeleted
+				}
+				return &ec2.DescribeNatGatewaysOutput{NatGateways: []*ec2.NatGateway{
+					&ec2.NatGateway{NatGatewayId: aws.String(testEvent.NatGatewayAWSID), State: aws.String(state)},
+				}}, nil
+			}
+			err := e.Delete()
+
+			Convey("It should slow down polling up to the maximum interval", func() {
+				So(err, ShouldBeNil)
+				So(waits, ShouldResemble, []time.Duration{
+					time.Second * 2,
+					time.Second * 3,
+					time.Second * 3,
+					time.Second * 3,
+				})
+			})
+		})
+
+		Convey("When deleting the nat gateway is throttled", func() {
+			deletes := 0
+			f.hooks["DeleteNatGateway"] = func(input interface{}) (interface{}, error) {
+				deletes++
+				if deletes == 1 {
+					return nil, awserr.New("Throttling", "Rate exceeded", nil)
+				}
+				return &ec2.DeleteNatGatewayOutput{}, nil
+			}
+			f.natGateways = append(f.natGateways, &ec2.NatGateway{
+				NatGatewayId: aws.String(testEvent.NatGatewayAWSID),
+				State:        aws.String(ec2.NatGatewayStateDeleted),
+			})
+			err := e.Delete()
+
+			Convey("It should retry it and keep the slower pace", func() {
+				So(err, ShouldBeNil)
+				So(deletes, ShouldEqual, 2)
+				So(waits, ShouldResemble, []time.Duration{time.Second * 2})
+			})
+		})
+
+		Convey("When deleting with a grace period", func() {
+			deleteGracePeriod = time.Second * 5
+
+			var order []string
+			sleep = func(d time.Duration) {
+				order = append(order, "sleep "+d.String())
+			}
+			f.hooks["DescribeNatGateways"] = func(input interface{}) (interface{}, error) {
+				order = append(order, "describe")
+				return &ec2.DescribeNatGatewaysOutput{NatGateways: []*ec2.NatGateway{
+					&ec2.NatGateway{NatGatewayId: aws.String(testEvent.NatGatewayAWSID), State: aws.String(ec2.NatGatewayStateDeleted)},
+				}}, nil
+			}
+			err := e.Delete()
+
+			Convey("It should wait for it before the first poll", func() {
+				So(err, ShouldBeNil)
+				So(order, ShouldResemble, []string{"sleep 5s", "describe"})
+			})
+		})
+
+		Convey("When waiting for the deletion fails transiently", func() {
+			polls := 0
+			f.hooks["DescribeNatGateways"] = func(input interface{}) (interface{}, error) {
+				polls++
+				if polls == 1 {
+					return nil, awserr.NewRequestFailure(awserr.New("InternalError", "An internal error has occurred", nil), 500, "req")
+				}
+				return &ec2.DescribeNatGatewaysOutput{NatGateways: []*ec2.NatGateway{
+					&ec2.NatGateway{NatGatewayId: aws.String(testEvent.NatGatewayAWSID), State: aws.String(ec2.NatGatewayStateDeleted)},
+				}}, nil
+			}
+			err := e.Delete()
+
+			Convey("It should retry the wait", func() {
+				So(err, ShouldBeNil)
+				So(polls, ShouldEqual, 2)
+			})
+		})
+
+		Convey("When waiting for the deletion keeps failing transiently", func() {
+			deleteWaitRetries = 2
+			f.errors["DescribeNatGateways"] = awserr.New("RequestError", "send request failed", nil)
+			err := e.Delete()
+
+			Convey("It should give up once the retries are spent", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "RequestError")
+				So(f.Count("DescribeNatGateways"), ShouldEqual, 3)
+			})
+		})
+
+		Convey("When describing the nat gateway fails otherwise", func() {
+			f.errors["DescribeNatGateways"] = awserr.New("InvalidNatGatewayID.NotFound", "not found", nil)
+			err := e.Delete()
+
+			Convey("It should not retry", func() {
+				So(err, ShouldNotBeNil)
+				So(len(waits), ShouldEqual, 0)
+			})
+		})
+
+		Reset(func() {
+			sleep = time.Sleep
+			deletePollInterval = time.Millisecond
+			deleteGracePeriod = 0
+			deleteWaitRetries = defaultDeleteWaitRetries
+			log.SetOutput(os.Stdout)
+		})
+	})
+}
+
+func TestDeleteGrace(t *testing.T) {
+	Convey("Given a configured delete grace period", t, func() {
+		Convey("When it isn't set", func() {
+			d, err := deleteGrace("")
+
+			Convey("It should default to a few seconds", func() {
+				So(err, ShouldBeNil)
+				So(d, ShouldEqual, defaultDeleteGracePeriod)
+			})
+		})
+
+		Convey("When it is a duration", func() {
+			d, err := deleteGrace("10s")
+
+			Convey("It should use it", func() {
+				So(err, ShouldBeNil)
+				So(d, ShouldEqual, time.Second*10)
+			})
+		})
+
+		Convey("When it is negative", func() {
+			_, err := deleteGrace("-1s")
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Delete grace period -1s is negative")
+			})
+		})
+	})
+}
+
+func TestDeleteWaitRetryCount(t *testing.T) {
+	Convey("Given configured delete wait retries", t, func() {
+		Convey("When they aren't set", func() {
+			n, err := deleteWaitRetryCount("")
+
+			Convey("It should default to a few", func() {
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, defaultDeleteWaitRetries)
+			})
+		})
+
+		Convey("When they are a number", func() {
+			n, err := deleteWaitRetryCount("5")
+
+			Convey("It should use it", func() {
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 5)
+			})
+		})
+
+		Convey("When they are negative", func() {
+			_, err := deleteWaitRetryCount("-1")
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Delete wait retries -1 is negative")
+			})
+		})
+	})
+}
@@ -0,0 +1,151 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// maxConcurrentDeletes bounds how many nat gateways of a batch are
+// deleted at once
+var maxConcurrentDeletes = 4
+
+// GatewayDeletion : the outcome of deleting one of the nat gateways of a
+// batch delete
+type GatewayDeletion struct {
+	NatGatewayAWSID        string        `json:"nat_gateway_aws_id"`
+	NatGatewayAllocationID string        `json:"nat_gateway_allocation_id,omitempty"`
+	RouteChanges           []RouteChange `json:"route_changes,omitempty"`
+	Warnings               []string      `json:"warnings,omitempty"`
+	ErrorMessage           string        `json:"error_message,omitempty"`
+	ErrorClass             string        `json:"error_class,omitempty"`
+}
+
+// batchNatGatewayIDs returns the nat gateways a batch delete targets,
+// including the single one of the event
+func (ev *Event) batchNatGatewayIDs() []string {
+	var ids []string
+
+	seen := make(map[string]bool)
+	for _, id := range append([]string{ev.NatGatewayAWSID}, ev.NatGatewayAWSIDs...) {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// forNatGateway returns a copy of the event deleting a single nat gateway
+// of a batch
+func (ev *Event) forNatGateway(id string) *Event {
+	d := *ev
+	d.NatGatewayAWSID = id
+	d.NatGatewayAWSIDs = nil
+	d.NatGatewayAllocationID = ""
+	d.NatGatewayAllocationIP = ""
+	d.FlowLogIDs = nil
+	d.RouteChanges = nil
+	d.Warnings = nil
+
+	return &d
+}
+
+// deleteNatGateways deletes every nat gateway of a batch concurrently,
+// reporting the outcome of each. A failing nat gateway doesn't stop the
+// others from being deleted.
+func (ev *Event) deleteNatGateways(svc ec2iface.EC2API) error {
+	ids := ev.batchNatGatewayIDs()
+
+	// every nat gateway is dry run before any of them is deleted
+	if ev.CheckPermissions {
+		for _, id := range ids {
+			err := ev.forNatGateway(id).checkPermissions(svc, "delete")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	results := make([]GatewayDeletion, len(ids))
+	sem := newSlots(maxConcurrentDeletes)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var failed []string
+
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+
+			sem.acquire()
+			defer sem.release()
+
+			d := ev.forNatGateway(id)
+			err := recovered(func() error {
+				return d.deleteBatchedNatGateway(svc)
+			})
+
+			res := GatewayDeletion{
+				NatGatewayAWSID:        id,
+				NatGatewayAllocationID: d.NatGatewayAllocationID,
+				RouteChanges:           d.RouteChanges,
+				Warnings:               d.Warnings,
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				res.ErrorMessage = err.Error()
+				res.ErrorClass = errorClass(err)
+				failed = append(failed, id+": "+err.Error())
+			}
+			results[i] = res
+		}(i, id)
+	}
+
+	wg.Wait()
+
+	ev.NatGatewayDeletions = results
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return errors.New("Operation failed on nat gateways " + strings.Join(failed, ", "))
+	}
+
+	// flow logs aren't tracked per nat gateway, so they go once all are
+	if ev.Teardown {
+		return ev.deleteFlowLogs(svc)
+	}
+
+	return nil
+}
+
+// deleteBatchedNatGateway deletes a nat gateway of a batch, which doesn't
+// carry its elastic ip, so it is looked up to release it on teardown
+func (ev *Event) deleteBatchedNatGateway(svc ec2iface.EC2API) error {
+	if ev.Teardown {
+		gw, err := ev.natGatewayByID(svc, ev.NatGatewayAWSID)
+		if err != nil {
+			return err
+		}
+
+		for _, addr := range gw.NatGatewayAddresses {
+			ev.NatGatewayAllocationID = aws.StringValue(addr.AllocationId)
+			break
+		}
+	}
+
+	return ev.deleteNatGateway(svc)
+}
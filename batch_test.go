@@ -0,0 +1,160 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBatchDelete(t *testing.T) {
+	deletePollInterval = time.Millisecond
+	deleteGracePeriod = 0
+
+	Convey("Given nat gateways on several availability zones", t, func() {
+		f := newFakeEC2()
+		useFake(f)
+
+		for _, suffix := range []string{"a", "b", "c"} {
+			f.natGateways = append(f.natGateways, &ec2.NatGateway{
+				NatGatewayId: aws.String("nat-" + suffix),
+				SubnetId:     aws.String("subnet-" + suffix),
+				State:        aws.String(ec2.NatGatewayStateAvailable),
+				NatGatewayAddresses: []*ec2.NatGatewayAddress{
+					{AllocationId: aws.String("eipalloc-" + suffix), PublicIp: aws.String("52.0.0.1")},
+				},
+			})
+		}
+
+		var mu sync.Mutex
+		var released []string
+		f.hooks["ReleaseAddress"] = func(input interface{}) (interface{}, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			released = append(released, aws.StringValue(input.(*ec2.ReleaseAddressInput).AllocationId))
+			return &ec2.ReleaseAddressOutput{}, nil
+		}
+
+		// the second nat gateway can't be deleted
+		f.hooks["DeleteNatGateway"] = func(input interface{}) (interface{}, error) {
+			id := aws.StringValue(input.(*ec2.DeleteNatGatewayInput).NatGatewayId)
+			if id == "nat-b" {
+				return nil, awserr.New("InvalidParameter", "The nat gateway can't be deleted.", nil)
+			}
+
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			for _, gw := range f.natGateways {
+				if aws.StringValue(gw.NatGatewayId) == id {
+					gw.State = aws.String(ec2.NatGatewayStateDeleted)
+				}
+			}
+			return &ec2.DeleteNatGatewayOutput{NatGatewayId: aws.String(id)}, nil
+		}
+
+		del := func(ev Event) (Event, error) {
+			data, _ := json.Marshal(ev)
+			e := New("nat.delete.aws", data)
+			e.Process()
+			if err := e.Validate(); err != nil {
+				return e, err
+			}
+			return e, e.Execute("delete")
+		}
+
+		Convey("When deleting them all with teardown", func() {
+			ev := testEvent
+			ev.NatGatewayAWSID = ""
+			ev.NatGatewayAWSIDs = []string{"nat-a", "nat-b", "nat-c"}
+			ev.Teardown = true
+			e, err := del(ev)
+
+			Convey("It should delete the others and report each", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Operation failed on nat gateways nat-b: InvalidParameter: The nat gateway can't be deleted.")
+
+				So(len(e.NatGatewayDeletions), ShouldEqual, 3)
+				So(e.NatGatewayDeletions[0].NatGatewayAWSID, ShouldEqual, "nat-a")
+				So(e.NatGatewayDeletions[0].ErrorMessage, ShouldBeEmpty)
+				So(e.NatGatewayDeletions[1].NatGatewayAWSID, ShouldEqual, "nat-b")
+				So(e.NatGatewayDeletions[1].ErrorMessage, ShouldContainSubstring, "InvalidParameter")
+				So(e.NatGatewayDeletions[1].ErrorClass, ShouldBeEmpty)
+				So(e.NatGatewayDeletions[2].NatGatewayAWSID, ShouldEqual, "nat-c")
+				So(e.NatGatewayDeletions[2].ErrorMessage, ShouldBeEmpty)
+
+				sort.Strings(released)
+				So(released, ShouldResemble, []string{"eipalloc-a", "eipalloc-c"})
+				So(aws.StringValue(f.natGateways[0].State), ShouldEqual, ec2.NatGatewayStateDeleted)
+				So(aws.StringValue(f.natGateways[1].State), ShouldEqual, ec2.NatGatewayStateAvailable)
+				So(aws.StringValue(f.natGateways[2].State), ShouldEqual, ec2.NatGatewayStateDeleted)
+			})
+		})
+
+		Convey("When the single nat gateway is also given", func() {
+			ev := testEvent
+			ev.NatGatewayAWSID = "nat-a"
+			ev.NatGatewayAWSIDs = []string{"nat-a", "nat-c"}
+			e, err := del(ev)
+
+			Convey("It should delete each once", func() {
+				So(err, ShouldBeNil)
+				So(len(e.NatGatewayDeletions), ShouldEqual, 2)
+				So(f.Count("DeleteNatGateway"), ShouldEqual, 2)
+				So(released, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When checking the permissions of the batch", func() {
+			var dryRuns []string
+			f.hooks["DeleteNatGateway"] = func(input interface{}) (interface{}, error) {
+				in := input.(*ec2.DeleteNatGatewayInput)
+				if aws.BoolValue(in.DryRun) {
+					dryRuns = append(dryRuns, aws.StringValue(in.NatGatewayId))
+					return nil, dryRunOperation()
+				}
+
+				f.mu.Lock()
+				defer f.mu.Unlock()
+				for _, gw := range f.natGateways {
+					if aws.StringValue(gw.NatGatewayId) == aws.StringValue(in.NatGatewayId) {
+						gw.State = aws.String(ec2.NatGatewayStateDeleted)
+					}
+				}
+				return &ec2.DeleteNatGatewayOutput{NatGatewayId: in.NatGatewayId}, nil
+			}
+
+			ev := testEvent
+			ev.NatGatewayAWSID = ""
+			ev.NatGatewayAWSIDs = []string{"nat-a", "nat-c"}
+			ev.CheckPermissions = true
+			e, err := del(ev)
+
+			Convey("It should dry run the deletion of each nat gateway", func() {
+				So(err, ShouldBeNil)
+				So(dryRuns, ShouldResemble, []string{"nat-a", "nat-c"})
+				So(len(e.NatGatewayDeletions), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When no nat gateway is given", func() {
+			ev := testEvent
+			ev.NatGatewayAWSID = ""
+			_, err := del(ev)
+
+			Convey("It should not validate", func() {
+				So(err, ShouldEqual, ErrNatGatewayIDInvalid)
+			})
+		})
+	})
+}
@@ -0,0 +1,288 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// APICallCount : the aws api calls made by an operation
+type APICallCount struct {
+	Read     int `json:"read"`
+	Mutating int `json:"mutating"`
+}
+
+// countingEC2 counts the aws api calls of an operation and refuses any
+// call once the budget, if any, is spent, so a runaway event can't flood
+// the account. Every ec2 call the connector makes has to go through one of
+// its methods, a waiter counts as a single call.
+type countingEC2 struct {
+	ec2iface.EC2API
+
+	mu        sync.Mutex
+	budget    int
+	calls     APICallCount
+	latencies []APICallLatency
+}
+
+// client returns the ec2 client of an event, counting its calls and
+// limited to the event api call budget when it has one. In latency debug
+// mode it also times them.
+func (ev *Event) client() (ec2iface.EC2API, error) {
+	svc, err := ec2Client(ev)
+	if err != nil {
+		return nil, err
+	}
+
+	ev.calls = &countingEC2{budget: ev.APICallBudget}
+	if debugLatency {
+		svc = ev.calls.timed(svc)
+	}
+	ev.calls.EC2API = svc
+
+	return ev.calls, nil
+}
+
+// spend counts a call, failing when the budget is exceeded
+func (b *countingEC2) spend(mutating bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.budget > 0 && b.calls.Read+b.calls.Mutating >= b.budget {
+		return fmt.Errorf("AWS API call budget of %d calls exceeded", b.budget)
+	}
+
+	if mutating {
+		b.calls.Mutating++
+	} else {
+		b.calls.Read++
+	}
+
+	return nil
+}
+
+// count returns the calls made so far
+func (b *countingEC2) count() APICallCount {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.calls
+}
+
+func (b *countingEC2) AllocateAddress(in *ec2.AllocateAddressInput) (*ec2.AllocateAddressOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.AllocateAddress(in)
+}
+
+func (b *countingEC2) ReleaseAddress(in *ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.ReleaseAddress(in)
+}
+
+func (b *countingEC2) DescribeAddresses(in *ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+	if err := b.spend(false); err != nil {
+		return nil, err
+	}
+	describeSlots.acquire()
+	defer describeSlots.release()
+	return b.EC2API.DescribeAddresses(in)
+}
+
+func (b *countingEC2) DescribeByoipCidrs(in *ec2.DescribeByoipCidrsInput) (*ec2.DescribeByoipCidrsOutput, error) {
+	if err := b.spend(false); err != nil {
+		return nil, err
+	}
+	describeSlots.acquire()
+	defer describeSlots.release()
+	return b.EC2API.DescribeByoipCidrs(in)
+}
+
+func (b *countingEC2) DescribeInternetGateways(in *ec2.DescribeInternetGatewaysInput) (*ec2.DescribeInternetGatewaysOutput, error) {
+	if err := b.spend(false); err != nil {
+		return nil, err
+	}
+	describeSlots.acquire()
+	defer describeSlots.release()
+	return b.EC2API.DescribeInternetGateways(in)
+}
+
+func (b *countingEC2) CreateInternetGateway(in *ec2.CreateInternetGatewayInput) (*ec2.CreateInternetGatewayOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.CreateInternetGateway(in)
+}
+
+func (b *countingEC2) AttachInternetGateway(in *ec2.AttachInternetGatewayInput) (*ec2.AttachInternetGatewayOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.AttachInternetGateway(in)
+}
+
+func (b *countingEC2) DetachInternetGateway(in *ec2.DetachInternetGatewayInput) (*ec2.DetachInternetGatewayOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.DetachInternetGateway(in)
+}
+
+func (b *countingEC2) DeleteInternetGateway(in *ec2.DeleteInternetGatewayInput) (*ec2.DeleteInternetGatewayOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.DeleteInternetGateway(in)
+}
+
+func (b *countingEC2) CreateNatGateway(in *ec2.CreateNatGatewayInput) (*ec2.CreateNatGatewayOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.CreateNatGateway(in)
+}
+
+func (b *countingEC2) WaitUntilNatGatewayAvailable(in *ec2.DescribeNatGatewaysInput) error {
+	if err := b.spend(false); err != nil {
+		return err
+	}
+	return b.EC2API.WaitUntilNatGatewayAvailable(in)
+}
+
+func (b *countingEC2) DescribeNatGateways(in *ec2.DescribeNatGatewaysInput) (*ec2.DescribeNatGatewaysOutput, error) {
+	if err := b.spend(false); err != nil {
+		return nil, err
+	}
+	describeSlots.acquire()
+	defer describeSlots.release()
+	return b.EC2API.DescribeNatGateways(in)
+}
+
+func (b *countingEC2) DeleteNatGateway(in *ec2.DeleteNatGatewayInput) (*ec2.DeleteNatGatewayOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.DeleteNatGateway(in)
+}
+
+func (b *countingEC2) DescribeRouteTables(in *ec2.DescribeRouteTablesInput) (*ec2.DescribeRouteTablesOutput, error) {
+	if err := b.spend(false); err != nil {
+		return nil, err
+	}
+	describeSlots.acquire()
+	defer describeSlots.release()
+	return b.EC2API.DescribeRouteTables(in)
+}
+
+func (b *countingEC2) CreateRouteTable(in *ec2.CreateRouteTableInput) (*ec2.CreateRouteTableOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.CreateRouteTable(in)
+}
+
+func (b *countingEC2) AssociateRouteTable(in *ec2.AssociateRouteTableInput) (*ec2.AssociateRouteTableOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.AssociateRouteTable(in)
+}
+
+func (b *countingEC2) ReplaceRouteTableAssociation(in *ec2.ReplaceRouteTableAssociationInput) (*ec2.ReplaceRouteTableAssociationOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.ReplaceRouteTableAssociation(in)
+}
+
+func (b *countingEC2) CreateRoute(in *ec2.CreateRouteInput) (*ec2.CreateRouteOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.CreateRoute(in)
+}
+
+func (b *countingEC2) ReplaceRoute(in *ec2.ReplaceRouteInput) (*ec2.ReplaceRouteOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.ReplaceRoute(in)
+}
+
+func (b *countingEC2) DeleteRoute(in *ec2.DeleteRouteInput) (*ec2.DeleteRouteOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.DeleteRoute(in)
+}
+
+func (b *countingEC2) DescribeSubnets(in *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	if err := b.spend(false); err != nil {
+		return nil, err
+	}
+	describeSlots.acquire()
+	defer describeSlots.release()
+	return b.EC2API.DescribeSubnets(in)
+}
+
+func (b *countingEC2) CreateFlowLogs(in *ec2.CreateFlowLogsInput) (*ec2.CreateFlowLogsOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.CreateFlowLogs(in)
+}
+
+func (b *countingEC2) DeleteFlowLogs(in *ec2.DeleteFlowLogsInput) (*ec2.DeleteFlowLogsOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.DeleteFlowLogs(in)
+}
+
+func (b *countingEC2) CreateTags(in *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.CreateTags(in)
+}
+
+func (b *countingEC2) DisassociateRouteTable(in *ec2.DisassociateRouteTableInput) (*ec2.DisassociateRouteTableOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.DisassociateRouteTable(in)
+}
+
+func (b *countingEC2) DeleteRouteTable(in *ec2.DeleteRouteTableInput) (*ec2.DeleteRouteTableOutput, error) {
+	if err := b.spend(true); err != nil {
+		return nil, err
+	}
+	return b.EC2API.DeleteRouteTable(in)
+}
+
+func (b *countingEC2) DescribeVpcs(in *ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error) {
+	if err := b.spend(false); err != nil {
+		return nil, err
+	}
+	describeSlots.acquire()
+	defer describeSlots.release()
+	return b.EC2API.DescribeVpcs(in)
+}
+
+func (b *countingEC2) DescribeVpcAttribute(in *ec2.DescribeVpcAttributeInput) (*ec2.DescribeVpcAttributeOutput, error) {
+	if err := b.spend(false); err != nil {
+		return nil, err
+	}
+	describeSlots.acquire()
+	defer describeSlots.release()
+	return b.EC2API.DescribeVpcAttribute(in)
+}
@@ -0,0 +1,96 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAPICallBudget(t *testing.T) {
+	Convey("Given an update event routing many networks", t, func() {
+		ev := testEvent
+		ev.RoutedNetworkAWSIDs = nil
+		for i := 1; i <= 20; i++ {
+			ev.RoutedNetworkAWSIDs = append(ev.RoutedNetworkAWSIDs, fmt.Sprintf("subnet-%08d", i))
+		}
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+
+		update := func(budget int) error {
+			ev.APICallBudget = budget
+			data, _ := json.Marshal(ev)
+			e := New("nat.update.aws", data)
+			e.Process()
+			return e.Update()
+		}
+
+		Convey("When the routed networks need more calls than the budget", func() {
+			err := update(10)
+
+			Convey("It should stop once the budget is spent", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "AWS API call budget of 10 calls exceeded")
+				So(len(f.Calls()), ShouldEqual, 10)
+			})
+		})
+
+		Convey("When the budget is large enough", func() {
+			err := update(200)
+
+			Convey("It should route every network", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateRoute"), ShouldEqual, 20)
+			})
+		})
+
+		Convey("When there is no budget", func() {
+			err := update(0)
+
+			Convey("It should not limit the calls", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateRoute"), ShouldEqual, 20)
+			})
+		})
+	})
+}
+
+func TestAPICallCount(t *testing.T) {
+	Convey("Given an update event routing a network without route table", t, func() {
+		data, _ := json.Marshal(testEvent)
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+
+		Convey("When updating it", func() {
+			e := New("nat.update.aws", data)
+			e.Process()
+			err := e.Execute("update")
+
+			Convey("It should report the read and mutating calls made", func() {
+				So(err, ShouldBeNil)
+				So(f.Calls(), ShouldResemble, []string{
+					"DescribeNatGateways",
+					"DescribeSubnets",
+					"DescribeRouteTables",
+					"DescribeRouteTables",
+					"CreateRouteTable",
+					"AssociateRouteTable",
+					"CreateTags",
+					"CreateRoute",
+					"DescribeSubnets",
+				})
+				So(*e.APICalls, ShouldResemble, APICallCount{Read: 5, Mutating: 4})
+
+				payload, _ := json.Marshal(e)
+				So(string(payload), ShouldContainSubstring, `"api_calls":{"read":5,"mutating":4}`)
+			})
+		})
+	})
+}
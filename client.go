@@ -0,0 +1,166 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// clientCacheTTL is how long an ec2 client is reused across events
+var clientCacheTTL = time.Minute * 15
+
+// httpClient is the http client the sdk uses, when it is not the
+// default one
+var httpClient *http.Client
+
+// newSession builds the aws session the ec2 clients are built from
+var newSession = session.NewSession
+
+// base is the session shared by every ec2 client, each client setting
+// its own region and credentials on top of it
+var base struct {
+	sync.Mutex
+	session *session.Session
+}
+
+// baseSession returns the shared session, building it on first use. A
+// failure is not kept, so the next event tries again.
+func baseSession() (*session.Session, error) {
+	base.Lock()
+	defer base.Unlock()
+
+	if base.session != nil {
+		return base.session, nil
+	}
+
+	sess, err := newSession()
+	if err != nil {
+		return nil, err
+	}
+	base.session = sess
+
+	return sess, nil
+}
+
+// newEC2Client builds a new ec2 client for the region and credentials
+// of an event
+var newEC2Client = func(ev *Event) (ec2iface.EC2API, error) {
+	sess, err := baseSession()
+	if err != nil {
+		return nil, err
+	}
+
+	creds := credentials.NewStaticCredentials(ev.DatacenterAccessKey, ev.DatacenterAccessToken, "")
+	config := aws.Config{
+		Region:      aws.String(ev.DatacenterRegion),
+		Credentials: creds,
+	}
+
+	if httpClient != nil {
+		config.HTTPClient = httpClient
+	}
+
+	return ec2.New(sess, &config), nil
+}
+
+// awsHTTPClient builds the http client to reach aws through a proxy
+// and trusting a custom certificate authority bundle, as needed on some
+// corporate networks. It returns no client when neither is configured.
+func awsHTTPClient(proxy, caBundle string) (*http.Client, error) {
+	if proxy == "" && caBundle == "" {
+		return nil, nil
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+	}
+
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+
+	if caBundle != "" {
+		pem, err := ioutil.ReadFile(caBundle)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates found in CA bundle " + caBundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+type cachedClient struct {
+	svc     ec2iface.EC2API
+	expires time.Time
+}
+
+var clients = struct {
+	sync.Mutex
+	cache map[string]*cachedClient
+}{cache: make(map[string]*cachedClient)}
+
+// clientKey identifies the region and credentials of an event, hashing
+// the credentials so they are not kept in clear as map keys
+func clientKey(ev *Event) string {
+	h := sha256.New()
+	h.Write([]byte(ev.DatacenterAccessKey))
+	h.Write([]byte{0})
+	h.Write([]byte(ev.DatacenterAccessToken))
+
+	return ev.DatacenterRegion + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedEC2Client returns the ec2 client of the event region and
+// credentials, reusing the one built by a previous event until it expires
+func cachedEC2Client(ev *Event) (ec2iface.EC2API, error) {
+	key := clientKey(ev)
+	now := time.Now()
+
+	clients.Lock()
+	defer clients.Unlock()
+
+	for k, c := range clients.cache {
+		if now.After(c.expires) {
+			delete(clients.cache, k)
+		}
+	}
+
+	if c, ok := clients.cache[key]; ok {
+		return c.svc, nil
+	}
+
+	svc, err := newEC2Client(ev)
+	if err != nil {
+		return nil, err
+	}
+	clients.cache[key] = &cachedClient{svc: svc, expires: now.Add(clientCacheTTL)}
+
+	return svc, nil
+}
@@ -0,0 +1,190 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClientCache(t *testing.T) {
+	Convey("Given the ec2 client cache", t, func() {
+		build := newEC2Client
+		built := 0
+		newEC2Client = func(ev *Event) (ec2iface.EC2API, error) {
+			built++
+			return newFakeEC2(), nil
+		}
+
+		a := testEvent
+		b := testEvent
+
+		Convey("When two events have the same region and credentials", func() {
+			first, _ := cachedEC2Client(&a)
+			second, _ := cachedEC2Client(&b)
+
+			Convey("It should reuse the client", func() {
+				So(built, ShouldEqual, 1)
+				So(second, ShouldPointTo, first)
+			})
+		})
+
+		Convey("When two events have different credentials", func() {
+			b.DatacenterAccessToken = "other"
+			first, _ := cachedEC2Client(&a)
+			second, _ := cachedEC2Client(&b)
+
+			Convey("It should build a client for each", func() {
+				So(built, ShouldEqual, 2)
+				So(second, ShouldNotPointTo, first)
+			})
+		})
+
+		Convey("When two events have different regions", func() {
+			b.DatacenterRegion = "us-east-1"
+			cachedEC2Client(&a)
+			cachedEC2Client(&b)
+
+			Convey("It should build a client for each", func() {
+				So(built, ShouldEqual, 2)
+			})
+		})
+
+		Convey("When the cached client expired", func() {
+			clientCacheTTL = -time.Second
+			cachedEC2Client(&a)
+			cachedEC2Client(&b)
+
+			Convey("It should build a new one", func() {
+				So(built, ShouldEqual, 2)
+			})
+		})
+
+		Reset(func() {
+			newEC2Client = build
+			clientCacheTTL = time.Minute * 15
+			clients.Lock()
+			clients.cache = make(map[string]*cachedClient)
+			clients.Unlock()
+		})
+	})
+}
+
+func TestAWSHTTPClient(t *testing.T) {
+	Convey("Given the aws http client configuration", t, func() {
+		Convey("When nothing is configured", func() {
+			c, err := awsHTTPClient("", "")
+
+			Convey("It should use the sdk default client", func() {
+				So(err, ShouldBeNil)
+				So(c, ShouldBeNil)
+			})
+		})
+
+		Convey("When a proxy is configured", func() {
+			c, err := awsHTTPClient("http://proxy.example.com:3128", "")
+
+			Convey("It should send the requests through it", func() {
+				So(err, ShouldBeNil)
+				req, _ := http.NewRequest("GET", "https://ec2.eu-west-1.amazonaws.com", nil)
+				proxy, err := c.Transport.(*http.Transport).Proxy(req)
+				So(err, ShouldBeNil)
+				So(proxy.String(), ShouldEqual, "http://proxy.example.com:3128")
+			})
+
+			Convey("It should be set on new ec2 clients", func() {
+				httpClient = c
+				svc, err := newEC2Client(&testEvent)
+				So(err, ShouldBeNil)
+				So(svc.(*ec2.EC2).Config.HTTPClient, ShouldEqual, c)
+			})
+		})
+
+		Convey("When the CA bundle has no certificates", func() {
+			f, _ := ioutil.TempFile("", "ca")
+			f.WriteString("not a certificate")
+			f.Close()
+			_, err := awsHTTPClient("", f.Name())
+			os.Remove(f.Name())
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Reset(func() {
+			httpClient = nil
+		})
+	})
+}
+
+func TestSessionErrors(t *testing.T) {
+	Convey("Given the aws session can't be built", t, func() {
+		base.Lock()
+		base.session = nil
+		base.Unlock()
+		newSession = func(cfgs ...*aws.Config) (*session.Session, error) {
+			return nil, errors.New("invalid shared config")
+		}
+		ec2Client = cachedEC2Client
+
+		Convey("When building an ec2 client", func() {
+			svc, err := newEC2Client(&testEvent)
+
+			Convey("It should return the session error", func() {
+				So(svc, ShouldBeNil)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "invalid shared config")
+			})
+		})
+
+		Convey("When processing an event", func() {
+			data, _ := json.Marshal(testEvent)
+			e := New("nat.create.aws", data)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should fail with the session error without caching a client", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "invalid shared config")
+				clients.Lock()
+				defer clients.Unlock()
+				So(clients.cache, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the session can be built again", func() {
+			newSession = session.NewSession
+			svc, err := newEC2Client(&testEvent)
+
+			Convey("It should build the client", func() {
+				So(err, ShouldBeNil)
+				So(svc, ShouldNotBeNil)
+			})
+		})
+
+		Reset(func() {
+			newSession = session.NewSession
+			base.Lock()
+			base.session = nil
+			base.Unlock()
+			clients.Lock()
+			clients.cache = make(map[string]*cachedClient)
+			clients.Unlock()
+		})
+	})
+}
@@ -0,0 +1,114 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"strconv"
+	"sync"
+)
+
+// defaultMaxDescribeCalls is how many describe calls can be made at once
+// when it is not configured
+const defaultMaxDescribeCalls = 10
+
+// describeSlots limits the describe calls made at once across every
+// operation, as operations running on several regions concurrently
+// would otherwise burst them
+var describeSlots = newSlots(defaultMaxDescribeCalls)
+
+// slots is a semaphore, a nil one never blocks
+type slots chan struct{}
+
+// newSlots returns a semaphore of n slots, or none when n isn't positive
+func newSlots(n int) slots {
+	if n <= 0 {
+		return nil
+	}
+
+	return make(slots, n)
+}
+
+func (s slots) acquire() {
+	if s != nil {
+		s <- struct{}{}
+	}
+}
+
+func (s slots) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// maxDescribeCalls parses the configured maximum of concurrent describe
+// calls, zero meaning unlimited
+func maxDescribeCalls(s string) (int, error) {
+	if s == "" {
+		return defaultMaxDescribeCalls, nil
+	}
+
+	return strconv.Atoi(s)
+}
+
+// serializeVPCs makes the operations changing the same vpc run one at a
+// time, as their route table changes would otherwise race each other
+var serializeVPCs = true
+
+// serializedActions are the actions changing the routes of a vpc
+var serializedActions = map[string]bool{
+	"create": true,
+	"update": true,
+	"delete": true,
+	"igw":    true,
+}
+
+// vpcLocks are the locks of the vpcs operations are changing, each kept
+// while an operation holds or waits for it
+var vpcLocks = struct {
+	sync.Mutex
+	locks map[string]*vpcLock
+}{locks: make(map[string]*vpcLock)}
+
+type vpcLock struct {
+	sync.Mutex
+	users int
+}
+
+// lockVPC waits until no other operation is changing the vpc, operations
+// on other vpcs going on in parallel. It returns the function releasing it.
+func lockVPC(key string) func() {
+	vpcLocks.Lock()
+	l, ok := vpcLocks.locks[key]
+	if !ok {
+		l = &vpcLock{}
+		vpcLocks.locks[key] = l
+	}
+	l.users++
+	vpcLocks.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		vpcLocks.Lock()
+		defer vpcLocks.Unlock()
+
+		l.users--
+		if l.users == 0 {
+			delete(vpcLocks.locks, key)
+		}
+	}
+}
+
+// parseSerializeVPCs parses whether operations on the same vpc are
+// serialized, which they are unless configured otherwise
+func parseSerializeVPCs(s string) (bool, error) {
+	if s == "" {
+		return true, nil
+	}
+
+	return strconv.ParseBool(s)
+}
@@ -0,0 +1,166 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDescribeConcurrency(t *testing.T) {
+	Convey("Given describe calls are limited to two at once", t, func() {
+		describeSlots = newSlots(2)
+
+		f := newFakeEC2()
+		var mu sync.Mutex
+		inFlight, max := 0, 0
+		f.hooks["DescribeRouteTables"] = func(input interface{}) (interface{}, error) {
+			mu.Lock()
+			inFlight++
+			if inFlight > max {
+				max = inFlight
+			}
+			mu.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+
+			return &ec2.DescribeRouteTablesOutput{}, nil
+		}
+
+		Convey("When several operations describe route tables at once", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 6; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					svc := &countingEC2{EC2API: f}
+					svc.DescribeRouteTables(&ec2.DescribeRouteTablesInput{})
+				}()
+			}
+			wg.Wait()
+
+			Convey("It should never make more than two at once", func() {
+				So(f.Count("DescribeRouteTables"), ShouldEqual, 6)
+				So(max, ShouldBeBetweenOrEqual, 1, 2)
+			})
+		})
+
+		Reset(func() {
+			describeSlots = newSlots(defaultMaxDescribeCalls)
+		})
+	})
+
+	Convey("Given the describe calls limit configuration", t, func() {
+		Convey("When it is not set", func() {
+			n, err := maxDescribeCalls("")
+
+			Convey("It should use the default", func() {
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, defaultMaxDescribeCalls)
+			})
+		})
+
+		Convey("When it is not a number", func() {
+			_, err := maxDescribeCalls("many")
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When it is zero", func() {
+			n, _ := maxDescribeCalls("0")
+
+			Convey("It should not limit the calls", func() {
+				So(newSlots(n), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestVPCSerialization(t *testing.T) {
+	Convey("Given update events", t, func() {
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+
+		var mu sync.Mutex
+		inFlight, max := 0, 0
+		f.hooks["DescribeNatGateways"] = func(input interface{}) (interface{}, error) {
+			mu.Lock()
+			inFlight++
+			if inFlight > max {
+				max = inFlight
+			}
+			mu.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+
+			return &ec2.DescribeNatGatewaysOutput{NatGateways: f.natGateways}, nil
+		}
+
+		run := func(vpcs ...string) {
+			var wg sync.WaitGroup
+			for _, vpc := range vpcs {
+				ev := testEvent
+				ev.VPCID = vpc
+				data, _ := json.Marshal(ev)
+				e := New("nat.update.aws", data)
+				e.Process()
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					e.Execute("update")
+				}()
+			}
+			wg.Wait()
+		}
+
+		Convey("When two operations change the same vpc at once", func() {
+			run(testEvent.VPCID, testEvent.VPCID)
+
+			Convey("It should run them one at a time", func() {
+				So(max, ShouldEqual, 1)
+				So(vpcLocks.locks, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When two operations change different vpcs at once", func() {
+			run(testEvent.VPCID, "vpc-other")
+
+			Convey("It should run them in parallel", func() {
+				So(max, ShouldEqual, 2)
+			})
+		})
+
+		Convey("When serializing is disabled", func() {
+			serializeVPCs = false
+			run(testEvent.VPCID, testEvent.VPCID)
+
+			Convey("It should run them in parallel", func() {
+				So(max, ShouldEqual, 2)
+			})
+		})
+
+		Reset(func() {
+			serializeVPCs = true
+		})
+	})
+}
@@ -5,16 +5,20 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 )
 
 var (
@@ -28,32 +32,152 @@ var (
 	ErrNetworkIDInvalid = errors.New("Network id invalid")
 	// ErrRoutedNetworksEmpty ...
 	ErrRoutedNetworksEmpty = errors.New("Routed networks are empty")
+	// ErrRoutedNetworksMismatch ...
+	ErrRoutedNetworksMismatch = errors.New("Routed networks and routed network ids must have the same length")
 	// ErrNatGatewayIDInvalid ...
 	ErrNatGatewayIDInvalid = errors.New("Nat Gateway aws id invalid")
+	// ErrNatGatewayNotFound ...
+	ErrNatGatewayNotFound = errors.New("Could not find nat gateway")
+	// ErrAllocationIncomplete ...
+	ErrAllocationIncomplete = errors.New("Elastic IP allocation response is incomplete")
+	// ErrConnectivityTypeInvalid ...
+	ErrConnectivityTypeInvalid = errors.New("Connectivity type must be public or private")
+	// ErrPrivateAllocation ...
+	ErrPrivateAllocation = errors.New("Private nat gateways can't use an elastic ip allocation")
+	// ErrPrivateInternetRoute ...
+	ErrPrivateInternetRoute = errors.New("Private nat gateways can't ensure an internet route")
+	// ErrActionInvalid ...
+	ErrActionInvalid = errors.New("Action is not supported")
+	// ErrNetworkBorderGroupInvalid ...
+	ErrNetworkBorderGroupInvalid = errors.New("Network border group must belong to the datacenter region")
+	// ErrRegionsConflict ...
+	ErrRegionsConflict = errors.New("Datacenter region and datacenter regions can't be used together")
 )
 
+// exclusiveFields are the combinations of fields that can't be used together
+var exclusiveFields = []struct {
+	conflict func(ev *Event) bool
+	err      error
+}{
+	{
+		conflict: func(ev *Event) bool { return ev.DatacenterRegion != "" && len(ev.DatacenterRegions) > 0 },
+		err:      ErrRegionsConflict,
+	},
+	{
+		conflict: func(ev *Event) bool { return ev.isPrivate() && ev.NatGatewayAllocationID != "" },
+		err:      ErrPrivateAllocation,
+	},
+	{
+		conflict: func(ev *Event) bool { return ev.isPrivate() && ev.EnsureInternetRoute },
+		err:      ErrPrivateInternetRoute,
+	},
+}
+
+// deletePollInterval is how often a deleting nat gateway is checked
+var deletePollInterval = time.Second * 3
+
+// deleteGracePeriod is how long a deleting nat gateway is left before it
+// is first checked, as deleting always takes longer than that
+var deleteGracePeriod = defaultDeleteGracePeriod
+
+// ec2Client returns the ec2 client used to process an event
+var ec2Client = cachedEC2Client
+
 // Event stores the nat data
 type Event struct {
-	UUID                   string   `json:"_uuid"`
-	BatchID                string   `json:"_batch_id"`
-	ProviderType           string   `json:"_type"`
-	VPCID                  string   `json:"vpc_id"`
-	DatacenterRegion       string   `json:"datacenter_region"`
-	DatacenterAccessKey    string   `json:"datacenter_secret"`
-	DatacenterAccessToken  string   `json:"datacenter_token"`
-	NetworkAWSID           string   `json:"network_aws_id"`
-	PublicNetwork          string   `json:"public_network"`
-	PublicNetworkAWSID     string   `json:"public_network_aws_id"`
-	RoutedNetworks         []string `json:"routed_networks"`
-	RoutedNetworkAWSIDs    []string `json:"routed_networks_aws_ids"`
-	NatGatewayAWSID        string   `json:"nat_gateway_aws_id"`
-	NatGatewayAllocationID string   `json:"nat_gateway_allocation_id"`
-	NatGatewayAllocationIP string   `json:"nat_gateway_allocation_ip"`
-	InternetGatewayID      string   `json:"internet_gateway_id"`
-	ErrorMessage           string   `json:"error_message,omitempty"`
-	action                 string
+	UUID                   string              `json:"_uuid"`
+	Action                 string              `json:"action,omitempty"`
+	BatchID                string              `json:"_batch_id"`
+	ProviderType           string              `json:"_type"`
+	ExpiresAt              *time.Time          `json:"expires_at,omitempty"`
+	VPCID                  string              `json:"vpc_id"`
+	DatacenterRegion       string              `json:"datacenter_region"`
+	DatacenterRegions      []string            `json:"datacenter_regions,omitempty"`
+	DatacenterAccessKey    string              `json:"datacenter_secret"`
+	DatacenterAccessToken  string              `json:"datacenter_token"`
+	NetworkAWSID           string              `json:"network_aws_id"`
+	PublicNetwork          string              `json:"public_network"`
+	PublicNetworkAWSID     string              `json:"public_network_aws_id"`
+	PublicNetworkAWSIDs    []string            `json:"public_networks_aws_ids,omitempty"`
+	RoutedNetworks         []string            `json:"routed_networks"`
+	RoutedNetworkAWSIDs    []string            `json:"routed_networks_aws_ids"`
+	NatGatewayAWSID        string              `json:"nat_gateway_aws_id"`
+	NatGatewayAWSIDs       []string            `json:"nat_gateways_aws_ids,omitempty"`
+	NatGatewayAllocationID string              `json:"nat_gateway_allocation_id"`
+	NatGatewayAllocationIP string              `json:"nat_gateway_allocation_ip"`
+	NatGatewayAddresses    []GatewayAddress    `json:"nat_gateway_addresses,omitempty"`
+	PublicIPv4Pool         string              `json:"public_ipv4_pool,omitempty"`
+	ByoipCidr              string              `json:"byoip_cidr,omitempty"`
+	ByoipCidrState         string              `json:"byoip_cidr_state,omitempty"`
+	ByoipWithdrawalPolicy  string              `json:"byoip_withdrawal_policy,omitempty"`
+	NetworkBorderGroup     string              `json:"network_border_group,omitempty"`
+	InternetGatewayID      string              `json:"internet_gateway_id"`
+	InternetGatewayState   string              `json:"internet_gateway_state,omitempty"`
+	InternetGatewayNeeded  *bool               `json:"internet_gateway_required,omitempty"`
+	InternetGatewayCreated *bool               `json:"internet_gateway_created,omitempty"`
+	FlowLogDestination     string              `json:"flow_log_destination,omitempty"`
+	FlowLogRoleARN         string              `json:"flow_log_role_arn,omitempty"`
+	FlowLogResource        string              `json:"flow_log_resource,omitempty"`
+	FlowLogIDs             []string            `json:"flow_log_ids,omitempty"`
+	ClientToken            string              `json:"client_token,omitempty"`
+	ConnectivityType       string              `json:"connectivity_type,omitempty"`
+	PrivateIPAddress       string              `json:"private_ip_address,omitempty"`
+	SecondaryIPCount       int64               `json:"secondary_private_ip_address_count,omitempty"`
+	EnsureInternetRoute    bool                `json:"ensure_internet_route,omitempty"`
+	InternetRoute          string              `json:"internet_route,omitempty"`
+	Regions                map[string]*Region  `json:"regions,omitempty"`
+	NatGatewayDeletions    []GatewayDeletion   `json:"nat_gateway_deletions,omitempty"`
+	RouteTables            map[string]string   `json:"route_tables,omitempty"`
+	RouteTableSubnets      map[string][]string `json:"route_table_subnets,omitempty"`
+	RouteConflictPolicy    string              `json:"route_conflict_policy,omitempty"`
+	NoRouteTablePolicy     string              `json:"no_route_table_policy,omitempty"`
+	RouteTableTag          string              `json:"route_table_tag,omitempty"`
+	RouteTableTagKey       string              `json:"route_table_tag_key,omitempty"`
+	RouteTargetType        string              `json:"route_target_type,omitempty"`
+	RouteTargetID          string              `json:"route_target_id,omitempty"`
+	RouteChanges           []RouteChange       `json:"route_changes,omitempty"`
+	Routes                 *RouteCount         `json:"routes,omitempty"`
+	VerifyRoutes           bool                `json:"verify_routes,omitempty"`
+	VerifyReachability     bool                `json:"verify_reachability,omitempty"`
+	Verified               *bool               `json:"verified,omitempty"`
+	VerificationFailure    string              `json:"verification_failure,omitempty"`
+	ListRouteTables        bool                `json:"list_route_tables,omitempty"`
+	VPCRouteTables         []VPCRouteTable     `json:"vpc_route_tables,omitempty"`
+	APICallBudget          int                 `json:"api_call_budget,omitempty"`
+	CheckPermissions       bool                `json:"check_permissions,omitempty"`
+	APICalls               *APICallCount       `json:"api_calls,omitempty"`
+	APICallLatencies       []APICallLatency    `json:"api_call_latencies,omitempty"`
+	Validation             *ValidationReport   `json:"validation,omitempty"`
+	Teardown               bool                `json:"teardown,omitempty"`
+	RollbackOnFailure      bool                `json:"rollback_on_failure,omitempty"`
+	Rollback               []RollbackStep      `json:"rollback,omitempty"`
+	StrictGatewaySubnet    bool                `json:"strict_gateway_subnet,omitempty"`
+	StrictRouteTables      bool                `json:"strict_route_tables,omitempty"`
+	RecreateOnUpdate       bool                `json:"recreate_on_update,omitempty"`
+	NatGatewayState        string              `json:"nat_gateway_state,omitempty"`
+	FailureCode            string              `json:"failure_code,omitempty"`
+	FailureMessage         string              `json:"failure_message,omitempty"`
+	Found                  *bool               `json:"found,omitempty"`
+	NatGatewayCreateTime   string              `json:"nat_gateway_create_time,omitempty"`
+	WaitMS                 *int64              `json:"wait_ms,omitempty"`
+	VPCDNSSupport          *bool               `json:"vpc_dns_support,omitempty"`
+	VPCDNSHostnames        *bool               `json:"vpc_dns_hostnames,omitempty"`
+	Tags                   map[string]string   `json:"tags,omitempty"`
+	TagFailurePolicy       string              `json:"tag_failure_policy,omitempty"`
+	EnforceBatchOwnership  bool                `json:"enforce_batch_ownership,omitempty"`
+	AvailabilityZones      map[string][]string `json:"availability_zones,omitempty"`
+	CrossAZNetworks        []string            `json:"cross_az_networks,omitempty"`
+	Warnings               []string            `json:"warnings,omitempty"`
+	Status                 string              `json:"status,omitempty"`
+	NoOpReason             string              `json:"no_op_reason,omitempty"`
+	ErrorMessage           string              `json:"error_message,omitempty"`
+	ErrorClass             string              `json:"error_class,omitempty"`
+	Suggestion             string              `json:"suggestion,omitempty"`
+	started                time.Time
+	calls                  *countingEC2
 	subject                string
 	body                   []byte
+	created                []createdResource
 }
 
 // New : Constructor
@@ -67,30 +191,141 @@ func New(subject string, body []byte) Event {
 
 // Validate checks if all criteria are met
 func (ev *Event) Validate() error {
-	if ev.VPCID == "" {
-		return ErrDatacenterIDInvalid
+	// a long queued event may no longer reflect what is wanted
+	if ev.ExpiresAt != nil && time.Now().After(*ev.ExpiresAt) {
+		return fmt.Errorf("Event expired at %s", ev.ExpiresAt.UTC().Format(time.RFC3339))
 	}
 
-	if ev.DatacenterRegion == "" {
+	if ev.DatacenterRegion == "" && len(ev.DatacenterRegions) == 0 {
 		return ErrDatacenterRegionInvalid
 	}
 
+	if ev.DatacenterRegion != "" {
+		region, err := normalizeRegion(ev.DatacenterRegion)
+		if err != nil {
+			return err
+		}
+		ev.DatacenterRegion = region
+	}
+
+	seen := make(map[string]bool)
+	for i, region := range ev.DatacenterRegions {
+		if strings.TrimSpace(region) == "" {
+			return ErrDatacenterRegionInvalid
+		}
+
+		normalized, err := normalizeRegion(region)
+		if err != nil {
+			return err
+		}
+
+		if seen[normalized] {
+			return ErrDatacenterRegionInvalid
+		}
+		seen[normalized] = true
+
+		// the resources of the region are keyed as it was given
+		ev.DatacenterRegions[i] = normalized
+		if res, ok := ev.Regions[region]; ok && region != normalized {
+			delete(ev.Regions, region)
+			ev.Regions[normalized] = res
+		}
+	}
+
 	if ev.DatacenterAccessKey == "" || ev.DatacenterAccessToken == "" {
 		return ErrDatacenterCredentialsInvalid
 	}
 
-	if ev.subject == "nat.delete.aws" {
-		if ev.NatGatewayAWSID == "" {
+	for _, f := range exclusiveFields {
+		if f.conflict(ev) {
+			return f.err
+		}
+	}
+
+	if len(ev.DatacenterRegions) > 0 {
+		return ev.validateRegions()
+	}
+
+	return ev.validateResources()
+}
+
+// validateResources checks the aws resources the event operates on
+func (ev *Event) validateResources() error {
+	if ev.VPCID == "" {
+		return ErrDatacenterIDInvalid
+	}
+
+	// local and wavelength zones border groups are named after their region
+	if ev.NetworkBorderGroup != "" && ev.NetworkBorderGroup != ev.DatacenterRegion && !strings.HasPrefix(ev.NetworkBorderGroup, ev.DatacenterRegion+"-") {
+		return ErrNetworkBorderGroupInvalid
+	}
+
+	if err := ev.validateTagFailurePolicy(); err != nil {
+		return err
+	}
+
+	switch ev.Action {
+	case "igw":
+		return nil
+	case "get":
+		// a nat gateway can also be looked up by the network it is on
+		if ev.NatGatewayAWSID == "" && ev.PublicNetworkAWSID == "" && ev.PublicNetwork == "" {
 			return ErrNatGatewayIDInvalid
 		}
-	} else {
-		if ev.PublicNetworkAWSID == "" {
+	case "delete":
+		if ev.NatGatewayAWSID == "" && len(ev.NatGatewayAWSIDs) == 0 {
+			return ErrNatGatewayIDInvalid
+		}
+	case "create", "update", "validate":
+		// create can pick the public network among candidates, and the
+		// public network can be given by name
+		if ev.PublicNetworkAWSID == "" && ev.PublicNetwork == "" && (ev.Action != "create" || len(ev.PublicNetworkAWSIDs) == 0) {
 			return ErrNetworkIDInvalid
 		}
 
-		if len(ev.RoutedNetworkAWSIDs) < 1 {
+		if len(ev.RoutedNetworkAWSIDs) < 1 && len(ev.RoutedNetworks) < 1 {
 			return ErrRoutedNetworksEmpty
 		}
+
+		if len(ev.RoutedNetworkAWSIDs) > 0 && len(ev.RoutedNetworks) > 0 && len(ev.RoutedNetworkAWSIDs) != len(ev.RoutedNetworks) {
+			return ErrRoutedNetworksMismatch
+		}
+
+		switch ev.ConnectivityType {
+		case "", ec2.ConnectivityTypePublic, ec2.ConnectivityTypePrivate:
+		default:
+			return ErrConnectivityTypeInvalid
+		}
+
+		if err := ev.validatePrivateAddresses(); err != nil {
+			return err
+		}
+
+		if err := ev.validateFlowLog(); err != nil {
+			return err
+		}
+
+		switch ev.RouteConflictPolicy {
+		case "", routeConflictFail, routeConflictReplace, routeConflictSkip:
+		default:
+			return ErrRouteConflictPolicyInvalid
+		}
+
+		switch ev.NoRouteTablePolicy {
+		case "", noRouteTableFail, noRouteTableCreate:
+		default:
+			return ErrNoRouteTablePolicyInvalid
+		}
+
+		switch ev.ByoipWithdrawalPolicy {
+		case "", byoipWithdrawalWarn, byoipWithdrawalFail:
+		default:
+			return ErrByoipWithdrawalPolicyInvalid
+		}
+
+		return ev.validateRouteTarget()
+	default:
+		return ErrActionInvalid
 	}
 
 	return nil
@@ -98,8 +333,17 @@ func (ev *Event) Validate() error {
 
 // Process : starts processing the current message
 func (ev *Event) Process() error {
-	ev.action = strings.Split(ev.subject, ".")[1]
 	err := json.Unmarshal(ev.body, &ev)
+
+	// the subject is what asks for the action, whatever the body says
+	ev.Action = subjectAction(ev.subject)
+
+	// a retry resends the previous error answer, which doesn't describe
+	// this attempt
+	ev.ErrorMessage = ""
+	ev.ErrorClass = ""
+	ev.Suggestion = ""
+
 	if err != nil {
 		nc.Publish(ev.subject+".error", ev.body)
 	}
@@ -111,12 +355,22 @@ func (ev *Event) Process() error {
 func (ev *Event) Error(err error) {
 	log.Printf("Error: %s", err.Error())
 	ev.ErrorMessage = err.Error()
+	ev.Status = statusFailed
+
+	if ev.ErrorClass == "" {
+		ev.ErrorClass = errorClass(err)
+	}
+
+	if s, ok := err.(suggester); ok {
+		ev.Suggestion = s.Suggestion()
+	}
 
 	data, err := json.Marshal(ev)
 	if err != nil {
 		log.Panic(err)
 	}
-	nc.Publish(ev.subject+".error", data)
+	publishResult(ev.subject+".error", data)
+	ev.stream("error")
 }
 
 // Complete : Responds the current request as done
@@ -125,136 +379,685 @@ func (ev *Event) Complete() {
 	if err != nil {
 		ev.Error(err)
 	}
-	nc.Publish(ev.subject+".done", data)
+	publishResult(ev.subject+".done", data)
+	ev.stream("done")
+
+	log.Println(ev.summary(time.Since(ev.started)))
 }
 
 // Create : Creates a nat object on aws
 func (ev *Event) Create() error {
-	creds := credentials.NewStaticCredentials(ev.DatacenterAccessKey, ev.DatacenterAccessToken, "")
-	svc := ec2.New(session.New(), &aws.Config{
-		Region:      aws.String(ev.DatacenterRegion),
-		Credentials: creds,
-	})
+	svc, err := ev.client()
+	if err != nil {
+		return err
+	}
+
+	ev.created = nil
+	ev.Rollback = nil
+	err = ev.create(svc)
+	if err != nil && ev.RollbackOnFailure {
+		ev.rollback(svc)
+	}
 
-	// Create Elastic IP
-	resp, err := svc.AllocateAddress(nil)
+	return err
+}
+
+func (ev *Event) create(svc ec2iface.EC2API) error {
+	err := ev.resolvePublicNetwork(svc)
 	if err != nil {
 		return err
 	}
 
-	ev.NatGatewayAllocationID = *resp.AllocationId
-	ev.NatGatewayAllocationIP = *resp.PublicIp
+	err = ev.resolveRoutedNetworks(svc)
+	if err != nil {
+		return err
+	}
 
-	// Create Internet Gateway
-	ev.InternetGatewayID, err = ev.createInternetGateway(svc)
+	err = ev.checkAllowedSubnets(svc)
 	if err != nil {
 		return err
 	}
 
-	// Create Nat Gateway
-	req := ec2.CreateNatGatewayInput{
-		AllocationId: aws.String(ev.NatGatewayAllocationID),
-		SubnetId:     aws.String(ev.PublicNetworkAWSID),
+	err = ev.checkRoutedNetworkVPCs(svc)
+	if err != nil {
+		return err
 	}
 
-	gwresp, err := svc.CreateNatGateway(&req)
+	// Nothing is created when no network would route through it
+	ev.NoOpReason, err = ev.natGatewayNotNeeded(svc)
+	if err != nil || ev.NoOpReason != "" {
+		return err
+	}
+
+	if ev.PublicNetworkAWSID == "" {
+		ev.PublicNetworkAWSID = ev.selectPublicNetwork(svc)
+	}
+
+	// The dry runs and the token need the network the nat gateway goes on
+	if ev.CheckPermissions {
+		err = ev.checkPermissions(svc, "create")
+		if err != nil {
+			return err
+		}
+	}
+
+	// Keep the token on the event so a retry of this event, even from
+	// another process, creates the nat gateway idempotently
+	ev.ClientToken = ev.clientToken()
+
+	// Wait for the nat gateway network before allocating anything on it
+	err = ev.waitForSubnet(svc, ev.PublicNetworkAWSID)
+	if err != nil {
+		return err
+	}
+
+	// Reuse the nat gateway a previous attempt already created
+	existing, err := ev.existingNatGateway(svc)
 	if err != nil {
 		return err
 	}
 
-	ev.NatGatewayAWSID = *gwresp.NatGateway.NatGatewayId
+	if existing == nil {
+		err = ev.checkPrivateAddresses(svc)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Create Elastic IP, unless a previous attempt already allocated it.
+	// Private nat gateways have no public address
+	if existing == nil && ev.NatGatewayAllocationID == "" && !ev.isPrivate() {
+		err = ev.allocateAddress(svc)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Create Internet Gateway, private nat gateways don't egress through it
+	ev.InternetGatewayNeeded = aws.Bool(!ev.isPrivate())
+	ev.InternetGatewayCreated = nil
+	if !ev.isPrivate() {
+		ev.InternetGatewayID, err = ev.createInternetGateway(svc)
+		if err != nil {
+			return err
+		}
+
+		if ev.EnsureInternetRoute {
+			err = ev.ensureInternetRoute(svc)
+			if err != nil {
+				return err
+			}
+		}
+
+		// A reused gateway only provides egress from a public network
+		if existing != nil {
+			err = ev.checkGatewaySubnet(svc, existing)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Create Nat Gateway
+	if existing == nil {
+		err = ev.createNatGateway(svc)
+		if err != nil {
+			return err
+		}
+	}
 
 	waitnat := ec2.DescribeNatGatewaysInput{
-		NatGatewayIds: []*string{gwresp.NatGateway.NatGatewayId},
+		NatGatewayIds: []*string{aws.String(ev.NatGatewayAWSID)},
 	}
 
+	// how long aws takes to provision nat gateways is reported to track it
+	waitStart := time.Now()
 	err = svc.WaitUntilNatGatewayAvailable(&waitnat)
+	ev.WaitMS = aws.Int64(int64(time.Since(waitStart) / time.Millisecond))
 	if err != nil {
 		return err
 	}
 
-	for _, networkID := range ev.RoutedNetworkAWSIDs {
-		rt, err := ev.createRouteTable(svc, networkID)
+	// The waiter can return as the gateway moves on to another state,
+	// as when it is deleted meanwhile, so confirm it is available
+	gw, err := ev.natGatewayByID(svc, ev.NatGatewayAWSID)
+	if err != nil {
+		return err
+	}
+
+	if state := aws.StringValue(gw.State); state != ec2.NatGatewayStateAvailable {
+		return fmt.Errorf("Nat gateway %s is %s instead of available", ev.NatGatewayAWSID, state)
+	}
+
+	if !ev.isPrivate() {
+		gw, err = ev.waitNatGatewayAddresses(svc, gw)
 		if err != nil {
 			return err
 		}
+		ev.reportAddress(gw)
+
+		ev.reportAddressPool(svc)
 
-		err = ev.createNatGatewayRoutes(svc, rt, *gwresp.NatGateway.NatGatewayId)
+		// an address aws can't route doesn't give the networks egress
+		err = ev.byoipWithdrawal()
+		if err != nil && ev.ByoipWithdrawalPolicy == byoipWithdrawalFail {
+			return err
+		}
+		if err != nil {
+			ev.warn(err.Error())
+		}
+	}
+
+	ev.reportAddresses(gw)
+
+	tags, err := ev.namedTags(svc, ev.createdTags(ev.Tags), "nat", ev.PublicNetworkAWSID)
+	if err != nil {
+		return err
+	}
+
+	err = ev.applyTags(svc, gw.NatGatewayId, gw.Tags, tags)
+	if err != nil {
+		return err
+	}
+
+	ev.Routes = &RouteCount{}
+	for _, networkID := range ev.RoutedNetworkAWSIDs {
+		err = ev.routeNetwork(svc, networkID)
 		if err != nil {
 			return err
 		}
 	}
 
+	err = ev.verifyRoutes(svc)
+	if err != nil {
+		return err
+	}
+
+	err = ev.verifyReachability(svc)
+	if err != nil {
+		return err
+	}
+
+	err = ev.createFlowLogs(svc, gw)
+	if err != nil {
+		return err
+	}
+
+	ev.reportAvailabilityZones(svc)
+
+	return nil
+}
+
+func (ev *Event) createNatGateway(svc ec2iface.EC2API) error {
+	req := ec2.CreateNatGatewayInput{
+		SubnetId:    aws.String(ev.PublicNetworkAWSID),
+		ClientToken: aws.String(ev.ClientToken),
+	}
+
+	if ev.isPrivate() {
+		req.ConnectivityType = aws.String(ec2.ConnectivityTypePrivate)
+	} else {
+		req.AllocationId = aws.String(ev.NatGatewayAllocationID)
+	}
+
+	if ev.PrivateIPAddress != "" {
+		req.PrivateIpAddress = aws.String(ev.PrivateIPAddress)
+	}
+
+	if ev.SecondaryIPCount > 0 {
+		req.SecondaryPrivateIpAddressCount = aws.Int64(ev.SecondaryIPCount)
+	}
+
+	gwresp, err := svc.CreateNatGateway(&req)
+	if err != nil {
+		return err
+	}
+
+	if gwresp.NatGateway == nil {
+		return missingField("nat gateway")
+	}
+
+	ev.NatGatewayAWSID, err = responseString("nat gateway id", gwresp.NatGateway.NatGatewayId)
+	if err != nil {
+		return err
+	}
+
+	ev.track("nat_gateway", ev.NatGatewayAWSID, ev.rollbackNatGateway)
+	ev.NatGatewayCreateTime = timestamp(gwresp.NatGateway.CreateTime)
+
 	return nil
 }
 
 // Update : Updates a nat object on aws
 func (ev *Event) Update() error {
-	creds := credentials.NewStaticCredentials(ev.DatacenterAccessKey, ev.DatacenterAccessToken, "")
-	svc := ec2.New(session.New(), &aws.Config{
-		Region:      aws.String(ev.DatacenterRegion),
-		Credentials: creds,
-	})
+	svc, err := ev.client()
+	if err != nil {
+		return err
+	}
 
-	for _, networkID := range ev.RoutedNetworkAWSIDs {
-		rt, err := ev.createRouteTable(svc, networkID)
+	if ev.CheckPermissions {
+		err = ev.checkPermissions(svc, "update")
 		if err != nil {
 			return err
 		}
+	}
 
-		if ev.routeTableIsConfigured(rt) {
-			continue
+	// Routing networks to a gateway that is gone would blackhole them
+	if ev.natRouteTarget().kind == routeTargetNatGateway {
+		gw, err := ev.liveNatGateway(svc)
+		if err != nil {
+			return err
+		}
+
+		if gw == nil && ev.RecreateOnUpdate {
+			return ev.recreateNatGateway(svc)
+		}
+
+		if gw == nil {
+			return fmt.Errorf("Nat gateway %s no longer exists", ev.NatGatewayAWSID)
 		}
 
-		err = ev.createNatGatewayRoutes(svc, rt, ev.NatGatewayAWSID)
+		err = ev.applyTags(svc, gw.NatGatewayId, gw.Tags, withScopeTag(ev.Tags))
 		if err != nil {
 			return err
 		}
 	}
 
+	err = ev.resolvePublicNetwork(svc)
+	if err != nil {
+		return err
+	}
+
+	err = ev.resolveRoutedNetworks(svc)
+	if err != nil {
+		return err
+	}
+
+	err = ev.checkAllowedSubnets(svc)
+	if err != nil {
+		return err
+	}
+
+	err = ev.checkRoutedNetworkVPCs(svc)
+	if err != nil {
+		return err
+	}
+
+	ev.Routes = &RouteCount{}
+	for _, networkID := range ev.RoutedNetworkAWSIDs {
+		err = ev.routeNetwork(svc, networkID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ev.verifyRoutes(svc)
+	if err != nil {
+		return err
+	}
+
+	ev.reportAvailabilityZones(svc)
+
 	return nil
 }
 
 // Delete : Deletes a nat object on aws
 func (ev *Event) Delete() error {
-	creds := credentials.NewStaticCredentials(ev.DatacenterAccessKey, ev.DatacenterAccessToken, "")
-	svc := ec2.New(session.New(), &aws.Config{
-		Region:      aws.String(ev.DatacenterRegion),
-		Credentials: creds,
-	})
+	svc, err := ev.client()
+	if err != nil {
+		return err
+	}
+
+	if len(ev.NatGatewayAWSIDs) > 0 {
+		return ev.deleteNatGateways(svc)
+	}
+
+	if ev.CheckPermissions {
+		err = ev.checkPermissions(svc, "delete")
+		if err != nil {
+			return err
+		}
+	}
+
+	return ev.deleteNatGateway(svc)
+}
+
+// deleteNatGateway deletes the nat gateway of the event
+func (ev *Event) deleteNatGateway(svc ec2iface.EC2API) error {
+	err := ev.checkBatchOwnership(svc)
+	if err != nil {
+		return err
+	}
+
+	// Routes to the nat gateway are removed first, so no network is left
+	// routing to a blackhole
+	if ev.Teardown {
+		err = ev.checkAllowedSubnets(svc)
+		if err != nil {
+			return err
+		}
+
+		err = ev.deleteNatGatewayRoutes(svc)
+		if err != nil {
+			return err
+		}
+	}
 
 	req := ec2.DeleteNatGatewayInput{
 		NatGatewayId: aws.String(ev.NatGatewayAWSID),
 	}
 
-	_, err := svc.DeleteNatGateway(&req)
+	// deleting and polling share the backoff, as both add to the same
+	// rate limits
+	b := newBackoff(deletePollInterval, deletePollMaxInterval)
+
+	_, err = svc.DeleteNatGateway(&req)
+	for isThrottled(err) {
+		b.throttled(err)
+		b.wait()
+		_, err = svc.DeleteNatGateway(&req)
+	}
+
 	if err != nil {
 		return err
 	}
+	infof("Nat gateway %s deletion issued", ev.NatGatewayAWSID)
+
+	if deleteGracePeriod > 0 {
+		sleep(deleteGracePeriod)
+	}
 
-	for ev.isNatGatewayDeleted(svc, ev.NatGatewayAWSID) == false {
-		time.Sleep(time.Second * 3)
+	err = ev.waitNatGatewayDeleted(svc, b)
+	if err != nil {
+		return err
+	}
+	infof("Nat gateway %s deleted", ev.NatGatewayAWSID)
+
+	// The elastic ip can only be released once the nat gateway is gone
+	if ev.Teardown {
+		err = ev.deleteFlowLogs(svc)
+		if err != nil {
+			return err
+		}
+
+		return ev.releaseAddress(svc)
 	}
 
 	return nil
 }
 
+// waitNatGatewayDeleted waits until the nat gateway is deleted, polling
+// slower while aws throttles the requests. Transient errors are retried a
+// bounded number of times, as the deletion goes on regardless.
+func (ev *Event) waitNatGatewayDeleted(svc ec2iface.EC2API, b *backoff) error {
+	retries := 0
+
+	for {
+		deleted, err := ev.isNatGatewayDeleted(svc, ev.NatGatewayAWSID)
+		if isThrottled(err) {
+			b.throttled(err)
+			b.wait()
+			continue
+		}
+
+		if isTransient(err) && retries < deleteWaitRetries {
+			retries++
+			log.Printf("Waiting for nat gateway %s deletion failed, retrying (%d/%d): %s", ev.NatGatewayAWSID, retries, deleteWaitRetries, err.Error())
+			b.wait()
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if deleted {
+			return nil
+		}
+
+		debugf("Nat gateway %s is not deleted yet, checking again in %s", ev.NatGatewayAWSID, b.interval)
+		b.wait()
+	}
+}
+
+// Execute : Runs the action requested on the event, on every region
+// of the event when it targets more than one
+func (ev *Event) Execute(action string) error {
+	ev.started = time.Now()
+
+	if len(ev.DatacenterRegions) > 0 {
+		err := ev.fanOut(action)
+		ev.Status = ev.operationStatus(action, err)
+		return err
+	}
+
+	return ev.execute(action)
+}
+
+func (ev *Event) execute(action string) error {
+	// the reports only describe the current operation
+	ev.RouteChanges = nil
+	ev.Routes = nil
+	ev.RouteTableSubnets = nil
+	ev.NatGatewayDeletions = nil
+	ev.WaitMS = nil
+	ev.Warnings = nil
+	ev.NoOpReason = ""
+	ev.ErrorMessage = ""
+	ev.ErrorClass = ""
+	ev.Suggestion = ""
+	ev.Verified = nil
+	ev.VerificationFailure = ""
+	ev.APICalls = nil
+	ev.APICallLatencies = nil
+	ev.calls = nil
+
+	// route table changes on the same vpc race each other, aws failing
+	// them as already existing or associated
+	if serializeVPCs && serializedActions[action] && ev.VPCID != "" {
+		defer lockVPC(ev.DatacenterRegion + ":" + ev.VPCID)()
+	}
+
+	var err error
+
+	switch action {
+	case "create":
+		err = ev.Create()
+	case "update":
+		err = ev.Update()
+	case "delete":
+		err = ev.Delete()
+	case "get":
+		err = ev.Get()
+	case "igw":
+		err = ev.InternetGateway()
+	case "validate":
+		err = ev.Preflight()
+	}
+
+	if ev.calls != nil {
+		count := ev.calls.count()
+		ev.APICalls = &count
+		ev.APICallLatencies = ev.calls.recordedLatencies()
+	}
+
+	ev.Status = ev.operationStatus(action, err)
+
+	return err
+}
+
 // Get : Gets a nat object on aws
 func (ev *Event) Get() error {
-	err := errors.New(ev.subject + " not implemented")
+	svc, err := ev.client()
+	if err != nil {
+		return err
+	}
+
+	var gw *ec2.NatGateway
+
+	if ev.NatGatewayAWSID == "" {
+		err = ev.resolvePublicNetwork(svc)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ev.NatGatewayAWSID != "" {
+		gw, err = ev.natGatewayByID(svc, ev.NatGatewayAWSID)
+	} else {
+		gw, err = ev.natGatewayBySubnetID(svc, ev.PublicNetworkAWSID)
+	}
+
+	// a missing nat gateway is a valid answer, reported as deleted
+	if err == ErrNatGatewayNotFound || isAWSError(err, "NatGatewayNotFound") {
+		ev.Found = aws.Bool(false)
+		ev.NatGatewayState = ec2.NatGatewayStateDeleted
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ev.NatGatewayAWSID = aws.StringValue(gw.NatGatewayId)
+	ev.NatGatewayState = aws.StringValue(gw.State)
+	ev.Found = aws.Bool(ev.NatGatewayState != ec2.NatGatewayStateDeleted)
+	ev.PublicNetworkAWSID = aws.StringValue(gw.SubnetId)
+	ev.ConnectivityType = aws.StringValue(gw.ConnectivityType)
+	ev.NatGatewayCreateTime = timestamp(gw.CreateTime)
+
+	// aws explains why a nat gateway failed, when it knows
+	ev.FailureCode, ev.FailureMessage = "", ""
+	if ev.NatGatewayState == ec2.NatGatewayStateFailed {
+		ev.FailureCode = aws.StringValue(gw.FailureCode)
+		ev.FailureMessage = aws.StringValue(gw.FailureMessage)
+	}
+
+	// A just created nat gateway can be available before listing its
+	// elastic ip
+	if !ev.isPrivate() && ev.NatGatewayState == ec2.NatGatewayStateAvailable {
+		gw, err = ev.waitNatGatewayAddresses(svc, gw)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, addr := range gw.NatGatewayAddresses {
+		ev.NatGatewayAllocationID = aws.StringValue(addr.AllocationId)
+		ev.NatGatewayAllocationIP = aws.StringValue(addr.PublicIp)
+		break
+	}
+
+	ev.reportAddresses(gw)
+
+	if !ev.isPrivate() {
+		ev.reportAddressPool(svc)
+
+		if err = ev.byoipWithdrawal(); err != nil {
+			ev.warn(err.Error())
+		}
+	}
+
+	ev.Tags = make(map[string]string)
+	for _, tag := range gw.Tags {
+		ev.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+
+	// The vpc dns settings help diagnosing networks that can't resolve
+	// names through the nat gateway
+	ev.VPCDNSSupport, err = ev.vpcAttribute(svc, ec2.VpcAttributeNameEnableDnsSupport)
+	if err != nil {
+		return err
+	}
+
+	ev.VPCDNSHostnames, err = ev.vpcAttribute(svc, ec2.VpcAttributeNameEnableDnsHostnames)
+	if err != nil {
+		return err
+	}
+
+	// private nat gateways don't egress through an internet gateway
+	if !ev.isPrivate() {
+		err = ev.reportInternetRoute(svc)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !ev.ListRouteTables {
+		return nil
+	}
+
+	ev.VPCRouteTables, err = ev.vpcRouteTables(svc)
+
+	return err
+}
+
+// vpcAttribute returns a boolean attribute of the event vpc
+func (ev *Event) vpcAttribute(svc ec2iface.EC2API, attribute string) (*bool, error) {
+	req := ec2.DescribeVpcAttributeInput{
+		VpcId:     aws.String(ev.VPCID),
+		Attribute: aws.String(attribute),
+	}
+
+	resp, err := svc.DescribeVpcAttribute(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	var v *ec2.AttributeBooleanValue
+	switch attribute {
+	case ec2.VpcAttributeNameEnableDnsSupport:
+		v = resp.EnableDnsSupport
+	case ec2.VpcAttributeNameEnableDnsHostnames:
+		v = resp.EnableDnsHostnames
+	}
+
+	if v == nil || v.Value == nil {
+		return nil, missingField(attribute)
+	}
+
+	return v.Value, nil
+}
+
+// InternetGateway : Ensures the vpc has an attached internet gateway
+func (ev *Event) InternetGateway() error {
+	svc, err := ev.client()
+	if err != nil {
+		return err
+	}
+
+	ev.InternetGatewayID, err = ev.createInternetGateway(svc)
+
 	return err
 }
 
-func (ev *Event) internetGatewayByVPCID(svc *ec2.EC2, vpc string) (*ec2.InternetGateway, error) {
-	f := []*ec2.Filter{
-		&ec2.Filter{
-			Name:   aws.String("attachment.vpc-id"),
-			Values: []*string{aws.String(vpc)},
-		},
+// warn adds a warning to the event result
+func (ev *Event) warn(warning string) {
+	log.Printf("Warning: %s", warning)
+	ev.Warnings = append(ev.Warnings, warning)
+}
+
+// isPrivate returns true if the nat gateway has private connectivity
+func (ev *Event) isPrivate() bool {
+	return ev.ConnectivityType == ec2.ConnectivityTypePrivate
+}
+
+// clientToken returns the idempotency token for the nat gateway creation,
+// it is derived from the event when no previous attempt stored one
+func (ev *Event) clientToken() string {
+	if ev.ClientToken != "" {
+		return ev.ClientToken
 	}
 
+	h := sha256.Sum256([]byte(ev.UUID + ev.BatchID + ev.PublicNetworkAWSID))
+
+	return hex.EncodeToString(h[:])
+}
+
+func (ev *Event) internetGatewayByVPCID(svc ec2iface.EC2API, vpc string) (*ec2.InternetGateway, error) {
 	req := ec2.DescribeInternetGatewaysInput{
-		Filters: f,
+		Filters: filters(map[string][]string{
+			"attachment.vpc-id": {vpc},
+		}),
 	}
 
 	resp, err := svc.DescribeInternetGateways(&req)
@@ -269,16 +1072,11 @@ func (ev *Event) internetGatewayByVPCID(svc *ec2.EC2, vpc string) (*ec2.Internet
 	return resp.InternetGateways[0], nil
 }
 
-func (ev *Event) routingTableBySubnetID(svc *ec2.EC2, subnet string) (*ec2.RouteTable, error) {
-	f := []*ec2.Filter{
-		&ec2.Filter{
-			Name:   aws.String("association.subnet-id"),
-			Values: []*string{aws.String(subnet)},
-		},
-	}
-
+func (ev *Event) routingTableBySubnetID(svc ec2iface.EC2API, subnet string) (*ec2.RouteTable, error) {
 	req := ec2.DescribeRouteTablesInput{
-		Filters: f,
+		Filters: filters(map[string][]string{
+			"association.subnet-id": {subnet},
+		}),
 	}
 
 	resp, err := svc.DescribeRouteTables(&req)
@@ -286,21 +1084,50 @@ func (ev *Event) routingTableBySubnetID(svc *ec2.EC2, subnet string) (*ec2.Route
 		return nil, err
 	}
 
-	if len(resp.RouteTables) == 0 {
+	switch len(resp.RouteTables) {
+	case 0:
 		return nil, nil
+	case 1:
+		return resp.RouteTables[0], nil
+	}
+
+	// A subnet is only associated with one route table, but aws can report
+	// more while associations change, so the same one is always chosen
+	tables := append([]*ec2.RouteTable{}, resp.RouteTables...)
+	sort.Slice(tables, func(i, j int) bool {
+		return aws.StringValue(tables[i].RouteTableId) < aws.StringValue(tables[j].RouteTableId)
+	})
+
+	var ids []string
+	for _, rt := range tables {
+		ids = append(ids, aws.StringValue(rt.RouteTableId))
+	}
+
+	if ev.StrictRouteTables {
+		return nil, fmt.Errorf("Network %s matches more than one route table: %s", subnet, strings.Join(ids, ", "))
 	}
 
-	return resp.RouteTables[0], nil
+	log.Printf("Network %s matches more than one route table: %s, using %s", subnet, strings.Join(ids, ", "), ids[0])
+
+	return tables[0], nil
 }
 
-func (ev *Event) createInternetGateway(svc *ec2.EC2) (string, error) {
+func (ev *Event) createInternetGateway(svc ec2iface.EC2API) (string, error) {
 	ig, err := ev.internetGatewayByVPCID(svc, ev.VPCID)
 	if err != nil {
 		return "", err
 	}
 
 	if ig != nil {
-		return *ig.InternetGatewayId, nil
+		id, err := responseString("internet gateway id", ig.InternetGatewayId)
+		if err != nil {
+			return "", err
+		}
+
+		ev.InternetGatewayState = ev.attachmentState(ig)
+		ev.InternetGatewayCreated = aws.Bool(false)
+
+		return id, ev.tagOwnership(svc, ig.InternetGatewayId, ig.Tags)
 	}
 
 	resp, err := svc.CreateInternetGateway(nil)
@@ -308,8 +1135,19 @@ func (ev *Event) createInternetGateway(svc *ec2.EC2) (string, error) {
 		return "", err
 	}
 
+	if resp.InternetGateway == nil {
+		return "", missingField("internet gateway")
+	}
+
+	id, err := responseString("internet gateway id", resp.InternetGateway.InternetGatewayId)
+	if err != nil {
+		return "", err
+	}
+	ev.track("internet_gateway", id, ev.rollbackInternetGateway(id))
+	ev.InternetGatewayCreated = aws.Bool(true)
+
 	req := ec2.AttachInternetGatewayInput{
-		InternetGatewayId: resp.InternetGateway.InternetGatewayId,
+		InternetGatewayId: aws.String(id),
 		VpcId:             aws.String(ev.VPCID),
 	}
 
@@ -318,17 +1156,76 @@ func (ev *Event) createInternetGateway(svc *ec2.EC2) (string, error) {
 		return "", err
 	}
 
-	return *resp.InternetGateway.InternetGatewayId, nil
+	// the attachment is reported so consumers know egress is ready
+	ig, err = ev.internetGatewayByID(svc, id)
+	if err != nil {
+		return "", err
+	}
+
+	if ig != nil {
+		ev.InternetGatewayState = ev.attachmentState(ig)
+	}
+
+	return id, ev.tagCreated(svc, aws.String(id), "igw", ev.PublicNetworkAWSID)
+}
+
+func (ev *Event) internetGatewayByID(svc ec2iface.EC2API, id string) (*ec2.InternetGateway, error) {
+	req := ec2.DescribeInternetGatewaysInput{
+		Filters: filters(map[string][]string{
+			"internet-gateway-id": {id},
+		}),
+	}
+
+	resp, err := svc.DescribeInternetGateways(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.InternetGateways) == 0 {
+		return nil, nil
+	}
+
+	return resp.InternetGateways[0], nil
+}
+
+// attachmentState returns the state of the internet gateway attachment to
+// the event vpc, which is available once attached
+func (ev *Event) attachmentState(ig *ec2.InternetGateway) string {
+	for _, a := range ig.Attachments {
+		if aws.StringValue(a.VpcId) == ev.VPCID {
+			return aws.StringValue(a.State)
+		}
+	}
+
+	return ec2.AttachmentStatusDetached
 }
 
-func (ev *Event) createRouteTable(svc *ec2.EC2, subnet string) (*ec2.RouteTable, error) {
+func (ev *Event) createRouteTable(svc ec2iface.EC2API, subnet string) (*ec2.RouteTable, error) {
+	if ev.RouteTableTag != "" {
+		return ev.taggedRouteTable(svc, subnet)
+	}
+
 	rt, err := ev.routingTableBySubnetID(svc, subnet)
 	if err != nil {
 		return nil, err
 	}
 
 	if rt != nil {
-		return rt, nil
+		ev.setRouteTable(subnet, rt)
+		ev.reportRouteTableSubnets(rt)
+		return rt, ev.tagOwnership(svc, rt.RouteTableId, rt.Tags)
+	}
+
+	// A subnet without its own table falls back on the vpc main one, so a
+	// vpc without either is unusual enough not to work around unasked
+	if ev.NoRouteTablePolicy != noRouteTableCreate {
+		main, err := ev.mainRouteTable(svc)
+		if err != nil {
+			return nil, err
+		}
+		if main == nil {
+			return nil, fmt.Errorf("Network %s has no associated route table and vpc %s has no main route table", subnet, ev.VPCID)
+		}
 	}
 
 	req := ec2.CreateRouteTableInput{
@@ -340,65 +1237,206 @@ func (ev *Event) createRouteTable(svc *ec2.EC2, subnet string) (*ec2.RouteTable,
 		return nil, err
 	}
 
+	if resp.RouteTable == nil || resp.RouteTable.RouteTableId == nil {
+		return nil, missingField("route table id")
+	}
+
+	created := &ec2.RouteTable{RouteTableId: resp.RouteTable.RouteTableId}
+	ev.track("route_table", aws.StringValue(created.RouteTableId), func(svc ec2iface.EC2API) error {
+		return ev.deleteRouteTable(svc, created)
+	})
+
 	acreq := ec2.AssociateRouteTableInput{
 		RouteTableId: resp.RouteTable.RouteTableId,
 		SubnetId:     aws.String(subnet),
 	}
 
-	_, err = svc.AssociateRouteTable(&acreq)
+	acresp, err := svc.AssociateRouteTable(&acreq)
 	if err != nil {
 		return nil, err
 	}
 
-	return resp.RouteTable, nil
+	if acresp != nil {
+		created.Associations = []*ec2.RouteTableAssociation{
+			&ec2.RouteTableAssociation{RouteTableAssociationId: acresp.AssociationId},
+		}
+	}
+
+	ev.setRouteTable(subnet, resp.RouteTable)
+
+	return resp.RouteTable, ev.tagCreated(svc, resp.RouteTable.RouteTableId, "rtb", subnet)
+}
+
+// setRouteTable records the route table a routed network uses
+func (ev *Event) setRouteTable(subnet string, rt *ec2.RouteTable) {
+	if ev.RouteTables == nil {
+		ev.RouteTables = make(map[string]string)
+	}
+
+	ev.RouteTables[subnet] = aws.StringValue(rt.RouteTableId)
 }
 
-func (ev *Event) createNatGatewayRoutes(svc *ec2.EC2, rt *ec2.RouteTable, gwID string) error {
+func (ev *Event) createNatGatewayRoutes(svc ec2iface.EC2API, subnet string, rt *ec2.RouteTable, target routeTargetSpec) error {
 	req := ec2.CreateRouteInput{
 		RouteTableId:         rt.RouteTableId,
-		DestinationCidrBlock: aws.String("0.0.0.0/0"),
-		NatGatewayId:         aws.String(gwID),
+		DestinationCidrBlock: aws.String(defaultRoute),
 	}
+	req.NatGatewayId, req.NetworkInterfaceId, req.InstanceId = target.fields()
 
 	_, err := svc.CreateRoute(&req)
+	if !isAWSError(err, "InvalidRouteTableID.NotFound") {
+		return err
+	}
+
+	// The route table was removed since it was discovered, so
+	// rediscover or recreate it and try once more
+	rt, err = ev.createRouteTable(svc, subnet)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	req.RouteTableId = rt.RouteTableId
+	_, err = svc.CreateRoute(&req)
+
+	return err
 }
 
-func (ev *Event) isNatGatewayDeleted(svc *ec2.EC2, id string) bool {
-	gw, _ := ev.natGatewayByID(svc, id)
-	if *gw.State == ec2.NatGatewayStateDeleted {
-		return true
+func (ev *Event) isNatGatewayDeleted(svc ec2iface.EC2API, id string) (bool, error) {
+	gw, err := ev.natGatewayByID(svc, id)
+	if err != nil {
+		return false, err
 	}
 
-	return false
+	return aws.StringValue(gw.State) == ec2.NatGatewayStateDeleted, nil
 }
 
-func (ev *Event) routeTableIsConfigured(rt *ec2.RouteTable) bool {
-	gwID := ev.NatGatewayAWSID
-	for _, route := range rt.Routes {
-		if *route.DestinationCidrBlock == "0.0.0.0/0" && *route.NatGatewayId == gwID {
-			return true
-		}
+func (ev *Event) natGatewayByID(svc ec2iface.EC2API, id string) (*ec2.NatGateway, error) {
+	req := ec2.DescribeNatGatewaysInput{
+		NatGatewayIds: []*string{aws.String(id)},
 	}
-	return false
+	resp, err := svc.DescribeNatGateways(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.NatGateways) != 1 || resp.NatGateways[0] == nil {
+		return nil, ErrNatGatewayNotFound
+	}
+
+	return resp.NatGateways[0], nil
 }
 
-func (ev *Event) natGatewayByID(svc *ec2.EC2, id string) (*ec2.NatGateway, error) {
+// natGatewayBySubnetID returns the nat gateway placed on a network,
+// ignoring deleted ones
+func (ev *Event) natGatewayBySubnetID(svc ec2iface.EC2API, subnet string) (*ec2.NatGateway, error) {
 	req := ec2.DescribeNatGatewaysInput{
-		NatGatewayIds: []*string{aws.String(id)},
+		Filter: filters(map[string][]string{
+			"subnet-id": {subnet},
+			"state": {
+				ec2.NatGatewayStatePending,
+				ec2.NatGatewayStateAvailable,
+				ec2.NatGatewayStateFailed,
+				ec2.NatGatewayStateDeleting,
+			},
+		}),
 	}
 	resp, err := svc.DescribeNatGateways(&req)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(resp.NatGateways) != 1 {
-		return nil, errors.New("Could not find nat gateway")
+	if len(resp.NatGateways) != 1 || resp.NatGateways[0] == nil {
+		return nil, ErrNatGatewayNotFound
 	}
 
 	return resp.NatGateways[0], nil
 }
+
+// missingField builds the error returned when an aws response lacks a field
+func missingField(field string) error {
+	return fmt.Errorf("aws response is missing the %s", field)
+}
+
+// responseString returns the value of a string field from an aws response,
+// erroring instead of panicking when aws did not return it
+func responseString(field string, v *string) (string, error) {
+	if v == nil {
+		return "", missingField(field)
+	}
+
+	return *v, nil
+}
+
+// timestamp formats an aws time as RFC 3339 in UTC
+func timestamp(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// filters builds the ec2 describe filters for a set of names and values,
+// sorted by name so the resulting requests are deterministic
+func filters(f map[string][]string) []*ec2.Filter {
+	// only resources with the scope tag are ever described
+	if scopeTag != nil {
+		scoped := map[string][]string{
+			"tag:" + aws.StringValue(scopeTag.Key): {aws.StringValue(scopeTag.Value)},
+		}
+		for name, values := range f {
+			if _, ok := scoped[name]; !ok {
+				scoped[name] = values
+			}
+		}
+		f = scoped
+	}
+
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ef := make([]*ec2.Filter, 0, len(names))
+	for _, name := range names {
+		ef = append(ef, &ec2.Filter{
+			Name:   aws.String(name),
+			Values: aws.StringSlice(f[name]),
+		})
+	}
+
+	return ef
+}
+
+// isAWSError checks if an error was returned by aws with the given code
+func isAWSError(err error, code string) bool {
+	aerr, ok := err.(awserr.Error)
+
+	return ok && aerr.Code() == code
+}
+
+// Classes of the errors reported on the event
+const (
+	// errorClassValidation : the event itself is invalid
+	errorClassValidation = "validation"
+	// errorClassClient : aws refused the request, it needs fixing
+	errorClassClient = "client"
+	// errorClassServer : aws failed, the request can be retried
+	errorClassServer = "server"
+)
+
+// errorClass classifies an aws error by its http status, so consumers
+// know whether retrying can help. Other errors are not classified.
+func errorClass(err error) string {
+	if rerr, ok := err.(awserr.RequestFailure); ok {
+		if rerr.StatusCode() >= 500 {
+			return errorClassServer
+		}
+		if rerr.StatusCode() >= 400 {
+			return errorClassClient
+		}
+	}
+
+	return ""
+}
@@ -13,6 +13,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	ecc "github.com/ernestio/ernest-config-client"
 	"github.com/nats-io/nats"
 
@@ -106,9 +109,12 @@ func TestEventCreation(t *testing.T) {
 				e.Process()
 				e.Complete()
 				Convey("It should produce a nat.create.aws.done event", func() {
+					expected := testEvent
+					expected.Action = "create"
+					done, _ := json.Marshal(expected)
 					msg, timeout := waitMsg(completed)
 					So(msg, ShouldNotBeNil)
-					So(string(msg.Data), ShouldEqual, string(valid))
+					So(string(msg.Data), ShouldEqual, string(done))
 					So(timeout, ShouldBeNil)
 					msg, timeout = waitMsg(errored)
 					So(msg, ShouldBeNil)
@@ -281,9 +287,12 @@ func TestEventDeletion(t *testing.T) {
 				e.Process()
 				e.Complete()
 				Convey("It should produce a nat.delete.aws.done event", func() {
+					expected := testEvent
+					expected.Action = "delete"
+					done, _ := json.Marshal(expected)
 					msg, timeout := waitMsg(completed)
 					So(msg, ShouldNotBeNil)
-					So(string(msg.Data), ShouldEqual, string(valid))
+					So(string(msg.Data), ShouldEqual, string(done))
 					So(timeout, ShouldBeNil)
 					msg, timeout = waitMsg(errored)
 					So(msg, ShouldBeNil)
@@ -442,9 +451,12 @@ func TestEventModification(t *testing.T) {
 				e.Process()
 				e.Complete()
 				Convey("It should produce a nat.update.aws.done event", func() {
+					expected := testEvent
+					expected.Action = "update"
+					done, _ := json.Marshal(expected)
 					msg, timeout := waitMsg(completed)
 					So(msg, ShouldNotBeNil)
-					So(string(msg.Data), ShouldEqual, string(valid))
+					So(string(msg.Data), ShouldEqual, string(done))
 					So(timeout, ShouldBeNil)
 					msg, timeout = waitMsg(errored)
 					So(msg, ShouldBeNil)
@@ -568,3 +580,928 @@ func TestEventModification(t *testing.T) {
 
 	})
 }
+
+func TestEventCreateAllocation(t *testing.T) {
+	subject := "nat.create.aws"
+
+	Convey("Given a valid create event", t, func() {
+		valid, _ := json.Marshal(testEvent)
+		e := New(subject, valid)
+		e.Process()
+
+		f := newFakeEC2()
+		useFake(f)
+
+		Convey("When the allocation response has no public ip", func() {
+			f.hooks["AllocateAddress"] = func(input interface{}) (interface{}, error) {
+				return &ec2.AllocateAddressOutput{AllocationId: aws.String("eipalloc-00000000")}, nil
+			}
+			err := e.Create()
+
+			Convey("It should error without creating the nat gateway", func() {
+				So(err, ShouldEqual, ErrAllocationIncomplete)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the allocation response is complete", func() {
+			err := e.Create()
+
+			Convey("It should create the nat gateway", func() {
+				So(err, ShouldBeNil)
+				So(e.NatGatewayAllocationID, ShouldNotBeEmpty)
+				So(e.NatGatewayAllocationIP, ShouldNotBeEmpty)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestEventNilResponses(t *testing.T) {
+	deletePollInterval = time.Millisecond
+	deleteGracePeriod = 0
+
+	Convey("Given an event", t, func() {
+		valid, _ := json.Marshal(testEvent)
+		f := newFakeEC2()
+		useFake(f)
+
+		Convey("When creating and aws returns a nat gateway without id", func() {
+			f.hooks["CreateNatGateway"] = func(input interface{}) (interface{}, error) {
+				return &ec2.CreateNatGatewayOutput{NatGateway: &ec2.NatGateway{}}, nil
+			}
+			e := New("nat.create.aws", valid)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should error gracefully", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "aws response is missing the nat gateway id")
+			})
+		})
+
+		Convey("When creating and aws returns no nat gateway", func() {
+			f.hooks["CreateNatGateway"] = func(input interface{}) (interface{}, error) {
+				return &ec2.CreateNatGatewayOutput{}, nil
+			}
+			e := New("nat.create.aws", valid)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should error gracefully", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "aws response is missing the nat gateway")
+			})
+		})
+
+		Convey("When creating and the existing internet gateway has no id", func() {
+			f.hooks["DescribeInternetGateways"] = func(input interface{}) (interface{}, error) {
+				return &ec2.DescribeInternetGatewaysOutput{
+					InternetGateways: []*ec2.InternetGateway{&ec2.InternetGateway{}},
+				}, nil
+			}
+			e := New("nat.create.aws", valid)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should error gracefully", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "aws response is missing the internet gateway id")
+			})
+		})
+
+		Convey("When creating and aws returns no route table", func() {
+			f.hooks["CreateRouteTable"] = func(input interface{}) (interface{}, error) {
+				return &ec2.CreateRouteTableOutput{}, nil
+			}
+			e := New("nat.create.aws", valid)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should error gracefully", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "aws response is missing the route table id")
+			})
+		})
+
+		Convey("When updating a route table with routes not targeting a nat gateway", func() {
+			f.routeTables = append(f.routeTables, &ec2.RouteTable{
+				RouteTableId: aws.String("rtb-00000001"),
+				Associations: []*ec2.RouteTableAssociation{
+					&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000001")},
+				},
+				Routes: []*ec2.Route{
+					&ec2.Route{DestinationIpv6CidrBlock: aws.String("::/0"), GatewayId: aws.String("igw-00000000")},
+					&ec2.Route{DestinationCidrBlock: aws.String("10.0.0.0/16"), GatewayId: aws.String("local")},
+				},
+			})
+			withNatGateway(f)
+			e := New("nat.update.aws", valid)
+			e.Process()
+			err := e.Update()
+
+			Convey("It should add the nat gateway route", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateRoute"), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When deleting and the nat gateway can't be described", func() {
+			f.errors["DescribeNatGateways"] = errors.New("describe failed")
+			e := New("nat.delete.aws", valid)
+			e.Process()
+			err := e.Delete()
+
+			Convey("It should return the error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "describe failed")
+			})
+		})
+
+		Convey("When deleting and the nat gateway has no state", func() {
+			described := 0
+			f.hooks["DescribeNatGateways"] = func(input interface{}) (interface{}, error) {
+				described++
+				gw := &ec2.NatGateway{NatGatewayId: aws.String("nat-00000000")}
+				if described > 1 {
+					gw.State = aws.String(ec2.NatGatewayStateDeleted)
+				}
+				return &ec2.DescribeNatGatewaysOutput{NatGateways: []*ec2.NatGateway{gw}}, nil
+			}
+			e := New("nat.delete.aws", valid)
+			e.Process()
+			err := e.Delete()
+
+			Convey("It should keep polling until it is deleted", func() {
+				So(err, ShouldBeNil)
+				So(described, ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func TestEventCreateClientToken(t *testing.T) {
+	subject := "nat.create.aws"
+
+	Convey("Given a create event", t, func() {
+		valid, _ := json.Marshal(testEvent)
+		f := newFakeEC2()
+		useFake(f)
+
+		Convey("When creating it", func() {
+			e := New(subject, valid)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should store the client token on the payload", func() {
+				So(err, ShouldBeNil)
+				So(len(e.ClientToken), ShouldEqual, 64)
+				data, _ := json.Marshal(e)
+				So(string(data), ShouldContainSubstring, `"client_token":"`+e.ClientToken+`"`)
+				So(string(data), ShouldContainSubstring, `"nat_gateway_create_time":"2017-03-01T10:30:00Z"`)
+			})
+		})
+
+		Convey("When the first attempt fails and is retried by another process", func() {
+			f.errors["WaitUntilNatGatewayAvailable"] = errors.New("timeout")
+			first := New(subject, valid)
+			first.Process()
+			err := first.Create()
+			So(err, ShouldNotBeNil)
+
+			// the retry is built from the error payload of the first attempt
+			payload, _ := json.Marshal(first)
+			delete(f.errors, "WaitUntilNatGatewayAvailable")
+			retry := New(subject, payload)
+			retry.Process()
+			err = retry.Create()
+
+			Convey("It should reuse the token, allocation and nat gateway", func() {
+				So(err, ShouldBeNil)
+				So(retry.ClientToken, ShouldEqual, first.ClientToken)
+				So(retry.NatGatewayAllocationID, ShouldEqual, first.NatGatewayAllocationID)
+				So(retry.NatGatewayAWSID, ShouldEqual, first.NatGatewayAWSID)
+				So(f.Count("AllocateAddress"), ShouldEqual, 1)
+				So(len(f.natGateways), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When two different events are created", func() {
+			other := testEvent
+			other.UUID = "other"
+			otherData, _ := json.Marshal(other)
+
+			a := New(subject, valid)
+			a.Process()
+			b := New(subject, otherData)
+			b.Process()
+
+			Convey("They should derive different tokens", func() {
+				So(a.clientToken(), ShouldNotEqual, b.clientToken())
+			})
+		})
+	})
+}
+
+func TestEventPrivateConnectivity(t *testing.T) {
+	subject := "nat.create.aws"
+
+	Convey("Given a private create event", t, func() {
+		private := testEvent
+		private.ConnectivityType = "private"
+		data, _ := json.Marshal(private)
+		f := newFakeEC2()
+		useFake(f)
+
+		Convey("When validating the event", func() {
+			e := New(subject, data)
+			e.Process()
+			err := e.Validate()
+
+			Convey("It should not error", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When creating the event", func() {
+			var req *ec2.CreateNatGatewayInput
+			f.hooks["CreateNatGateway"] = func(input interface{}) (interface{}, error) {
+				req = input.(*ec2.CreateNatGatewayInput)
+				gw := &ec2.NatGateway{
+					NatGatewayId: aws.String("nat-00000001"),
+					State:        aws.String(ec2.NatGatewayStateAvailable),
+				}
+				f.natGateways = append(f.natGateways, gw)
+				return &ec2.CreateNatGatewayOutput{NatGateway: gw}, nil
+			}
+			e := New(subject, data)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should not allocate an elastic ip or internet gateway", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("AllocateAddress"), ShouldEqual, 0)
+				So(f.Count("CreateInternetGateway"), ShouldEqual, 0)
+				So(e.NatGatewayAllocationID, ShouldBeEmpty)
+				So(e.NatGatewayAllocationIP, ShouldBeEmpty)
+				So(e.InternetGatewayID, ShouldBeEmpty)
+			})
+
+			Convey("It should report no internet gateway was required", func() {
+				So(*e.InternetGatewayNeeded, ShouldBeFalse)
+				So(e.InternetGatewayCreated, ShouldBeNil)
+			})
+
+			Convey("It should create a private nat gateway", func() {
+				So(aws.StringValue(req.ConnectivityType), ShouldEqual, "private")
+				So(req.AllocationId, ShouldBeNil)
+				So(e.NatGatewayAWSID, ShouldEqual, "nat-00000001")
+			})
+		})
+	})
+
+	Convey("Given an event with an unknown connectivity type", t, func() {
+		invalid := testEvent
+		invalid.ConnectivityType = "internal"
+		data, _ := json.Marshal(invalid)
+
+		Convey("When validating the event", func() {
+			e := New(subject, data)
+			e.Process()
+			err := e.Validate()
+
+			Convey("It should error", func() {
+				So(err, ShouldEqual, ErrConnectivityTypeInvalid)
+			})
+		})
+	})
+}
+
+func TestFilters(t *testing.T) {
+	Convey("Given a set of filter names and values", t, func() {
+		f := map[string][]string{
+			"vpc-id":                {"vpc-0000000"},
+			"association.subnet-id": {"subnet-00000000", "subnet-00000001"},
+		}
+
+		Convey("When building the ec2 filters", func() {
+			ef := filters(f)
+
+			Convey("It should build a filter per name sorted by name", func() {
+				So(len(ef), ShouldEqual, 2)
+				So(*ef[0].Name, ShouldEqual, "association.subnet-id")
+				So(aws.StringValueSlice(ef[0].Values), ShouldResemble, []string{"subnet-00000000", "subnet-00000001"})
+				So(*ef[1].Name, ShouldEqual, "vpc-id")
+				So(aws.StringValueSlice(ef[1].Values), ShouldResemble, []string{"vpc-0000000"})
+			})
+		})
+
+		Convey("When building filters from nothing", func() {
+			ef := filters(nil)
+
+			Convey("It should return no filters", func() {
+				So(len(ef), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestEventExclusiveFields(t *testing.T) {
+	subject := "nat.create.aws"
+
+	validate := func(ev Event) error {
+		data, _ := json.Marshal(ev)
+		e := New(subject, data)
+		e.Process()
+		return e.Validate()
+	}
+
+	Convey("Given an event with conflicting fields", t, func() {
+		Convey("When it targets a region and a list of regions", func() {
+			ev := testEvent
+			ev.DatacenterRegions = []string{"eu-west-2"}
+
+			Convey("It should error", func() {
+				So(validate(ev), ShouldEqual, ErrRegionsConflict)
+			})
+		})
+
+		Convey("When it is private and provides an elastic ip allocation", func() {
+			ev := testEvent
+			ev.ConnectivityType = "private"
+			ev.NatGatewayAllocationID = "eipalloc-00000000"
+
+			Convey("It should error", func() {
+				So(validate(ev), ShouldEqual, ErrPrivateAllocation)
+			})
+		})
+
+		Convey("When it is private and ensures the internet route", func() {
+			ev := testEvent
+			ev.ConnectivityType = "private"
+			ev.EnsureInternetRoute = true
+
+			Convey("It should error", func() {
+				So(validate(ev), ShouldEqual, ErrPrivateInternetRoute)
+			})
+		})
+	})
+
+	Convey("Given a public event with an elastic ip allocation", t, func() {
+		ev := testEvent
+		ev.ConnectivityType = "public"
+		ev.NatGatewayAllocationID = "eipalloc-00000000"
+		ev.EnsureInternetRoute = true
+
+		Convey("When validating the event", func() {
+			Convey("It should not error", func() {
+				So(validate(ev), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestEventGet(t *testing.T) {
+	subject := "nat.get.aws"
+
+	Convey("Given a get event", t, func() {
+		data, _ := json.Marshal(testEvent)
+		f := newFakeEC2()
+		useFake(f)
+
+		gw := &ec2.NatGateway{
+			NatGatewayId: aws.String(testEvent.NatGatewayAWSID),
+			SubnetId:     aws.String("subnet-00000000"),
+			State:        aws.String(ec2.NatGatewayStateAvailable),
+			CreateTime:   aws.Time(time.Date(2017, 3, 1, 11, 30, 0, 0, time.FixedZone("CET", 3600))),
+			NatGatewayAddresses: []*ec2.NatGatewayAddress{
+				&ec2.NatGatewayAddress{AllocationId: aws.String("eipalloc-00000000"), PublicIp: aws.String("52.0.0.1")},
+			},
+		}
+		f.natGateways = append(f.natGateways, gw)
+
+		e := New(subject, data)
+		e.Process()
+
+		Convey("When validating the event", func() {
+			Convey("It should only require the nat gateway id", func() {
+				e.PublicNetworkAWSID = ""
+				e.RoutedNetworkAWSIDs = nil
+				So(e.Validate(), ShouldBeNil)
+				e.NatGatewayAWSID = ""
+				So(e.Validate(), ShouldEqual, ErrNatGatewayIDInvalid)
+			})
+
+			Convey("It should accept the public network instead of the nat gateway id", func() {
+				e.NatGatewayAWSID = ""
+				e.RoutedNetworkAWSIDs = nil
+				So(e.Validate(), ShouldBeNil)
+			})
+		})
+
+		Convey("When the nat gateway has tags", func() {
+			gw.Tags = []*ec2.Tag{
+				&ec2.Tag{Key: aws.String("Name"), Value: aws.String("test-nat")},
+				&ec2.Tag{Key: aws.String("ernest"), Value: aws.String("true")},
+			}
+			err := e.Get()
+
+			Convey("It should report the nat gateway and its tags", func() {
+				So(err, ShouldBeNil)
+				So(e.NatGatewayState, ShouldEqual, "available")
+				So(e.NatGatewayAllocationID, ShouldEqual, "eipalloc-00000000")
+				So(e.NatGatewayAllocationIP, ShouldEqual, "52.0.0.1")
+				So(e.NatGatewayCreateTime, ShouldEqual, "2017-03-01T10:30:00Z")
+				So(*e.VPCDNSSupport, ShouldBeTrue)
+				So(*e.VPCDNSHostnames, ShouldBeFalse)
+				data, _ := json.Marshal(e)
+				So(string(data), ShouldContainSubstring, `"vpc_dns_support":true,"vpc_dns_hostnames":false`)
+				So(e.Tags, ShouldResemble, map[string]string{"Name": "test-nat", "ernest": "true"})
+			})
+		})
+
+		Convey("When looking it up by the network it is on", func() {
+			e.NatGatewayAWSID = ""
+			f.natGateways = append([]*ec2.NatGateway{&ec2.NatGateway{
+				NatGatewayId: aws.String("nat-deleted"),
+				SubnetId:     aws.String("subnet-00000000"),
+				State:        aws.String(ec2.NatGatewayStateDeleted),
+			}}, f.natGateways...)
+			err := e.Get()
+
+			Convey("It should report the nat gateway on that network", func() {
+				So(err, ShouldBeNil)
+				So(e.NatGatewayAWSID, ShouldEqual, testEvent.NatGatewayAWSID)
+				So(e.NatGatewayState, ShouldEqual, "available")
+			})
+		})
+
+		Convey("When the nat gateway has no tags", func() {
+			err := e.Get()
+
+			Convey("It should report no tags", func() {
+				So(err, ShouldBeNil)
+				So(e.NatGatewayState, ShouldEqual, "available")
+				So(len(e.Tags), ShouldEqual, 0)
+			})
+
+			Convey("It should report it was found", func() {
+				data, _ := json.Marshal(e)
+				So(string(data), ShouldContainSubstring, `"nat_gateway_state":"available","found":true`)
+			})
+		})
+
+		Convey("When the nat gateway no longer exists", func() {
+			f.natGateways = nil
+			err := e.Get()
+
+			Convey("It should report it as deleted and not found", func() {
+				So(err, ShouldBeNil)
+				data, _ := json.Marshal(e)
+				So(string(data), ShouldContainSubstring, `"nat_gateway_state":"deleted","found":false`)
+			})
+		})
+
+		Convey("When aws reports the nat gateway as not found", func() {
+			f.errors["DescribeNatGateways"] = awserr.New("NatGatewayNotFound", "The Nat Gateway nat-00000000 was not found", nil)
+			err := e.Get()
+
+			Convey("It should report it as deleted and not found", func() {
+				So(err, ShouldBeNil)
+				So(*e.Found, ShouldBeFalse)
+				So(e.NatGatewayState, ShouldEqual, "deleted")
+			})
+		})
+
+		Convey("When the nat gateway is deleted", func() {
+			gw.State = aws.String(ec2.NatGatewayStateDeleted)
+			err := e.Get()
+
+			Convey("It should report it as not found", func() {
+				So(err, ShouldBeNil)
+				So(*e.Found, ShouldBeFalse)
+				So(e.NatGatewayState, ShouldEqual, "deleted")
+			})
+		})
+
+		Convey("When the nat gateway failed", func() {
+			gw.State = aws.String(ec2.NatGatewayStateFailed)
+			gw.FailureCode = aws.String("InsufficientFreeAddressesInSubnet")
+			gw.FailureMessage = aws.String("Subnet subnet-00000000 has insufficient free addresses to create this NAT gateway")
+			err := e.Get()
+
+			Convey("It should report why", func() {
+				So(err, ShouldBeNil)
+				So(e.NatGatewayState, ShouldEqual, "failed")
+				So(e.FailureCode, ShouldEqual, "InsufficientFreeAddressesInSubnet")
+				So(e.FailureMessage, ShouldEqual, "Subnet subnet-00000000 has insufficient free addresses to create this NAT gateway")
+				data, _ := json.Marshal(e)
+				So(string(data), ShouldContainSubstring, `"failure_code":"InsufficientFreeAddressesInSubnet"`)
+			})
+		})
+
+		Convey("When the nat gateway failed without details", func() {
+			gw.State = aws.String(ec2.NatGatewayStateFailed)
+			err := e.Get()
+
+			Convey("It should report no failure details", func() {
+				So(err, ShouldBeNil)
+				So(e.NatGatewayState, ShouldEqual, "failed")
+				So(e.FailureCode, ShouldBeEmpty)
+				data, _ := json.Marshal(e)
+				So(string(data), ShouldNotContainSubstring, `"failure_`)
+			})
+		})
+	})
+}
+
+func TestEventInternetGateway(t *testing.T) {
+	subject := "nat.igw.aws"
+
+	Convey("Given an internet gateway event", t, func() {
+		ev := Event{
+			VPCID:                 "vpc-0000000",
+			DatacenterRegion:      "eu-west-1",
+			DatacenterAccessKey:   "key",
+			DatacenterAccessToken: "token",
+		}
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		useFake(f)
+
+		e := New(subject, data)
+		e.Process()
+
+		Convey("When validating the event", func() {
+			Convey("It should only require the vpc", func() {
+				So(e.Validate(), ShouldBeNil)
+				e.VPCID = ""
+				So(e.Validate(), ShouldEqual, ErrDatacenterIDInvalid)
+			})
+		})
+
+		Convey("When the vpc has no internet gateway", func() {
+			err := e.Execute("igw")
+
+			Convey("It should create and attach one", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateInternetGateway"), ShouldEqual, 1)
+				So(f.Count("AttachInternetGateway"), ShouldEqual, 1)
+				So(e.InternetGatewayID, ShouldEqual, *f.internetGateways[0].InternetGatewayId)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the vpc already has an internet gateway", func() {
+			f.internetGateways = append(f.internetGateways, &ec2.InternetGateway{
+				InternetGatewayId: aws.String("igw-existing"),
+				Attachments: []*ec2.InternetGatewayAttachment{
+					&ec2.InternetGatewayAttachment{VpcId: aws.String("vpc-0000000")},
+				},
+			})
+			err := e.Execute("igw")
+
+			Convey("It should reuse it", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateInternetGateway"), ShouldEqual, 0)
+				So(e.InternetGatewayID, ShouldEqual, "igw-existing")
+			})
+		})
+	})
+}
+
+func TestEventValidateAction(t *testing.T) {
+	Convey("Given an event", t, func() {
+		data, _ := json.Marshal(testEvent)
+
+		Convey("When its subject has an unknown action", func() {
+			e := New("nat.find.aws", data)
+			e.Process()
+
+			Convey("It should fail validation", func() {
+				So(e.Validate(), ShouldEqual, ErrActionInvalid)
+			})
+		})
+
+		Convey("When its subject is known", func() {
+			for _, action := range []string{"create", "update", "delete", "get", "igw"} {
+				e := New("nat."+action+".aws", data)
+				e.Process()
+				So(e.Validate(), ShouldBeNil)
+			}
+		})
+	})
+}
+
+func TestEventCreateWaitState(t *testing.T) {
+	Convey("Given a create event", t, func() {
+		valid, _ := json.Marshal(testEvent)
+		f := newFakeEC2()
+		useFake(f)
+
+		e := New("nat.create.aws", valid)
+		e.Process()
+
+		Convey("When the nat gateway is being deleted as the wait returns", func() {
+			f.hooks["WaitUntilNatGatewayAvailable"] = func(input interface{}) (interface{}, error) {
+				f.natGateways[0].State = aws.String(ec2.NatGatewayStateDeleting)
+				return nil, nil
+			}
+			err := e.Create()
+
+			Convey("It should fail without routing any network", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Nat gateway "+e.NatGatewayAWSID+" is deleting instead of available")
+				So(f.Count("CreateRoute"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the nat gateway is available after the wait", func() {
+			err := e.Create()
+
+			Convey("It should route the networks", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateRoute"), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When aws takes a while to provision the nat gateway", func() {
+			f.hooks["WaitUntilNatGatewayAvailable"] = func(input interface{}) (interface{}, error) {
+				time.Sleep(20 * time.Millisecond)
+				gw := f.natGateways[0]
+				gw.State = aws.String(ec2.NatGatewayStateAvailable)
+				gw.NatGatewayAddresses[0].PublicIp = aws.String("52.0.0.1")
+				return nil, nil
+			}
+			err := e.Execute("create")
+
+			Convey("It should report how long it waited", func() {
+				So(err, ShouldBeNil)
+				So(e.WaitMS, ShouldNotBeNil)
+				So(*e.WaitMS, ShouldBeGreaterThanOrEqualTo, 20)
+
+				payload, _ := json.Marshal(e)
+				So(string(payload), ShouldContainSubstring, `"wait_ms":`)
+			})
+		})
+	})
+}
+
+func TestNetworkBorderGroup(t *testing.T) {
+	Convey("Given an event allocating its elastic ip on a local zone", t, func() {
+		ev := testEvent
+		ev.DatacenterRegion = "us-west-2"
+		ev.NetworkBorderGroup = "us-west-2-lax-1"
+		f := newFakeEC2()
+		useFake(f)
+
+		var input *ec2.AllocateAddressInput
+		f.hooks["AllocateAddress"] = func(in interface{}) (interface{}, error) {
+			input, _ = in.(*ec2.AllocateAddressInput)
+			return &ec2.AllocateAddressOutput{
+				AllocationId: aws.String("eipalloc-00000000"),
+				PublicIp:     aws.String("52.0.0.1"),
+			}, nil
+		}
+
+		Convey("When creating the event", func() {
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+			So(e.Validate(), ShouldBeNil)
+			err := e.Create()
+
+			Convey("It should allocate the elastic ip on its network border group", func() {
+				So(err, ShouldBeNil)
+				So(input, ShouldNotBeNil)
+				So(aws.StringValue(input.NetworkBorderGroup), ShouldEqual, "us-west-2-lax-1")
+			})
+		})
+
+		Convey("When the network border group belongs to another region", func() {
+			ev.NetworkBorderGroup = "us-east-1-bos-1"
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+
+			Convey("It should error", func() {
+				So(e.Validate(), ShouldEqual, ErrNetworkBorderGroupInvalid)
+			})
+		})
+
+		Convey("When the network border group is the region itself", func() {
+			ev.NetworkBorderGroup = "us-west-2"
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+
+			Convey("It should be valid", func() {
+				So(e.Validate(), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestEventExpiry(t *testing.T) {
+	Convey("Given an event with an expiry", t, func() {
+		ev := testEvent
+
+		Convey("When it is validated before it expires", func() {
+			expires := time.Now().Add(time.Hour)
+			ev.ExpiresAt = &expires
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+
+			Convey("It should be valid", func() {
+				So(e.Validate(), ShouldBeNil)
+			})
+		})
+
+		Convey("When it is validated after it expired", func() {
+			expires := time.Date(2017, 3, 1, 11, 30, 0, 0, time.FixedZone("CET", 3600))
+			ev.ExpiresAt = &expires
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+
+			Convey("It should be rejected", func() {
+				err := e.Validate()
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Event expired at 2017-03-01T10:30:00Z")
+			})
+		})
+
+		Convey("When its expiry is read from a message", func() {
+			e := New("nat.create.aws", []byte(`{"expires_at":"2017-03-01T10:30:00Z"}`))
+			e.Process()
+
+			Convey("It should parse it", func() {
+				So(e.ExpiresAt, ShouldNotBeNil)
+				So(e.ExpiresAt.Equal(time.Date(2017, 3, 1, 10, 30, 0, 0, time.UTC)), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestErrorClass(t *testing.T) {
+	Convey("Given errors returned by aws", t, func() {
+		Convey("When aws refused the request", func() {
+			err := awserr.NewRequestFailure(awserr.New("InvalidSubnetID.NotFound", "The subnet ID 'subnet-00000000' does not exist", nil), 400, "request")
+
+			Convey("It should be a client error", func() {
+				So(errorClass(err), ShouldEqual, "client")
+			})
+		})
+
+		Convey("When the credentials are not allowed", func() {
+			err := awserr.NewRequestFailure(awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil), 403, "request")
+
+			Convey("It should be a client error", func() {
+				So(errorClass(err), ShouldEqual, "client")
+			})
+		})
+
+		Convey("When aws failed", func() {
+			err := awserr.NewRequestFailure(awserr.New("Unavailable", "The service is unavailable.", nil), 503, "request")
+
+			Convey("It should be a server error", func() {
+				So(errorClass(err), ShouldEqual, "server")
+			})
+		})
+
+		Convey("When the error doesn't come from aws", func() {
+			Convey("It should not be classified", func() {
+				So(errorClass(errors.New("Nat gateway nat-00000000 no longer exists")), ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestEventRetriedError(t *testing.T) {
+	Convey("Given a retry resending a previous error answer", t, func() {
+		log.SetOutput(ioutil.Discard)
+		published := make(map[string][]byte)
+		publish = func(subject string, data []byte) error {
+			published[subject] = data
+			return nil
+		}
+
+		ev := testEvent
+		ev.ErrorMessage = "Throttling: Rate exceeded"
+		ev.ErrorClass = "throttled"
+		ev.Suggestion = "Retry later"
+		data, _ := json.Marshal(ev)
+		e := New("nat.update.aws", data)
+		e.Process()
+
+		Convey("When it fails with an error that is not classified", func() {
+			e.Error(errors.New("Nat gateway nat-00000000 no longer exists"))
+
+			Convey("It should not report the previous class or suggestion", func() {
+				answer := string(published["nat.update.aws.error"])
+				So(answer, ShouldContainSubstring, `"error_message":"Nat gateway nat-00000000 no longer exists"`)
+				So(answer, ShouldNotContainSubstring, "error_class")
+				So(answer, ShouldNotContainSubstring, "suggestion")
+			})
+		})
+
+		Convey("When it completes", func() {
+			e.Complete()
+
+			Convey("It should not report the previous error", func() {
+				answer := string(published["nat.update.aws.done"])
+				So(answer, ShouldNotContainSubstring, "error_message")
+				So(answer, ShouldNotContainSubstring, "error_class")
+				So(answer, ShouldNotContainSubstring, "suggestion")
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stdout)
+			publish = func(subject string, data []byte) error {
+				return nc.Publish(subject, data)
+			}
+		})
+	})
+}
+
+func TestEventAction(t *testing.T) {
+	Convey("Given an event received on a subject", t, func() {
+		log.SetOutput(ioutil.Discard)
+		published := make(map[string][]byte)
+		publish = func(subject string, data []byte) error {
+			published[subject] = data
+			return nil
+		}
+
+		ev := testEvent
+		ev.Action = "delete"
+		data, _ := json.Marshal(ev)
+		e := New("nat.get.aws", data)
+		e.Process()
+
+		Convey("When it completes", func() {
+			e.Complete()
+
+			Convey("It should report the action of its subject", func() {
+				So(e.Action, ShouldEqual, "get")
+				So(string(published["nat.get.aws.done"]), ShouldContainSubstring, `"action":"get"`)
+			})
+		})
+
+		Convey("When it fails", func() {
+			e.Error(errors.New("failed"))
+
+			Convey("It should report the action of its subject", func() {
+				So(string(published["nat.get.aws.error"]), ShouldContainSubstring, `"action":"get"`)
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stdout)
+			publish = func(subject string, data []byte) error {
+				return nc.Publish(subject, data)
+			}
+		})
+	})
+}
+
+func TestInternetGatewayState(t *testing.T) {
+	Convey("Given a create event", t, func() {
+		data, _ := json.Marshal(testEvent)
+		f := newFakeEC2()
+		useFake(f)
+
+		e := New("nat.create.aws", data)
+		e.Process()
+
+		Convey("When the vpc has no internet gateway", func() {
+			err := e.Create()
+
+			Convey("It should report the one it attaches as available", func() {
+				So(err, ShouldBeNil)
+				So(e.InternetGatewayState, ShouldEqual, "available")
+
+				payload, _ := json.Marshal(e)
+				So(string(payload), ShouldContainSubstring, `"internet_gateway_state":"available"`)
+			})
+		})
+
+		Convey("When the vpc already has an internet gateway", func() {
+			f.internetGateways = append(f.internetGateways, &ec2.InternetGateway{
+				InternetGatewayId: aws.String("igw-existing"),
+				Attachments: []*ec2.InternetGatewayAttachment{
+					&ec2.InternetGatewayAttachment{VpcId: aws.String(testEvent.VPCID), State: aws.String("available")},
+				},
+			})
+			err := e.Create()
+
+			Convey("It should report its attachment state", func() {
+				So(err, ShouldBeNil)
+				So(e.InternetGatewayID, ShouldEqual, "igw-existing")
+				So(e.InternetGatewayState, ShouldEqual, "available")
+			})
+		})
+	})
+}
@@ -0,0 +1,903 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// fakeCreateTime is when the fake creates every nat gateway
+var fakeCreateTime = time.Date(2017, 3, 1, 10, 30, 0, 0, time.UTC)
+
+// fakeEC2 is an in memory ec2 used by the tests. It keeps just enough
+// state to let the connector create, update and delete nat gateways,
+// records every call made and lets tests override any call result.
+type fakeEC2 struct {
+	ec2iface.EC2API
+
+	mu               sync.Mutex
+	seq              int
+	calls            []string
+	errors           map[string]error
+	hooks            map[string]func(input interface{}) (interface{}, error)
+	internetGateways []*ec2.InternetGateway
+	routeTables      []*ec2.RouteTable
+	natGateways      []*ec2.NatGateway
+	clientTokens     map[string]*ec2.NatGateway
+	subnets          map[string]*ec2.Subnet
+	vpcAttributes    map[string]bool
+	flowLogs         map[string]*ec2.FlowLog
+	addresses        map[string]string
+	addressTags      map[string][]*ec2.Tag
+	addressPools     map[string]string
+	byoipCidrs       []*ec2.ByoipCidr
+	// strictSubnets makes subnets other than the known ones not exist
+	strictSubnets bool
+	// noMainRouteTable makes vpcs without a main route table among the
+	// known ones have none, as aws otherwise always creates one
+	noMainRouteTable bool
+}
+
+func newFakeEC2() *fakeEC2 {
+	return &fakeEC2{
+		errors:       make(map[string]error),
+		clientTokens: make(map[string]*ec2.NatGateway),
+		subnets:      make(map[string]*ec2.Subnet),
+		flowLogs:     make(map[string]*ec2.FlowLog),
+		addresses:    make(map[string]string),
+		addressTags:  make(map[string][]*ec2.Tag),
+		addressPools: make(map[string]string),
+		vpcAttributes: map[string]bool{
+			ec2.VpcAttributeNameEnableDnsSupport:   true,
+			ec2.VpcAttributeNameEnableDnsHostnames: false,
+		},
+		hooks: make(map[string]func(input interface{}) (interface{}, error)),
+	}
+}
+
+// withNatGateway adds the test event's nat gateway, available
+func withNatGateway(f *fakeEC2) *ec2.NatGateway {
+	gw := &ec2.NatGateway{
+		NatGatewayId: aws.String(testEvent.NatGatewayAWSID),
+		SubnetId:     aws.String(testEvent.PublicNetworkAWSID),
+		State:        aws.String(ec2.NatGatewayStateAvailable),
+		NatGatewayAddresses: []*ec2.NatGatewayAddress{
+			{AllocationId: aws.String("eipalloc-00000000"), PublicIp: aws.String("52.0.0.1")},
+		},
+	}
+	f.natGateways = append(f.natGateways, gw)
+
+	return gw
+}
+
+// useFake makes every event processed talk to the given fake
+func useFake(f *fakeEC2) {
+	ec2Client = func(ev *Event) (ec2iface.EC2API, error) {
+		return f, nil
+	}
+}
+
+func (f *fakeEC2) id(prefix string) string {
+	f.seq++
+	return fmt.Sprintf("%s-%08d", prefix, f.seq)
+}
+
+// call records a call and returns any overridden result for it. It is
+// called with the fake locked, but hooks run unlocked so they can inspect
+// the fake through its methods.
+func (f *fakeEC2) call(name string, input interface{}) (interface{}, bool, error) {
+	f.calls = append(f.calls, name)
+
+	if err, ok := f.errors[name]; ok {
+		return nil, true, err
+	}
+
+	if hook, ok := f.hooks[name]; ok {
+		// relocked even when the hook panics, as the caller unlocks it
+		f.mu.Unlock()
+		defer f.mu.Lock()
+		out, err := hook(input)
+		return out, true, err
+	}
+
+	return nil, false, nil
+}
+
+// Calls returns the names of the calls made, in order
+func (f *fakeEC2) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string{}, f.calls...)
+}
+
+// Count returns the number of times a call was made
+func (f *fakeEC2) Count(name string) int {
+	n := 0
+	for _, c := range f.Calls() {
+		if c == name {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeEC2) AllocateAddress(in *ec2.AllocateAddressInput) (*ec2.AllocateAddressOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("AllocateAddress", in); ok {
+		o, _ := out.(*ec2.AllocateAddressOutput)
+		return o, err
+	}
+
+	if in != nil && aws.BoolValue(in.DryRun) {
+		return nil, dryRunOperation()
+	}
+
+	id := f.id("eipalloc")
+	f.addresses[id] = fmt.Sprintf("52.0.0.%d", f.seq)
+
+	return &ec2.AllocateAddressOutput{
+		AllocationId: aws.String(id),
+		PublicIp:     aws.String(f.addresses[id]),
+	}, nil
+}
+
+func (f *fakeEC2) DescribeAddresses(in *ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("DescribeAddresses", in); ok {
+		o, _ := out.(*ec2.DescribeAddressesOutput)
+		return o, err
+	}
+
+	resp := ec2.DescribeAddressesOutput{}
+	for _, id := range in.AllocationIds {
+		pool, ok := f.addressPools[aws.StringValue(id)]
+		if !ok {
+			pool = "amazon"
+		}
+		resp.Addresses = append(resp.Addresses, &ec2.Address{
+			AllocationId:   id,
+			PublicIp:       aws.String(f.addresses[aws.StringValue(id)]),
+			PublicIpv4Pool: aws.String(pool),
+		})
+	}
+
+	return &resp, nil
+}
+
+func (f *fakeEC2) DescribeByoipCidrs(in *ec2.DescribeByoipCidrsInput) (*ec2.DescribeByoipCidrsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("DescribeByoipCidrs", in); ok {
+		o, _ := out.(*ec2.DescribeByoipCidrsOutput)
+		return o, err
+	}
+
+	return &ec2.DescribeByoipCidrsOutput{ByoipCidrs: f.byoipCidrs}, nil
+}
+
+func (f *fakeEC2) DescribeInternetGateways(in *ec2.DescribeInternetGatewaysInput) (*ec2.DescribeInternetGatewaysOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("DescribeInternetGateways", in); ok {
+		o, _ := out.(*ec2.DescribeInternetGatewaysOutput)
+		return o, err
+	}
+
+	resp := ec2.DescribeInternetGatewaysOutput{}
+	for _, ig := range f.internetGateways {
+		var vpcs []string
+		for _, a := range ig.Attachments {
+			vpcs = append(vpcs, aws.StringValue(a.VpcId))
+		}
+		attributes := map[string][]string{
+			"attachment.vpc-id":   vpcs,
+			"internet-gateway-id": {aws.StringValue(ig.InternetGatewayId)},
+		}
+		for _, t := range ig.Tags {
+			attributes["tag:"+aws.StringValue(t.Key)] = []string{aws.StringValue(t.Value)}
+		}
+		if matchFilters(in.Filters, attributes) {
+			resp.InternetGateways = append(resp.InternetGateways, ig)
+		}
+	}
+
+	return &resp, nil
+}
+
+func (f *fakeEC2) CreateInternetGateway(in *ec2.CreateInternetGatewayInput) (*ec2.CreateInternetGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("CreateInternetGateway", in); ok {
+		o, _ := out.(*ec2.CreateInternetGatewayOutput)
+		return o, err
+	}
+
+	if in != nil && aws.BoolValue(in.DryRun) {
+		return nil, dryRunOperation()
+	}
+
+	ig := &ec2.InternetGateway{InternetGatewayId: aws.String(f.id("igw"))}
+	f.internetGateways = append(f.internetGateways, ig)
+
+	return &ec2.CreateInternetGatewayOutput{InternetGateway: ig}, nil
+}
+
+func (f *fakeEC2) AttachInternetGateway(in *ec2.AttachInternetGatewayInput) (*ec2.AttachInternetGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("AttachInternetGateway", in); ok {
+		o, _ := out.(*ec2.AttachInternetGatewayOutput)
+		return o, err
+	}
+
+	for _, ig := range f.internetGateways {
+		if aws.StringValue(ig.InternetGatewayId) == aws.StringValue(in.InternetGatewayId) {
+			ig.Attachments = append(ig.Attachments, &ec2.InternetGatewayAttachment{
+				VpcId: in.VpcId,
+				State: aws.String("available"),
+			})
+		}
+	}
+
+	return &ec2.AttachInternetGatewayOutput{}, nil
+}
+
+func (f *fakeEC2) DetachInternetGateway(in *ec2.DetachInternetGatewayInput) (*ec2.DetachInternetGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("DetachInternetGateway", in); ok {
+		o, _ := out.(*ec2.DetachInternetGatewayOutput)
+		return o, err
+	}
+
+	for _, ig := range f.internetGateways {
+		if aws.StringValue(ig.InternetGatewayId) == aws.StringValue(in.InternetGatewayId) {
+			ig.Attachments = nil
+		}
+	}
+
+	return &ec2.DetachInternetGatewayOutput{}, nil
+}
+
+func (f *fakeEC2) DeleteInternetGateway(in *ec2.DeleteInternetGatewayInput) (*ec2.DeleteInternetGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("DeleteInternetGateway", in); ok {
+		o, _ := out.(*ec2.DeleteInternetGatewayOutput)
+		return o, err
+	}
+
+	for i, ig := range f.internetGateways {
+		if aws.StringValue(ig.InternetGatewayId) == aws.StringValue(in.InternetGatewayId) {
+			if len(ig.Attachments) > 0 {
+				return nil, awserr.New("DependencyViolation", "The internetGateway has dependencies and cannot be deleted.", nil)
+			}
+			f.internetGateways = append(f.internetGateways[:i], f.internetGateways[i+1:]...)
+			return &ec2.DeleteInternetGatewayOutput{}, nil
+		}
+	}
+
+	return nil, awserr.New("InvalidInternetGatewayID.NotFound", "internet gateway not found", nil)
+}
+
+func (f *fakeEC2) CreateNatGateway(in *ec2.CreateNatGatewayInput) (*ec2.CreateNatGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("CreateNatGateway", in); ok {
+		o, _ := out.(*ec2.CreateNatGatewayOutput)
+		return o, err
+	}
+
+	if in != nil && aws.BoolValue(in.DryRun) {
+		return nil, dryRunOperation()
+	}
+
+	if gw, ok := f.clientTokens[aws.StringValue(in.ClientToken)]; ok {
+		return &ec2.CreateNatGatewayOutput{NatGateway: gw, ClientToken: in.ClientToken}, nil
+	}
+
+	id := f.id("nat")
+	gw := &ec2.NatGateway{
+		NatGatewayId: aws.String(id),
+		SubnetId:     in.SubnetId,
+		State:        aws.String(ec2.NatGatewayStatePending),
+		CreateTime:   aws.Time(fakeCreateTime),
+		NatGatewayAddresses: []*ec2.NatGatewayAddress{
+			{AllocationId: in.AllocationId, NetworkInterfaceId: aws.String("eni-" + strings.TrimPrefix(id, "nat-"))},
+		},
+	}
+	f.natGateways = append(f.natGateways, gw)
+
+	if in.ClientToken != nil {
+		f.clientTokens[*in.ClientToken] = gw
+	}
+
+	return &ec2.CreateNatGatewayOutput{NatGateway: gw, ClientToken: in.ClientToken}, nil
+}
+
+func (f *fakeEC2) WaitUntilNatGatewayAvailable(in *ec2.DescribeNatGatewaysInput) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok, err := f.call("WaitUntilNatGatewayAvailable", in); ok {
+		return err
+	}
+
+	for _, gw := range f.natGateways {
+		for _, id := range in.NatGatewayIds {
+			if aws.StringValue(gw.NatGatewayId) == aws.StringValue(id) {
+				gw.State = aws.String(ec2.NatGatewayStateAvailable)
+				f.associateAddresses(gw)
+			}
+		}
+	}
+
+	return nil
+}
+
+// associateAddresses lists the public ip of the nat gateway elastic ips,
+// as aws does once it is available
+func (f *fakeEC2) associateAddresses(gw *ec2.NatGateway) {
+	for _, addr := range gw.NatGatewayAddresses {
+		if addr.AllocationId == nil || addr.PublicIp != nil {
+			continue
+		}
+
+		ip, ok := f.addresses[*addr.AllocationId]
+		if !ok {
+			ip = "52.0.1.1"
+		}
+		addr.PublicIp = aws.String(ip)
+	}
+}
+
+func (f *fakeEC2) DescribeNatGateways(in *ec2.DescribeNatGatewaysInput) (*ec2.DescribeNatGatewaysOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("DescribeNatGateways", in); ok {
+		o, _ := out.(*ec2.DescribeNatGatewaysOutput)
+		return o, err
+	}
+
+	resp := ec2.DescribeNatGatewaysOutput{}
+	for _, gw := range f.natGateways {
+		for _, id := range in.NatGatewayIds {
+			if aws.StringValue(gw.NatGatewayId) == aws.StringValue(id) {
+				resp.NatGateways = append(resp.NatGateways, gw)
+			}
+		}
+
+		attributes := map[string][]string{
+			"subnet-id": {aws.StringValue(gw.SubnetId)},
+			"state":     {aws.StringValue(gw.State)},
+		}
+		for _, t := range gw.Tags {
+			attributes["tag:"+aws.StringValue(t.Key)] = []string{aws.StringValue(t.Value)}
+		}
+		if len(in.NatGatewayIds) == 0 && matchFilters(in.Filter, attributes) {
+			resp.NatGateways = append(resp.NatGateways, gw)
+		}
+	}
+
+	return &resp, nil
+}
+
+func (f *fakeEC2) DeleteNatGateway(in *ec2.DeleteNatGatewayInput) (*ec2.DeleteNatGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("DeleteNatGateway", in); ok {
+		o, _ := out.(*ec2.DeleteNatGatewayOutput)
+		return o, err
+	}
+
+	if in != nil && aws.BoolValue(in.DryRun) {
+		return nil, dryRunOperation()
+	}
+
+	for _, gw := range f.natGateways {
+		if aws.StringValue(gw.NatGatewayId) == aws.StringValue(in.NatGatewayId) {
+			gw.State = aws.String(ec2.NatGatewayStateDeleted)
+		}
+	}
+
+	return &ec2.DeleteNatGatewayOutput{NatGatewayId: in.NatGatewayId}, nil
+}
+
+func (f *fakeEC2) DescribeRouteTables(in *ec2.DescribeRouteTablesInput) (*ec2.DescribeRouteTablesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("DescribeRouteTables", in); ok {
+		o, _ := out.(*ec2.DescribeRouteTablesOutput)
+		return o, err
+	}
+
+	resp := ec2.DescribeRouteTablesOutput{}
+	for _, rt := range f.routeTables {
+		var subnets []string
+		main := "false"
+		for _, a := range rt.Associations {
+			if aws.BoolValue(a.Main) {
+				main = "true"
+			}
+			subnets = append(subnets, aws.StringValue(a.SubnetId))
+		}
+		attributes := map[string][]string{
+			"association.subnet-id": subnets,
+			"association.main":      {main},
+			"vpc-id":                {aws.StringValue(rt.VpcId)},
+		}
+		for _, t := range rt.Tags {
+			attributes["tag:"+aws.StringValue(t.Key)] = []string{aws.StringValue(t.Value)}
+		}
+		if matchFilters(in.Filters, attributes) {
+			resp.RouteTables = append(resp.RouteTables, rt)
+		}
+	}
+
+	if len(resp.RouteTables) == 0 && !f.noMainRouteTable && mainFilter(in.Filters) {
+		resp.RouteTables = append(resp.RouteTables, &ec2.RouteTable{
+			RouteTableId: aws.String("rtb-main"),
+			VpcId:        aws.String(testEvent.VPCID),
+			Associations: []*ec2.RouteTableAssociation{
+				{Main: aws.Bool(true)},
+			},
+		})
+	}
+
+	return &resp, nil
+}
+
+// mainFilter checks if a request only looks for main route tables
+func mainFilter(f []*ec2.Filter) bool {
+	for _, filter := range f {
+		if aws.StringValue(filter.Name) == "association.main" {
+			return aws.StringValueSlice(filter.Values)[0] == "true"
+		}
+	}
+
+	return false
+}
+
+func (f *fakeEC2) CreateRouteTable(in *ec2.CreateRouteTableInput) (*ec2.CreateRouteTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("CreateRouteTable", in); ok {
+		o, _ := out.(*ec2.CreateRouteTableOutput)
+		return o, err
+	}
+
+	if in != nil && aws.BoolValue(in.DryRun) {
+		return nil, dryRunOperation()
+	}
+
+	rt := &ec2.RouteTable{
+		RouteTableId: aws.String(f.id("rtb")),
+		VpcId:        in.VpcId,
+	}
+	f.routeTables = append(f.routeTables, rt)
+
+	return &ec2.CreateRouteTableOutput{RouteTable: rt}, nil
+}
+
+func (f *fakeEC2) AssociateRouteTable(in *ec2.AssociateRouteTableInput) (*ec2.AssociateRouteTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("AssociateRouteTable", in); ok {
+		o, _ := out.(*ec2.AssociateRouteTableOutput)
+		return o, err
+	}
+
+	if _, ok := f.subnets[aws.StringValue(in.SubnetId)]; f.strictSubnets && !ok {
+		return nil, awserr.New("InvalidSubnetID.NotFound", "The subnet ID '"+aws.StringValue(in.SubnetId)+"' does not exist", nil)
+	}
+
+	id := f.id("rtbassoc")
+	for _, rt := range f.routeTables {
+		if aws.StringValue(rt.RouteTableId) == aws.StringValue(in.RouteTableId) {
+			rt.Associations = append(rt.Associations, &ec2.RouteTableAssociation{
+				RouteTableAssociationId: aws.String(id),
+				RouteTableId:            in.RouteTableId,
+				SubnetId:                in.SubnetId,
+			})
+		}
+	}
+
+	return &ec2.AssociateRouteTableOutput{AssociationId: aws.String(id)}, nil
+}
+
+func (f *fakeEC2) ReplaceRouteTableAssociation(in *ec2.ReplaceRouteTableAssociationInput) (*ec2.ReplaceRouteTableAssociationOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("ReplaceRouteTableAssociation", in); ok {
+		o, _ := out.(*ec2.ReplaceRouteTableAssociationOutput)
+		return o, err
+	}
+
+	var assoc *ec2.RouteTableAssociation
+	for _, rt := range f.routeTables {
+		for i, a := range rt.Associations {
+			if aws.StringValue(a.RouteTableAssociationId) == aws.StringValue(in.AssociationId) {
+				assoc = a
+				rt.Associations = append(rt.Associations[:i], rt.Associations[i+1:]...)
+				break
+			}
+		}
+	}
+
+	if assoc == nil {
+		return nil, awserr.New("InvalidAssociationID.NotFound", "association not found", nil)
+	}
+
+	id := f.id("rtbassoc")
+	for _, rt := range f.routeTables {
+		if aws.StringValue(rt.RouteTableId) == aws.StringValue(in.RouteTableId) {
+			rt.Associations = append(rt.Associations, &ec2.RouteTableAssociation{
+				RouteTableAssociationId: aws.String(id),
+				RouteTableId:            in.RouteTableId,
+				SubnetId:                assoc.SubnetId,
+			})
+		}
+	}
+
+	return &ec2.ReplaceRouteTableAssociationOutput{NewAssociationId: aws.String(id)}, nil
+}
+
+func (f *fakeEC2) CreateRoute(in *ec2.CreateRouteInput) (*ec2.CreateRouteOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("CreateRoute", in); ok {
+		o, _ := out.(*ec2.CreateRouteOutput)
+		return o, err
+	}
+
+	for _, rt := range f.routeTables {
+		if aws.StringValue(rt.RouteTableId) == aws.StringValue(in.RouteTableId) {
+			rt.Routes = append(rt.Routes, &ec2.Route{
+				DestinationCidrBlock: in.DestinationCidrBlock,
+				GatewayId:            in.GatewayId,
+				NatGatewayId:         in.NatGatewayId,
+				NetworkInterfaceId:   in.NetworkInterfaceId,
+				InstanceId:           in.InstanceId,
+				State:                aws.String(ec2.RouteStateActive),
+			})
+		}
+	}
+
+	return &ec2.CreateRouteOutput{Return: aws.Bool(true)}, nil
+}
+
+// dryRunOperation is the error aws returns for an allowed dry run
+func dryRunOperation() error {
+	return awserr.New("DryRunOperation", "Request would have succeeded, but DryRun flag is set.", nil)
+}
+
+// matchFilters checks a resource's attributes against ec2 filters
+func matchFilters(filters []*ec2.Filter, attributes map[string][]string) bool {
+	for _, f := range filters {
+		values, ok := attributes[aws.StringValue(f.Name)]
+		if !ok && strings.HasPrefix(aws.StringValue(f.Name), "tag:") {
+			return false
+		}
+		if !ok {
+			continue
+		}
+
+		found := false
+		for _, want := range f.Values {
+			for _, v := range values {
+				if aws.StringValue(want) == v {
+					found = true
+				}
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// subnet returns a known subnet, any other subnet is available in the
+// test event's vpc
+func (f *fakeEC2) subnet(id string) *ec2.Subnet {
+	if s, ok := f.subnets[id]; ok {
+		return s
+	}
+
+	return &ec2.Subnet{
+		SubnetId:                aws.String(id),
+		VpcId:                   aws.String(testEvent.VPCID),
+		State:                   aws.String(ec2.SubnetStateAvailable),
+		AvailabilityZone:        aws.String("eu-west-1a"),
+		AvailableIpAddressCount: aws.Int64(250),
+	}
+}
+
+func (f *fakeEC2) DescribeSubnets(in *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("DescribeSubnets", in); ok {
+		o, _ := out.(*ec2.DescribeSubnetsOutput)
+		return o, err
+	}
+
+	resp := ec2.DescribeSubnetsOutput{}
+	if len(in.SubnetIds) == 0 {
+		for _, s := range f.subnets {
+			attributes := map[string][]string{
+				"vpc-id": {aws.StringValue(s.VpcId)},
+			}
+			for _, t := range s.Tags {
+				attributes["tag:"+aws.StringValue(t.Key)] = []string{aws.StringValue(t.Value)}
+			}
+			if matchFilters(in.Filters, attributes) {
+				resp.Subnets = append(resp.Subnets, s)
+			}
+		}
+	}
+
+	for _, id := range in.SubnetIds {
+		if _, ok := f.subnets[aws.StringValue(id)]; f.strictSubnets && !ok {
+			return nil, awserr.New("InvalidSubnetID.NotFound", "The subnet ID '"+aws.StringValue(id)+"' does not exist", nil)
+		}
+		resp.Subnets = append(resp.Subnets, f.subnet(aws.StringValue(id)))
+	}
+
+	return &resp, nil
+}
+
+func (f *fakeEC2) ReplaceRoute(in *ec2.ReplaceRouteInput) (*ec2.ReplaceRouteOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("ReplaceRoute", in); ok {
+		o, _ := out.(*ec2.ReplaceRouteOutput)
+		return o, err
+	}
+
+	for _, rt := range f.routeTables {
+		if aws.StringValue(rt.RouteTableId) != aws.StringValue(in.RouteTableId) {
+			continue
+		}
+		for i, r := range rt.Routes {
+			if aws.StringValue(r.DestinationCidrBlock) == aws.StringValue(in.DestinationCidrBlock) {
+				rt.Routes[i] = &ec2.Route{
+					DestinationCidrBlock: in.DestinationCidrBlock,
+					GatewayId:            in.GatewayId,
+					NatGatewayId:         in.NatGatewayId,
+					NetworkInterfaceId:   in.NetworkInterfaceId,
+					InstanceId:           in.InstanceId,
+					State:                aws.String(ec2.RouteStateActive),
+				}
+			}
+		}
+	}
+
+	return &ec2.ReplaceRouteOutput{}, nil
+}
+
+func (f *fakeEC2) DeleteRoute(in *ec2.DeleteRouteInput) (*ec2.DeleteRouteOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("DeleteRoute", in); ok {
+		o, _ := out.(*ec2.DeleteRouteOutput)
+		return o, err
+	}
+
+	for _, rt := range f.routeTables {
+		if aws.StringValue(rt.RouteTableId) != aws.StringValue(in.RouteTableId) {
+			continue
+		}
+		var routes []*ec2.Route
+		for _, r := range rt.Routes {
+			if aws.StringValue(r.DestinationCidrBlock) != aws.StringValue(in.DestinationCidrBlock) {
+				routes = append(routes, r)
+			}
+		}
+		rt.Routes = routes
+	}
+
+	return &ec2.DeleteRouteOutput{}, nil
+}
+
+func (f *fakeEC2) ReleaseAddress(in *ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("ReleaseAddress", in); ok {
+		o, _ := out.(*ec2.ReleaseAddressOutput)
+		return o, err
+	}
+
+	if in != nil && aws.BoolValue(in.DryRun) {
+		return nil, dryRunOperation()
+	}
+
+	return &ec2.ReleaseAddressOutput{}, nil
+}
+
+func (f *fakeEC2) CreateFlowLogs(in *ec2.CreateFlowLogsInput) (*ec2.CreateFlowLogsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("CreateFlowLogs", in); ok {
+		o, _ := out.(*ec2.CreateFlowLogsOutput)
+		return o, err
+	}
+
+	resp := ec2.CreateFlowLogsOutput{}
+	for _, id := range in.ResourceIds {
+		fl := &ec2.FlowLog{
+			FlowLogId:      aws.String(f.id("fl")),
+			ResourceId:     id,
+			LogDestination: in.LogDestination,
+		}
+		f.flowLogs[aws.StringValue(fl.FlowLogId)] = fl
+		resp.FlowLogIds = append(resp.FlowLogIds, fl.FlowLogId)
+	}
+
+	return &resp, nil
+}
+
+func (f *fakeEC2) DeleteFlowLogs(in *ec2.DeleteFlowLogsInput) (*ec2.DeleteFlowLogsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("DeleteFlowLogs", in); ok {
+		o, _ := out.(*ec2.DeleteFlowLogsOutput)
+		return o, err
+	}
+
+	for _, id := range in.FlowLogIds {
+		delete(f.flowLogs, aws.StringValue(id))
+	}
+
+	return &ec2.DeleteFlowLogsOutput{}, nil
+}
+
+func (f *fakeEC2) CreateTags(in *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("CreateTags", in); ok {
+		o, _ := out.(*ec2.CreateTagsOutput)
+		return o, err
+	}
+
+	for _, id := range in.Resources {
+		for _, rt := range f.routeTables {
+			if aws.StringValue(rt.RouteTableId) == aws.StringValue(id) {
+				rt.Tags = append(rt.Tags, in.Tags...)
+			}
+		}
+		for _, ig := range f.internetGateways {
+			if aws.StringValue(ig.InternetGatewayId) == aws.StringValue(id) {
+				ig.Tags = append(ig.Tags, in.Tags...)
+			}
+		}
+		for _, gw := range f.natGateways {
+			if aws.StringValue(gw.NatGatewayId) == aws.StringValue(id) {
+				gw.Tags = append(gw.Tags, in.Tags...)
+			}
+		}
+		if _, ok := f.addresses[aws.StringValue(id)]; ok {
+			f.addressTags[aws.StringValue(id)] = append(f.addressTags[aws.StringValue(id)], in.Tags...)
+		}
+	}
+
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (f *fakeEC2) DisassociateRouteTable(in *ec2.DisassociateRouteTableInput) (*ec2.DisassociateRouteTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("DisassociateRouteTable", in); ok {
+		o, _ := out.(*ec2.DisassociateRouteTableOutput)
+		return o, err
+	}
+
+	for _, rt := range f.routeTables {
+		for i, a := range rt.Associations {
+			if aws.StringValue(a.RouteTableAssociationId) == aws.StringValue(in.AssociationId) {
+				rt.Associations = append(rt.Associations[:i], rt.Associations[i+1:]...)
+				return &ec2.DisassociateRouteTableOutput{}, nil
+			}
+		}
+	}
+
+	return nil, awserr.New("InvalidAssociationID.NotFound", "association not found", nil)
+}
+
+func (f *fakeEC2) DeleteRouteTable(in *ec2.DeleteRouteTableInput) (*ec2.DeleteRouteTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("DeleteRouteTable", in); ok {
+		o, _ := out.(*ec2.DeleteRouteTableOutput)
+		return o, err
+	}
+
+	for i, rt := range f.routeTables {
+		if aws.StringValue(rt.RouteTableId) == aws.StringValue(in.RouteTableId) {
+			if len(rt.Associations) > 0 {
+				return nil, awserr.New("DependencyViolation", "The routeTable has dependencies and cannot be deleted.", nil)
+			}
+			f.routeTables = append(f.routeTables[:i], f.routeTables[i+1:]...)
+			return &ec2.DeleteRouteTableOutput{}, nil
+		}
+	}
+
+	return nil, awserr.New("InvalidRouteTableID.NotFound", "route table not found", nil)
+}
+
+func (f *fakeEC2) DescribeVpcs(in *ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("DescribeVpcs", in); ok {
+		o, _ := out.(*ec2.DescribeVpcsOutput)
+		return o, err
+	}
+
+	resp := ec2.DescribeVpcsOutput{}
+	for _, id := range in.VpcIds {
+		resp.Vpcs = append(resp.Vpcs, &ec2.Vpc{VpcId: id, State: aws.String(ec2.VpcStateAvailable)})
+	}
+
+	return &resp, nil
+}
+
+func (f *fakeEC2) DescribeVpcAttribute(in *ec2.DescribeVpcAttributeInput) (*ec2.DescribeVpcAttributeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if out, ok, err := f.call("DescribeVpcAttribute", in); ok {
+		o, _ := out.(*ec2.DescribeVpcAttributeOutput)
+		return o, err
+	}
+
+	resp := ec2.DescribeVpcAttributeOutput{VpcId: in.VpcId}
+	v := &ec2.AttributeBooleanValue{Value: aws.Bool(f.vpcAttributes[aws.StringValue(in.Attribute)])}
+	switch aws.StringValue(in.Attribute) {
+	case ec2.VpcAttributeNameEnableDnsSupport:
+		resp.EnableDnsSupport = v
+	case ec2.VpcAttributeNameEnableDnsHostnames:
+		resp.EnableDnsHostnames = v
+	}
+
+	return &resp, nil
+}
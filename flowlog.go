@@ -0,0 +1,142 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// Resources a flow log can be created on
+const (
+	// flowLogRoutedNetworks logs the traffic of every routed network
+	flowLogRoutedNetworks = "routed_networks"
+	// flowLogNatGateway logs the traffic of the nat gateway interface
+	flowLogNatGateway = "nat_gateway"
+)
+
+var (
+	// ErrFlowLogDestinationInvalid ...
+	ErrFlowLogDestinationInvalid = errors.New("Flow log destination must be a cloudwatch logs log group or s3 bucket arn")
+	// ErrFlowLogRoleMissing ...
+	ErrFlowLogRoleMissing = errors.New("Flow logs to cloudwatch logs need a flow log role arn")
+	// ErrFlowLogResourceInvalid ...
+	ErrFlowLogResourceInvalid = errors.New("Flow log resource must be routed_networks or nat_gateway")
+)
+
+// flowLogDestinationType returns the type of a flow log destination arn
+func flowLogDestinationType(destination string) string {
+	switch {
+	case strings.HasPrefix(destination, "arn:aws:s3:::"):
+		return ec2.LogDestinationTypeS3
+	case strings.HasPrefix(destination, "arn:aws:logs:"):
+		return ec2.LogDestinationTypeCloudWatchLogs
+	}
+
+	return ""
+}
+
+// validateFlowLog checks the flow log requested, if any
+func (ev *Event) validateFlowLog() error {
+	if ev.FlowLogDestination == "" {
+		return nil
+	}
+
+	switch flowLogDestinationType(ev.FlowLogDestination) {
+	case ec2.LogDestinationTypeCloudWatchLogs:
+		if ev.FlowLogRoleARN == "" {
+			return ErrFlowLogRoleMissing
+		}
+	case "":
+		return ErrFlowLogDestinationInvalid
+	}
+
+	switch ev.FlowLogResource {
+	case "", flowLogRoutedNetworks, flowLogNatGateway:
+		return nil
+	}
+
+	return ErrFlowLogResourceInvalid
+}
+
+// createFlowLogs creates the flow logs requested for debugging, on the
+// routed networks or on the nat gateway network interface. A retry keeps
+// the flow logs a previous attempt created.
+func (ev *Event) createFlowLogs(svc ec2iface.EC2API, gw *ec2.NatGateway) error {
+	if ev.FlowLogDestination == "" || len(ev.FlowLogIDs) > 0 {
+		return nil
+	}
+
+	req := ec2.CreateFlowLogsInput{
+		LogDestination:     aws.String(ev.FlowLogDestination),
+		LogDestinationType: aws.String(flowLogDestinationType(ev.FlowLogDestination)),
+		TrafficType:        aws.String(ec2.TrafficTypeAll),
+		ResourceType:       aws.String(ec2.FlowLogsResourceTypeSubnet),
+		ResourceIds:        aws.StringSlice(ev.RoutedNetworkAWSIDs),
+	}
+
+	if ev.FlowLogRoleARN != "" {
+		req.DeliverLogsPermissionArn = aws.String(ev.FlowLogRoleARN)
+	}
+
+	if ev.FlowLogResource == flowLogNatGateway {
+		req.ResourceType = aws.String(ec2.FlowLogsResourceTypeNetworkInterface)
+		req.ResourceIds = nil
+		for _, addr := range gw.NatGatewayAddresses {
+			if addr.NetworkInterfaceId != nil {
+				req.ResourceIds = append(req.ResourceIds, addr.NetworkInterfaceId)
+				break
+			}
+		}
+
+		if len(req.ResourceIds) == 0 {
+			return missingField("nat gateway network interface id")
+		}
+	}
+
+	resp, err := svc.CreateFlowLogs(&req)
+	if err != nil {
+		return err
+	}
+
+	ev.FlowLogIDs = aws.StringValueSlice(resp.FlowLogIds)
+
+	for _, u := range resp.Unsuccessful {
+		if u.Error != nil {
+			return fmt.Errorf("Could not create the flow log of %s: %s", aws.StringValue(u.ResourceId), aws.StringValue(u.Error.Message))
+		}
+	}
+
+	return nil
+}
+
+// deleteFlowLogs deletes the flow logs the connector created
+func (ev *Event) deleteFlowLogs(svc ec2iface.EC2API) error {
+	if len(ev.FlowLogIDs) == 0 {
+		return nil
+	}
+
+	resp, err := svc.DeleteFlowLogs(&ec2.DeleteFlowLogsInput{
+		FlowLogIds: aws.StringSlice(ev.FlowLogIDs),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, u := range resp.Unsuccessful {
+		if u.Error != nil && aws.StringValue(u.Error.Code) != "InvalidFlowLogId.NotFound" {
+			return fmt.Errorf("Could not delete flow log %s: %s", aws.StringValue(u.ResourceId), aws.StringValue(u.Error.Message))
+		}
+	}
+
+	ev.FlowLogIDs = nil
+
+	return nil
+}
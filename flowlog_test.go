@@ -0,0 +1,110 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFlowLogs(t *testing.T) {
+	deletePollInterval = time.Millisecond
+	deleteGracePeriod = 0
+
+	Convey("Given a create event with a flow log", t, func() {
+		ev := testEvent
+		ev.NatGatewayAWSID = ""
+		ev.FlowLogDestination = "arn:aws:s3:::nat-flow-logs"
+		f := newFakeEC2()
+		useFake(f)
+
+		create := func(ev Event) (Event, error) {
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+			if err := e.Validate(); err != nil {
+				return e, err
+			}
+			return e, e.Create()
+		}
+
+		Convey("When creating it on the routed networks", func() {
+			e, err := create(ev)
+
+			Convey("It should log the routed networks traffic to the destination", func() {
+				So(err, ShouldBeNil)
+				So(e.FlowLogIDs, ShouldHaveLength, 1)
+				fl := f.flowLogs[e.FlowLogIDs[0]]
+				So(aws.StringValue(fl.ResourceId), ShouldEqual, "subnet-00000001")
+				So(aws.StringValue(fl.LogDestination), ShouldEqual, "arn:aws:s3:::nat-flow-logs")
+			})
+
+			Convey("When deleting it with teardown", func() {
+				f.hooks["DescribeNatGateways"] = func(input interface{}) (interface{}, error) {
+					return &ec2.DescribeNatGatewaysOutput{NatGateways: []*ec2.NatGateway{
+						&ec2.NatGateway{NatGatewayId: aws.String(e.NatGatewayAWSID), State: aws.String(ec2.NatGatewayStateDeleted)},
+					}}, nil
+				}
+				e.Teardown = true
+				data, _ := json.Marshal(e)
+				d := New("nat.delete.aws", data)
+				d.Process()
+				err := d.Delete()
+
+				Convey("It should delete the flow logs", func() {
+					So(err, ShouldBeNil)
+					So(f.Count("DeleteFlowLogs"), ShouldEqual, 1)
+					So(f.flowLogs, ShouldBeEmpty)
+					So(d.FlowLogIDs, ShouldBeEmpty)
+				})
+			})
+		})
+
+		Convey("When creating it on the nat gateway", func() {
+			ev.FlowLogResource = "nat_gateway"
+			e, err := create(ev)
+
+			Convey("It should log the nat gateway interface traffic", func() {
+				So(err, ShouldBeNil)
+				So(e.FlowLogIDs, ShouldHaveLength, 1)
+				So(aws.StringValue(f.flowLogs[e.FlowLogIDs[0]].ResourceId), ShouldStartWith, "eni-")
+			})
+		})
+
+		Convey("When a previous attempt already created it", func() {
+			ev.FlowLogIDs = []string{"fl-existing"}
+			_, err := create(ev)
+
+			Convey("It should not create another", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateFlowLogs"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When it logs to cloudwatch logs without a role", func() {
+			ev.FlowLogDestination = "arn:aws:logs:eu-west-1:123456789012:log-group:nat"
+			_, err := create(ev)
+
+			Convey("It should not validate", func() {
+				So(err, ShouldEqual, ErrFlowLogRoleMissing)
+			})
+		})
+
+		Convey("When the destination is not an arn", func() {
+			ev.FlowLogDestination = "nat-flow-logs"
+			_, err := create(ev)
+
+			Convey("It should not validate", func() {
+				So(err, ShouldEqual, ErrFlowLogDestinationInvalid)
+			})
+		})
+	})
+}
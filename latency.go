@@ -0,0 +1,81 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// debugLatency makes every operation report how long each of its aws api
+// calls took, for performance investigations
+var debugLatency bool
+
+// APICallLatency : how long an attempt of an aws api call took, from
+// sending the request until its response was read
+type APICallLatency struct {
+	Operation string `json:"operation"`
+	Attempt   int    `json:"attempt"`
+	LatencyMS int64  `json:"latency_ms"`
+	Failed    bool   `json:"failed,omitempty"`
+}
+
+// parseDebugLatency parses the configured latency debug mode, off unless
+// set
+func parseDebugLatency(s string) (bool, error) {
+	if s == "" {
+		return false, nil
+	}
+
+	return strconv.ParseBool(s)
+}
+
+// timed returns a copy of an ec2 client recording the latency of every
+// request it sends. The client may be shared with other events, so the
+// handler is only added to the copy. Clients other than the sdk one, as
+// in tests, are returned as they are.
+func (b *countingEC2) timed(svc ec2iface.EC2API) ec2iface.EC2API {
+	c, ok := svc.(*ec2.EC2)
+	if !ok {
+		return svc
+	}
+
+	cl := *c.Client
+	cl.Handlers = cl.Handlers.Copy()
+	cl.Handlers.Send.PushBack(b.recordLatency)
+
+	return &ec2.EC2{Client: &cl}
+}
+
+// recordLatency records the latency of a request attempt once it is
+// sent, failed attempts included
+func (b *countingEC2) recordLatency(r *request.Request) {
+	l := APICallLatency{
+		Operation: r.Operation.Name,
+		Attempt:   r.RetryCount + 1,
+		LatencyMS: int64(time.Since(r.AttemptTime) / time.Millisecond),
+		Failed:    r.Error != nil || r.HTTPResponse == nil || r.HTTPResponse.StatusCode >= 300,
+	}
+
+	log.Printf("Debug: %s attempt %d took %dms", l.Operation, l.Attempt, l.LatencyMS)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.latencies = append(b.latencies, l)
+}
+
+// recordedLatencies returns the latencies recorded so far
+func (b *countingEC2) recordedLatencies() []APICallLatency {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]APICallLatency(nil), b.latencies...)
+}
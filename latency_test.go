@@ -0,0 +1,121 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const describeNatGatewaysResponse = `<DescribeNatGatewaysResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>00000000-0000-0000-0000-000000000000</requestId>
+  <natGatewaySet/>
+</DescribeNatGatewaysResponse>`
+
+func TestAPICallLatencies(t *testing.T) {
+	Convey("Given latency debug mode and an ec2 client reaching aws", t, func() {
+		log.SetOutput(ioutil.Discard)
+		debugLatency = true
+
+		requests := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte(describeNatGatewaysResponse))
+		}))
+
+		sess, _ := session.NewSession(&aws.Config{
+			Endpoint:    aws.String(srv.URL),
+			Region:      aws.String("eu-west-1"),
+			Credentials: credentials.NewStaticCredentials("key", "secret", ""),
+			MaxRetries:  aws.Int(1),
+		})
+		shared := ec2.New(sess)
+		sends := shared.Handlers.Send.Len()
+		ec2Client = func(ev *Event) (ec2iface.EC2API, error) {
+			return shared, nil
+		}
+
+		data, _ := json.Marshal(testEvent)
+		e := New("nat.get.aws", data)
+		e.Process()
+
+		Convey("When the event makes a call that is retried", func() {
+			svc, err := e.client()
+			So(err, ShouldBeNil)
+			_, err = svc.DescribeNatGateways(&ec2.DescribeNatGatewaysInput{})
+
+			Convey("It should record the latency of every attempt", func() {
+				So(err, ShouldBeNil)
+				latencies := e.calls.recordedLatencies()
+				So(len(latencies), ShouldEqual, 2)
+				So(latencies[0].Operation, ShouldEqual, "DescribeNatGateways")
+				So(latencies[0].Attempt, ShouldEqual, 1)
+				So(latencies[0].Failed, ShouldBeTrue)
+				So(latencies[1].Attempt, ShouldEqual, 2)
+				So(latencies[1].Failed, ShouldBeFalse)
+				So(latencies[1].LatencyMS, ShouldBeGreaterThanOrEqualTo, 0)
+			})
+
+			Convey("It should not time the calls of the shared client", func() {
+				So(shared.Handlers.Send.Len(), ShouldEqual, sends)
+			})
+		})
+
+		Convey("When the mode is off", func() {
+			debugLatency = false
+			svc, _ := e.client()
+			svc.DescribeNatGateways(&ec2.DescribeNatGatewaysInput{})
+
+			Convey("It should not record anything", func() {
+				So(e.calls.recordedLatencies(), ShouldBeEmpty)
+			})
+		})
+
+		Reset(func() {
+			srv.Close()
+			debugLatency = false
+			ec2Client = cachedEC2Client
+			log.SetOutput(os.Stdout)
+		})
+	})
+}
+
+func TestParseDebugLatency(t *testing.T) {
+	Convey("Given a configured latency debug mode", t, func() {
+		Convey("When it isn't set", func() {
+			on, err := parseDebugLatency("")
+
+			Convey("It should be off", func() {
+				So(err, ShouldBeNil)
+				So(on, ShouldBeFalse)
+			})
+		})
+
+		Convey("When it is not a boolean", func() {
+			_, err := parseDebugLatency("verbose")
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
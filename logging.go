@@ -0,0 +1,56 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Levels of the progress logs, from the most to the least verbose
+const (
+	logLevelDebug = iota
+	logLevelInfo
+	logLevelWarning
+)
+
+// logLevels are the names of the log levels
+var logLevels = map[string]int{
+	"debug":   logLevelDebug,
+	"info":    logLevelInfo,
+	"warning": logLevelWarning,
+}
+
+// logLevel is the minimum level of the progress logs written. Errors and
+// warnings are always written.
+var logLevel = logLevelInfo
+
+// parseLogLevel parses the configured minimum log level, info unless set
+func parseLogLevel(s string) (int, error) {
+	if s == "" {
+		return logLevelInfo, nil
+	}
+
+	level, ok := logLevels[s]
+	if !ok {
+		return 0, fmt.Errorf("Log level %s must be debug, info or warning", s)
+	}
+
+	return level, nil
+}
+
+// debugf logs the detail of each step, as every poll of a wait
+func debugf(format string, v ...interface{}) {
+	if logLevel <= logLevelDebug {
+		log.Printf("Debug: "+format, v...)
+	}
+}
+
+// infof logs the milestones of an operation
+func infof(format string, v ...interface{}) {
+	if logLevel <= logLevelInfo {
+		log.Printf(format, v...)
+	}
+}
@@ -0,0 +1,118 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeleteLogLevel(t *testing.T) {
+	Convey("Given a nat gateway taking a few polls to be deleted", t, func() {
+		var out bytes.Buffer
+		log.SetOutput(&out)
+		deletePollInterval = time.Millisecond
+		deleteGracePeriod = 0
+
+		f := newFakeEC2()
+		useFake(f)
+		polls := 0
+		f.hooks["DescribeNatGateways"] = func(input interface{}) (interface{}, error) {
+			polls++
+			state := ec2.NatGatewayStateDeleting
+			if polls > 2 {
+				state = ec2.NatGatewayStateDeleted
+			}
+			return &ec2.DescribeNatGatewaysOutput{NatGateways: []*ec2.NatGateway{
+				&ec2.NatGateway{NatGatewayId: aws.String(testEvent.NatGatewayAWSID), State: aws.String(state)},
+			}}, nil
+		}
+
+		del := func() error {
+			data, _ := json.Marshal(testEvent)
+			e := New("nat.delete.aws", data)
+			e.Process()
+			return e.Delete()
+		}
+
+		Convey("When logging at info", func() {
+			logLevel = logLevelInfo
+			err := del()
+
+			Convey("It should only log the milestones", func() {
+				So(err, ShouldBeNil)
+				So(out.String(), ShouldContainSubstring, "Nat gateway nat-00000000 deletion issued")
+				So(out.String(), ShouldContainSubstring, "Nat gateway nat-00000000 deleted")
+				So(out.String(), ShouldNotContainSubstring, "not deleted yet")
+			})
+		})
+
+		Convey("When logging at debug", func() {
+			logLevel = logLevelDebug
+			err := del()
+
+			Convey("It should log every poll as well", func() {
+				So(err, ShouldBeNil)
+				So(out.String(), ShouldContainSubstring, "Debug: Nat gateway nat-00000000 is not deleted yet, checking again in 1ms")
+				So(out.String(), ShouldContainSubstring, "Nat gateway nat-00000000 deleted")
+			})
+		})
+
+		Convey("When logging at warning", func() {
+			logLevel = logLevelWarning
+			err := del()
+
+			Convey("It should log none of them", func() {
+				So(err, ShouldBeNil)
+				So(out.String(), ShouldBeEmpty)
+			})
+		})
+
+		Reset(func() {
+			logLevel = logLevelInfo
+			log.SetOutput(os.Stdout)
+		})
+	})
+}
+
+func TestParseLogLevel(t *testing.T) {
+	Convey("Given a configured log level", t, func() {
+		Convey("When it isn't set", func() {
+			level, err := parseLogLevel("")
+
+			Convey("It should be info", func() {
+				So(err, ShouldBeNil)
+				So(level, ShouldEqual, logLevelInfo)
+			})
+		})
+
+		Convey("When it is debug", func() {
+			level, err := parseLogLevel("debug")
+
+			Convey("It should use it", func() {
+				So(err, ShouldBeNil)
+				So(level, ShouldEqual, logLevelDebug)
+			})
+		})
+
+		Convey("When it is unknown", func() {
+			_, err := parseLogLevel("verbose")
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Log level verbose must be debug, info or warning")
+			})
+		})
+	})
+}
@@ -6,9 +6,10 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"runtime"
-	"strings"
+	"runtime/debug"
 
 	ecc "github.com/ernestio/ernest-config-client"
 	"github.com/nats-io/nats"
@@ -20,27 +21,27 @@ var natsErr error
 func eventHandler(m *nats.Msg) {
 	n := New(m.Subject, m.Data)
 
+	// A failing operation must not take down the subscriber, so any panic
+	// is answered as an error
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Error: panic processing %s: %v\n%s", m.Subject, r, debug.Stack())
+			n.Error(fmt.Errorf("Internal error: %v", r))
+		}
+	}()
+
 	err := n.Process()
 	if err != nil {
 		return
 	}
 
 	if err = n.Validate(); err != nil {
+		n.ErrorClass = errorClassValidation
 		n.Error(err)
 		return
 	}
 
-	parts := strings.Split(m.Subject, ".")
-	switch parts[1] {
-	case "create":
-		err = n.Create()
-	case "update":
-		err = n.Update()
-	case "delete":
-		err = n.Delete()
-	case "get":
-		err = n.Get()
-	}
+	err = n.Execute(subjectAction(m.Subject))
 	if err != nil {
 		n.Error(err)
 		return
@@ -49,13 +50,92 @@ func eventHandler(m *nats.Msg) {
 	n.Complete()
 }
 
+// recovered runs the part of an operation given its own goroutine, turning
+// a panic into its error, as the handler only recovers its own goroutine
+func recovered(run func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Error: panic: %v\n%s", r, debug.Stack())
+			err = fmt.Errorf("Internal error: %v", r)
+		}
+	}()
+
+	return run()
+}
+
 func main() {
 	nc = ecc.NewConfig(os.Getenv("NATS_URI")).Nats()
 
-	events := []string{"nat.create.aws", "nat.update.aws", "nat.delete.aws"}
+	var err error
+	logLevel, err = parseLogLevel(os.Getenv("NAT_LOG_LEVEL"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	httpClient, err = awsHTTPClient(os.Getenv("NAT_AWS_PROXY"), os.Getenv("NAT_AWS_CA_BUNDLE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	scopeTag, err = parseScopeTag(os.Getenv("NAT_AWS_SCOPE_TAG"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	nameTemplate, err = parseNameTemplate(os.Getenv("NAT_AWS_NAME_TEMPLATE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	allowedSubnets, err = parseSubnetAllowlist(os.Getenv("NAT_AWS_ALLOWED_SUBNETS"), os.Getenv("NAT_AWS_ALLOWED_SUBNET_TAG"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	describeCalls, err := maxDescribeCalls(os.Getenv("NAT_AWS_MAX_DESCRIBE_CALLS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	describeSlots = newSlots(describeCalls)
+
+	deleteGracePeriod, err = deleteGrace(os.Getenv("NAT_AWS_DELETE_GRACE_PERIOD"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	deleteWaitRetries, err = deleteWaitRetryCount(os.Getenv("NAT_AWS_DELETE_WAIT_RETRIES"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	debugLatency, err = parseDebugLatency(os.Getenv("NAT_AWS_DEBUG_LATENCY"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	serializeVPCs, err = parseSerializeVPCs(os.Getenv("NAT_AWS_SERIALIZE_VPCS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	subjectActions, err = parseSubjectActions(os.Getenv("NAT_NATS_SUBJECT_ACTIONS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	maxMsgs, maxBytes, err := pendingLimits(os.Getenv("NAT_NATS_MAX_PENDING_MSGS"), os.Getenv("NAT_NATS_MAX_PENDING_BYTES"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	nc.SetErrorHandler(asyncErrorHandler)
+
+	events := []string{"nat.create.aws", "nat.update.aws", "nat.delete.aws", "nat.get.aws", "nat.igw.aws", "nat.validate.aws"}
+	events = append(events, mappedSubjects()...)
 	for _, subject := range events {
 		fmt.Println("listening for " + subject)
-		nc.Subscribe(subject, eventHandler)
+		if _, err := subscribe(subject, maxMsgs, maxBytes); err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	runtime.Goexit()
@@ -0,0 +1,172 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/nats-io/nats"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEventHandlerPanic(t *testing.T) {
+	Convey("Given an event whose operation panics", t, func() {
+		log.SetOutput(ioutil.Discard)
+		ec2Client = func(ev *Event) (ec2iface.EC2API, error) {
+			return nil, nil
+		}
+
+		published := make(map[string][]byte)
+		publish = func(subject string, data []byte) error {
+			published[subject] = data
+			return nil
+		}
+
+		data, _ := json.Marshal(testEvent)
+
+		Convey("When handling it", func() {
+			So(func() {
+				eventHandler(&nats.Msg{Subject: "nat.update.aws", Data: data})
+			}, ShouldNotPanic)
+
+			Convey("It should answer with an error", func() {
+				So(published, ShouldContainKey, "nat.update.aws.error")
+				So(published, ShouldNotContainKey, "nat.update.aws.done")
+				So(string(published["nat.update.aws.error"]), ShouldContainSubstring, `"error_message":"Internal error: runtime error: invalid memory address or nil pointer dereference"`)
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stdout)
+			publish = func(subject string, data []byte) error {
+				return nc.Publish(subject, data)
+			}
+		})
+	})
+}
+
+func TestEventHandlerErrorClass(t *testing.T) {
+	Convey("Given an invalid event", t, func() {
+		log.SetOutput(ioutil.Discard)
+		published := make(map[string][]byte)
+		publish = func(subject string, data []byte) error {
+			published[subject] = data
+			return nil
+		}
+
+		ev := testEvent
+		ev.VPCID = ""
+		data, _ := json.Marshal(ev)
+
+		Convey("When handling it", func() {
+			eventHandler(&nats.Msg{Subject: "nat.create.aws", Data: data})
+
+			Convey("It should answer with a validation error", func() {
+				So(string(published["nat.create.aws.error"]), ShouldContainSubstring, `"error_class":"validation"`)
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stdout)
+			publish = func(subject string, data []byte) error {
+				return nc.Publish(subject, data)
+			}
+		})
+	})
+}
+
+func TestGoroutinePanic(t *testing.T) {
+	deletePollInterval = time.Millisecond
+	deleteGracePeriod = 0
+
+	Convey("Given an operation whose goroutines panic", t, func() {
+		log.SetOutput(ioutil.Discard)
+
+		Convey("When an operation panics on one of its regions", func() {
+			ev := testEvent
+			ev.DatacenterRegion = ""
+			ev.DatacenterRegions = []string{"eu-west-1", "us-east-1"}
+			ev.Regions = map[string]*Region{
+				"eu-west-1": &Region{VPCID: "vpc-eu", NatGatewayAWSID: "nat-eu"},
+				"us-east-1": &Region{VPCID: "vpc-us", NatGatewayAWSID: "nat-us"},
+			}
+			eu := newFakeEC2()
+			eu.natGateways = append(eu.natGateways, &ec2.NatGateway{
+				NatGatewayId: aws.String("nat-eu"),
+				State:        aws.String(ec2.NatGatewayStateAvailable),
+			})
+			// us-east-1 has no client, so calling aws panics
+			useRegionFakes(map[string]*fakeEC2{"eu-west-1": eu})
+
+			data, _ := json.Marshal(ev)
+			e := New("nat.delete.aws", data)
+			e.Process()
+
+			var err error
+			So(func() { err = e.Execute("delete") }, ShouldNotPanic)
+
+			Convey("It should report it as the error of that region", func() {
+				So(err, ShouldNotBeNil)
+				So(e.Regions["eu-west-1"].ErrorMessage, ShouldBeEmpty)
+				So(e.Regions["us-east-1"].ErrorMessage, ShouldStartWith, "Internal error: ")
+			})
+		})
+
+		Convey("When a batch delete panics on one of its nat gateways", func() {
+			f := newFakeEC2()
+			useFake(f)
+			for _, id := range []string{"nat-a", "nat-b"} {
+				f.natGateways = append(f.natGateways, &ec2.NatGateway{
+					NatGatewayId: aws.String(id),
+					State:        aws.String(ec2.NatGatewayStateAvailable),
+				})
+			}
+			f.hooks["DeleteNatGateway"] = func(input interface{}) (interface{}, error) {
+				id := aws.StringValue(input.(*ec2.DeleteNatGatewayInput).NatGatewayId)
+				if id == "nat-b" {
+					panic("unexpected response")
+				}
+
+				f.mu.Lock()
+				defer f.mu.Unlock()
+				for _, gw := range f.natGateways {
+					if aws.StringValue(gw.NatGatewayId) == id {
+						gw.State = aws.String(ec2.NatGatewayStateDeleted)
+					}
+				}
+				return &ec2.DeleteNatGatewayOutput{NatGatewayId: aws.String(id)}, nil
+			}
+
+			ev := testEvent
+			ev.NatGatewayAWSID = ""
+			ev.NatGatewayAWSIDs = []string{"nat-a", "nat-b"}
+			data, _ := json.Marshal(ev)
+			e := New("nat.delete.aws", data)
+			e.Process()
+
+			var err error
+			So(func() { err = e.Execute("delete") }, ShouldNotPanic)
+
+			Convey("It should report it as the error of that nat gateway", func() {
+				So(err, ShouldNotBeNil)
+				So(e.NatGatewayDeletions[0].ErrorMessage, ShouldBeEmpty)
+				So(e.NatGatewayDeletions[1].ErrorMessage, ShouldEqual, "Internal error: unexpected response")
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stdout)
+		})
+	})
+}
@@ -0,0 +1,106 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// nameTagKey is the tag aws shows as the name of a resource
+const nameTagKey = "Name"
+
+// ErrNameTemplateInvalid ...
+var ErrNameTemplateInvalid = errors.New("Name template has an unclosed placeholder")
+
+// nameTemplate is the template the Name tag of the resources the
+// connector creates is rendered from, none being named when it is empty
+var nameTemplate string
+
+// namePlaceholders are the placeholders a name template can use
+var namePlaceholders = map[string]bool{
+	"service":  true,
+	"region":   true,
+	"vpc":      true,
+	"az":       true,
+	"resource": true,
+}
+
+var placeholderPattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+// parseNameTemplate checks a name template only uses known placeholders,
+// like {service}-nat-{az}
+func parseNameTemplate(s string) (string, error) {
+	for _, p := range placeholderPattern.FindAllString(s, -1) {
+		if !namePlaceholders[strings.Trim(p, "{}")] {
+			return "", fmt.Errorf("Name template placeholder %s is unknown", p)
+		}
+	}
+
+	if strings.ContainsAny(placeholderPattern.ReplaceAllString(s, ""), "{}") {
+		return "", ErrNameTemplateInvalid
+	}
+
+	return s, nil
+}
+
+// resourceName renders the name template for a resource the event creates
+// on a subnet. The resource is its kind, as nat or rtb, and the subnet is
+// only described when the template uses its availability zone.
+func (ev *Event) resourceName(svc ec2iface.EC2API, resource, subnet string) (string, error) {
+	var az string
+
+	if strings.Contains(nameTemplate, "{az}") && subnet != "" {
+		req := ec2.DescribeSubnetsInput{
+			SubnetIds: []*string{aws.String(subnet)},
+		}
+
+		resp, err := svc.DescribeSubnets(&req)
+		if err != nil {
+			return "", err
+		}
+
+		for _, s := range resp.Subnets {
+			az = aws.StringValue(s.AvailabilityZone)
+		}
+	}
+
+	r := strings.NewReplacer(
+		"{service}", ev.BatchID,
+		"{region}", ev.DatacenterRegion,
+		"{vpc}", ev.VPCID,
+		"{az}", az,
+		"{resource}", resource,
+	)
+
+	return r.Replace(nameTemplate), nil
+}
+
+// namedTags returns the tags of a resource the event creates with its
+// Name tag rendered from the name template, unless there is no template
+// or the tags already name it
+func (ev *Event) namedTags(svc ec2iface.EC2API, tags map[string]string, resource, subnet string) (map[string]string, error) {
+	if _, ok := tags[nameTagKey]; ok || nameTemplate == "" {
+		return tags, nil
+	}
+
+	name, err := ev.resourceName(svc, resource, subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	named := map[string]string{nameTagKey: name}
+	for k, v := range tags {
+		named[k] = v
+	}
+
+	return named, nil
+}
@@ -0,0 +1,96 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseNameTemplate(t *testing.T) {
+	Convey("Given a configured name template", t, func() {
+		Convey("When it only uses known placeholders", func() {
+			tmpl, err := parseNameTemplate("{service}-nat-{az}")
+
+			Convey("It should use it", func() {
+				So(err, ShouldBeNil)
+				So(tmpl, ShouldEqual, "{service}-nat-{az}")
+			})
+		})
+
+		Convey("When it uses an unknown placeholder", func() {
+			_, err := parseNameTemplate("{service}-{env}")
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Name template placeholder {env} is unknown")
+			})
+		})
+
+		Convey("When a placeholder is not closed", func() {
+			_, err := parseNameTemplate("{service}-nat-{az")
+
+			Convey("It should error", func() {
+				So(err, ShouldEqual, ErrNameTemplateInvalid)
+			})
+		})
+	})
+}
+
+func TestNameTemplate(t *testing.T) {
+	Convey("Given a name template", t, func() {
+		nameTemplate = "{service}-{resource}-{az}"
+
+		f := newFakeEC2()
+		useFake(f)
+
+		create := func(tags map[string]string) (Event, error) {
+			ev := testEvent
+			ev.Tags = tags
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+			return e, e.Create()
+		}
+
+		Convey("When creating a nat gateway", func() {
+			e, err := create(nil)
+
+			Convey("It should name the resources it creates", func() {
+				So(err, ShouldBeNil)
+				So(hasTag(f.natGateways[0].Tags, "Name", "test-nat-eu-west-1a"), ShouldBeTrue)
+				So(hasTag(f.addressTags[e.NatGatewayAllocationID], "Name", "test-eip-eu-west-1a"), ShouldBeTrue)
+				So(hasTag(f.internetGateways[0].Tags, "Name", "test-igw-eu-west-1a"), ShouldBeTrue)
+				So(hasTag(f.routeTables[0].Tags, "Name", "test-rtb-eu-west-1a"), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the event names the nat gateway", func() {
+			_, err := create(map[string]string{"Name": "prod-nat"})
+
+			Convey("It should keep that name", func() {
+				So(err, ShouldBeNil)
+				So(hasTag(f.natGateways[0].Tags, "Name", "prod-nat"), ShouldBeTrue)
+				So(hasTag(f.natGateways[0].Tags, "Name", "test-nat-eu-west-1a"), ShouldBeFalse)
+			})
+		})
+
+		Convey("When the template uses the region and vpc", func() {
+			nameTemplate = "{region}-{vpc}-{resource}"
+			_, err := create(nil)
+
+			Convey("It should fill them from the event", func() {
+				So(err, ShouldBeNil)
+				So(hasTag(f.natGateways[0].Tags, "Name", testEvent.DatacenterRegion+"-"+testEvent.VPCID+"-nat"), ShouldBeTrue)
+			})
+		})
+
+		Reset(func() {
+			nameTemplate = ""
+		})
+	})
+}
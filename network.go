@@ -0,0 +1,154 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// resolveRoutedNetworks derives the routed network ids from the routed
+// network names, matching the Name tag of the vpc subnets, when the event
+// only has the names
+func (ev *Event) resolveRoutedNetworks(svc ec2iface.EC2API) error {
+	if len(ev.RoutedNetworkAWSIDs) > 0 || len(ev.RoutedNetworks) == 0 {
+		return nil
+	}
+
+	req := ec2.DescribeSubnetsInput{
+		Filters: filters(map[string][]string{
+			"vpc-id":   {ev.VPCID},
+			"tag:Name": ev.RoutedNetworks,
+		}),
+	}
+
+	resp, err := svc.DescribeSubnets(&req)
+	if err != nil {
+		return err
+	}
+
+	ids := make(map[string][]string)
+	for _, s := range resp.Subnets {
+		for _, t := range s.Tags {
+			if aws.StringValue(t.Key) == "Name" {
+				ids[aws.StringValue(t.Value)] = append(ids[aws.StringValue(t.Value)], aws.StringValue(s.SubnetId))
+			}
+		}
+	}
+
+	var resolved []string
+	for _, name := range ev.RoutedNetworks {
+		switch len(ids[name]) {
+		case 0:
+			return fmt.Errorf("Routed network %s not found", name)
+		case 1:
+			resolved = append(resolved, ids[name][0])
+		default:
+			return fmt.Errorf("Routed network name %s matches more than one network", name)
+		}
+	}
+
+	ev.RoutedNetworkAWSIDs = resolved
+
+	return nil
+}
+
+// checkRoutedNetworkVPCs checks every routed network is on the vpc of the
+// event, as the nat gateway can only route the networks of its own vpc
+func (ev *Event) checkRoutedNetworkVPCs(svc ec2iface.EC2API) error {
+	if len(ev.RoutedNetworkAWSIDs) == 0 {
+		return nil
+	}
+
+	resp, err := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{
+		SubnetIds: aws.StringSlice(ev.RoutedNetworkAWSIDs),
+	})
+	if err != nil {
+		return err
+	}
+
+	var others []string
+	for _, s := range resp.Subnets {
+		if vpc := aws.StringValue(s.VpcId); vpc != ev.VPCID {
+			others = append(others, aws.StringValue(s.SubnetId)+" on "+vpc)
+		}
+	}
+
+	if len(others) > 0 {
+		sort.Strings(others)
+		return fmt.Errorf("Routed networks are not on vpc %s: %s", ev.VPCID, strings.Join(others, ", "))
+	}
+
+	return nil
+}
+
+// resolvePublicNetwork derives the public network id from the public
+// network name, matching the Name tag of the vpc subnets, when the event
+// only has the name
+func (ev *Event) resolvePublicNetwork(svc ec2iface.EC2API) error {
+	if ev.PublicNetworkAWSID != "" || ev.PublicNetwork == "" {
+		return nil
+	}
+
+	req := ec2.DescribeSubnetsInput{
+		Filters: filters(map[string][]string{
+			"vpc-id":   {ev.VPCID},
+			"tag:Name": {ev.PublicNetwork},
+		}),
+	}
+
+	resp, err := svc.DescribeSubnets(&req)
+	if err != nil {
+		return err
+	}
+
+	switch len(resp.Subnets) {
+	case 0:
+		return fmt.Errorf("Public network %s not found", ev.PublicNetwork)
+	case 1:
+		ev.PublicNetworkAWSID = aws.StringValue(resp.Subnets[0].SubnetId)
+	default:
+		return fmt.Errorf("Public network name %s matches more than one network", ev.PublicNetwork)
+	}
+
+	return nil
+}
+
+// selectPublicNetwork picks the candidate public network with the most
+// available ip addresses to place the nat gateway on, or the first one
+// when aws doesn't report them
+func (ev *Event) selectPublicNetwork(svc ec2iface.EC2API) string {
+	selected := ev.PublicNetworkAWSIDs[0]
+
+	req := ec2.DescribeSubnetsInput{
+		SubnetIds: aws.StringSlice(ev.PublicNetworkAWSIDs),
+	}
+
+	resp, err := svc.DescribeSubnets(&req)
+	if err != nil {
+		log.Printf("Could not describe the candidate public networks, using %s: %s", selected, err.Error())
+		return selected
+	}
+
+	var most int64 = -1
+	for _, s := range resp.Subnets {
+		if s.AvailableIpAddressCount == nil {
+			continue
+		}
+
+		if *s.AvailableIpAddressCount > most {
+			most = *s.AvailableIpAddressCount
+			selected = aws.StringValue(s.SubnetId)
+		}
+	}
+
+	return selected
+}
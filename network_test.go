@@ -0,0 +1,332 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// namedSubnet adds a subnet with a Name tag to the test event's vpc
+func namedSubnet(f *fakeEC2, id, name string) {
+	f.subnets[id] = &ec2.Subnet{
+		SubnetId: aws.String(id),
+		VpcId:    aws.String(testEvent.VPCID),
+		State:    aws.String(ec2.SubnetStateAvailable),
+		Tags: []*ec2.Tag{
+			&ec2.Tag{Key: aws.String("Name"), Value: aws.String(name)},
+		},
+	}
+}
+
+func TestRoutedNetworkNames(t *testing.T) {
+	Convey("Given an update event", t, func() {
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+		namedSubnet(f, "subnet-00000001", "web")
+		namedSubnet(f, "subnet-00000002", "db")
+
+		event := func(names, ids []string) *Event {
+			ev := testEvent
+			ev.RoutedNetworks = names
+			ev.RoutedNetworkAWSIDs = ids
+			data, _ := json.Marshal(ev)
+			e := New("nat.update.aws", data)
+			e.Process()
+			return &e
+		}
+
+		Convey("When names and ids have the same length", func() {
+			e := event([]string{"web", "db"}, []string{"subnet-00000001", "subnet-00000002"})
+
+			Convey("It should be valid", func() {
+				So(e.Validate(), ShouldBeNil)
+			})
+		})
+
+		Convey("When names and ids have different lengths", func() {
+			e := event([]string{"web", "db"}, []string{"subnet-00000001"})
+
+			Convey("It should fail validation", func() {
+				So(e.Validate(), ShouldEqual, ErrRoutedNetworksMismatch)
+			})
+		})
+
+		Convey("When only the names are set", func() {
+			e := event([]string{"db", "web"}, nil)
+			So(e.Validate(), ShouldBeNil)
+			err := e.Update()
+
+			Convey("It should derive the ids from the names, in order", func() {
+				So(err, ShouldBeNil)
+				So(e.RoutedNetworkAWSIDs, ShouldResemble, []string{"subnet-00000002", "subnet-00000001"})
+				So(f.Count("CreateRoute"), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When only the names are set and one is unknown", func() {
+			e := event([]string{"web", "cache"}, nil)
+			err := e.Update()
+
+			Convey("It should fail before routing anything", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Routed network cache not found")
+				So(f.Count("CreateRoute"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When only the ids are set", func() {
+			e := event(nil, []string{"subnet-00000001"})
+			So(e.Validate(), ShouldBeNil)
+			err := e.Update()
+
+			Convey("It should use them as they are", func() {
+				So(err, ShouldBeNil)
+				So(e.RoutedNetworkAWSIDs, ShouldResemble, []string{"subnet-00000001"})
+			})
+		})
+
+		Convey("When neither is set", func() {
+			e := event(nil, nil)
+
+			Convey("It should fail validation", func() {
+				So(e.Validate(), ShouldEqual, ErrRoutedNetworksEmpty)
+			})
+		})
+	})
+}
+
+func TestSelectPublicNetwork(t *testing.T) {
+	Convey("Given a create event with candidate public networks", t, func() {
+		log.SetOutput(ioutil.Discard)
+		ev := testEvent
+		ev.PublicNetworkAWSID = ""
+		ev.NatGatewayAWSID = ""
+		ev.PublicNetworkAWSIDs = []string{"subnet-0000000a", "subnet-0000000b", "subnet-0000000c"}
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		useFake(f)
+
+		available := func(id string, count *int64) {
+			f.subnets[id] = &ec2.Subnet{
+				SubnetId:                aws.String(id),
+				VpcId:                   aws.String(testEvent.VPCID),
+				State:                   aws.String(ec2.SubnetStateAvailable),
+				AvailableIpAddressCount: count,
+			}
+		}
+
+		e := New("nat.create.aws", data)
+		e.Process()
+		So(e.Validate(), ShouldBeNil)
+
+		Convey("When the candidates have available addresses", func() {
+			available("subnet-0000000a", aws.Int64(10))
+			available("subnet-0000000b", aws.Int64(200))
+			available("subnet-0000000c", aws.Int64(50))
+			err := e.Create()
+
+			Convey("It should place the nat gateway on the least used one", func() {
+				So(err, ShouldBeNil)
+				So(e.PublicNetworkAWSID, ShouldEqual, "subnet-0000000b")
+				So(aws.StringValue(f.natGateways[0].SubnetId), ShouldEqual, "subnet-0000000b")
+			})
+		})
+
+		Convey("When aws does not report the available addresses", func() {
+			available("subnet-0000000a", nil)
+			available("subnet-0000000b", nil)
+			available("subnet-0000000c", nil)
+			err := e.Create()
+
+			Convey("It should use the first one", func() {
+				So(err, ShouldBeNil)
+				So(e.PublicNetworkAWSID, ShouldEqual, "subnet-0000000a")
+			})
+		})
+
+		Convey("When the candidates can't be described", func() {
+			f.hooks["DescribeSubnets"] = func(input interface{}) (interface{}, error) {
+				in := input.(*ec2.DescribeSubnetsInput)
+				if len(in.SubnetIds) == len(ev.PublicNetworkAWSIDs) {
+					return nil, awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil)
+				}
+				return &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{f.subnet(aws.StringValue(in.SubnetIds[0]))}}, nil
+			}
+			err := e.Create()
+
+			Convey("It should use the first one", func() {
+				So(err, ShouldBeNil)
+				So(e.PublicNetworkAWSID, ShouldEqual, "subnet-0000000a")
+			})
+		})
+
+		Convey("When a public network is also given", func() {
+			e.PublicNetworkAWSID = "subnet-00000000"
+			available("subnet-0000000b", aws.Int64(200))
+			err := e.Create()
+
+			Convey("It should use it", func() {
+				So(err, ShouldBeNil)
+				So(e.PublicNetworkAWSID, ShouldEqual, "subnet-00000000")
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stdout)
+		})
+	})
+}
+
+func TestPublicNetworkName(t *testing.T) {
+	Convey("Given an event naming its public network", t, func() {
+		f := newFakeEC2()
+		useFake(f)
+		namedSubnet(f, "subnet-0000000a", "public")
+		namedSubnet(f, "subnet-00000001", "web")
+
+		event := func(subject, name string) *Event {
+			ev := testEvent
+			ev.PublicNetwork = name
+			ev.PublicNetworkAWSID = ""
+			data, _ := json.Marshal(ev)
+			e := New(subject, data)
+			e.Process()
+			return &e
+		}
+
+		Convey("When creating it", func() {
+			e := event("nat.create.aws", "public")
+			So(e.Validate(), ShouldBeNil)
+			err := e.Create()
+
+			Convey("It should place the nat gateway on the named network", func() {
+				So(err, ShouldBeNil)
+				So(e.PublicNetworkAWSID, ShouldEqual, "subnet-0000000a")
+				So(aws.StringValue(f.natGateways[0].SubnetId), ShouldEqual, "subnet-0000000a")
+			})
+		})
+
+		Convey("When getting it by its network", func() {
+			e := event("nat.get.aws", "public")
+			e.NatGatewayAWSID = ""
+			f.natGateways = append(f.natGateways, &ec2.NatGateway{
+				NatGatewayId: aws.String("nat-0000000a"),
+				SubnetId:     aws.String("subnet-0000000a"),
+				State:        aws.String(ec2.NatGatewayStateAvailable),
+				NatGatewayAddresses: []*ec2.NatGatewayAddress{
+					{AllocationId: aws.String("eipalloc-0000000a"), PublicIp: aws.String("52.0.0.1")},
+				},
+			})
+			So(e.Validate(), ShouldBeNil)
+			err := e.Get()
+
+			Convey("It should find the nat gateway on it", func() {
+				So(err, ShouldBeNil)
+				So(e.NatGatewayAWSID, ShouldEqual, "nat-0000000a")
+			})
+		})
+
+		Convey("When no network has the name", func() {
+			e := event("nat.create.aws", "dmz")
+			err := e.Create()
+
+			Convey("It should error before creating anything", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Public network dmz not found")
+				So(f.Count("AllocateAddress"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When several networks have the name", func() {
+			namedSubnet(f, "subnet-0000000b", "public")
+			e := event("nat.create.aws", "public")
+			err := e.Create()
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Public network name public matches more than one network")
+			})
+		})
+
+		Convey("When the public network id is also given", func() {
+			e := event("nat.create.aws", "web")
+			e.PublicNetworkAWSID = testEvent.PublicNetworkAWSID
+			err := e.Create()
+
+			Convey("It should keep the id", func() {
+				So(err, ShouldBeNil)
+				So(e.PublicNetworkAWSID, ShouldEqual, testEvent.PublicNetworkAWSID)
+			})
+		})
+	})
+}
+
+func TestRoutedNetworkVPCs(t *testing.T) {
+	Convey("Given an event routing networks", t, func() {
+		ev := testEvent
+		ev.RoutedNetworkAWSIDs = []string{"subnet-00000001", "subnet-00000002", "subnet-00000003"}
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		useFake(f)
+
+		onVPC := func(id, vpc string) {
+			f.subnets[id] = &ec2.Subnet{
+				SubnetId: aws.String(id),
+				VpcId:    aws.String(vpc),
+				State:    aws.String(ec2.SubnetStateAvailable),
+			}
+		}
+
+		run := func(subject string) error {
+			e := New(subject, data)
+			e.Process()
+			if subject == "nat.create.aws" {
+				return e.Create()
+			}
+			withNatGateway(f)
+			return e.Update()
+		}
+
+		Convey("When they are all on the event vpc", func() {
+			err := run("nat.create.aws")
+
+			Convey("It should route them", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateRoute"), ShouldEqual, 3)
+			})
+		})
+
+		Convey("When some are on other vpcs", func() {
+			onVPC("subnet-00000002", "vpc-other")
+			onVPC("subnet-00000003", "vpc-another")
+
+			Convey("It should refuse to create the nat gateway", func() {
+				err := run("nat.create.aws")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Routed networks are not on vpc "+testEvent.VPCID+": subnet-00000002 on vpc-other, subnet-00000003 on vpc-another")
+				So(f.Count("AllocateAddress"), ShouldEqual, 0)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 0)
+			})
+
+			Convey("It should refuse to route them on update", func() {
+				err := run("nat.update.aws")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, "Routed networks are not on vpc")
+				So(f.Count("CreateRoute"), ShouldEqual, 0)
+			})
+		})
+	})
+}
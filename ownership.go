@@ -0,0 +1,161 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+const (
+	// ownedTagKey tags the resources the connector created
+	ownedTagKey = "ernest_owned"
+	// sharedTagKey tags the existing resources the connector uses
+	sharedTagKey = "ernest_shared"
+	// batchTagKey tags the resources the connector created with the batch
+	// that created them
+	batchTagKey = "ernest_batch_id"
+)
+
+const (
+	// tagFailureIgnore keeps the resources created when tagging them fails
+	tagFailureIgnore = "ignore"
+	// tagFailureFail fails the operation when tagging a resource fails
+	tagFailureFail = "fail"
+)
+
+// ErrTagFailurePolicyInvalid ...
+var ErrTagFailurePolicyInvalid = errors.New("Tag failure policy must be ignore or fail")
+
+// hasTag checks if a resource has a tag with the given value
+func hasTag(tags []*ec2.Tag, key, value string) bool {
+	for _, t := range tags {
+		if aws.StringValue(t.Key) == key && aws.StringValue(t.Value) == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tagOwnership tags an existing resource the connector uses as shared, so
+// teardown only ever deletes what the connector created
+func (ev *Event) tagOwnership(svc ec2iface.EC2API, id *string, tags []*ec2.Tag) error {
+	// the resources the connector created stay its own when reused
+	if hasTag(tags, ownedTagKey, "true") {
+		return nil
+	}
+
+	return ev.applyTags(svc, id, tags, map[string]string{sharedTagKey: "true"})
+}
+
+// tagCreated tags a resource the event created as owned by the connector,
+// named after the name template when one is configured
+func (ev *Event) tagCreated(svc ec2iface.EC2API, id *string, resource, subnet string) error {
+	wanted, err := ev.namedTags(svc, ev.createdTags(map[string]string{ownedTagKey: "true"}), resource, subnet)
+	if err != nil {
+		return err
+	}
+
+	return ev.applyTags(svc, id, nil, wanted)
+}
+
+// createdTags returns the tags to set on a resource the event created,
+// with the scope tag and the batch creating it
+func (ev *Event) createdTags(tags map[string]string) map[string]string {
+	tags = withScopeTag(tags)
+	if ev.BatchID == "" {
+		return tags
+	}
+
+	owned := map[string]string{batchTagKey: ev.BatchID}
+	for k, v := range tags {
+		owned[k] = v
+	}
+
+	return owned
+}
+
+// checkBatchOwnership refuses, when enforcing batch ownership, to delete a
+// nat gateway another batch created, so concurrent batches can't remove
+// each other's resources. Untagged nat gateways can be deleted by any.
+func (ev *Event) checkBatchOwnership(svc ec2iface.EC2API) error {
+	if !ev.EnforceBatchOwnership {
+		return nil
+	}
+
+	gw, err := ev.natGatewayByID(svc, ev.NatGatewayAWSID)
+	if err == ErrNatGatewayNotFound || isAWSError(err, "NatGatewayNotFound") {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, t := range gw.Tags {
+		if aws.StringValue(t.Key) == batchTagKey && aws.StringValue(t.Value) != ev.BatchID {
+			return fmt.Errorf("Nat gateway %s is owned by batch %s", ev.NatGatewayAWSID, aws.StringValue(t.Value))
+		}
+	}
+
+	return nil
+}
+
+// applyTags sets the wanted tags on a resource, only calling aws for the
+// tags missing or with another value, so re-runs make no tagging calls
+func (ev *Event) applyTags(svc ec2iface.EC2API, id *string, tags []*ec2.Tag, wanted map[string]string) error {
+	var keys []string
+	for k, v := range wanted {
+		if !hasTag(tags, k, v) {
+			keys = append(keys, k)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	sort.Strings(keys)
+
+	req := ec2.CreateTagsInput{
+		Resources: []*string{id},
+	}
+
+	for _, k := range keys {
+		req.Tags = append(req.Tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(wanted[k])})
+	}
+
+	_, err := svc.CreateTags(&req)
+
+	// aws refusing the tags leaves the resource usable, only untagged
+	if _, ok := err.(awserr.Error); ok && ev.TagFailurePolicy != tagFailureFail {
+		ev.warn(fmt.Sprintf("Could not tag %s: %s", aws.StringValue(id), err.Error()))
+		return nil
+	}
+
+	return err
+}
+
+// validateTagFailurePolicy checks the tag failure policy is known
+func (ev *Event) validateTagFailurePolicy() error {
+	switch ev.TagFailurePolicy {
+	case "", tagFailureIgnore, tagFailureFail:
+		return nil
+	}
+
+	return ErrTagFailurePolicyInvalid
+}
+
+// isOwned checks if a resource was created by the connector and isn't
+// used as a shared resource as well
+func isOwned(tags []*ec2.Tag) bool {
+	return hasTag(tags, ownedTagKey, "true") && !hasTag(tags, sharedTagKey, "true")
+}
@@ -0,0 +1,269 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOwnershipTags(t *testing.T) {
+	deletePollInterval = time.Millisecond
+	deleteGracePeriod = 0
+
+	Convey("Given a create event", t, func() {
+		data, _ := json.Marshal(testEvent)
+		f := newFakeEC2()
+		useFake(f)
+
+		e := New("nat.create.aws", data)
+		e.Process()
+
+		Convey("When the vpc has no internet gateway or route tables", func() {
+			err := e.Create()
+
+			Convey("It should tag the ones it creates as owned", func() {
+				So(err, ShouldBeNil)
+				So(hasTag(f.internetGateways[0].Tags, "ernest_owned", "true"), ShouldBeTrue)
+				So(hasTag(f.routeTables[0].Tags, "ernest_owned", "true"), ShouldBeTrue)
+				So(hasTag(f.routeTables[0].Tags, "ernest_shared", "true"), ShouldBeFalse)
+			})
+
+			Convey("It should report the internet gateway was required and created", func() {
+				So(*e.InternetGatewayNeeded, ShouldBeTrue)
+				So(*e.InternetGatewayCreated, ShouldBeTrue)
+			})
+
+			Convey("It should keep them owned when reused", func() {
+				payload, _ := json.Marshal(e)
+				u := New("nat.update.aws", payload)
+				u.Process()
+				So(u.Update(), ShouldBeNil)
+				So(hasTag(f.routeTables[0].Tags, "ernest_shared", "true"), ShouldBeFalse)
+			})
+		})
+
+		Convey("When the vpc already has an internet gateway and route table", func() {
+			f.internetGateways = append(f.internetGateways, &ec2.InternetGateway{
+				InternetGatewayId: aws.String("igw-existing"),
+				Attachments: []*ec2.InternetGatewayAttachment{
+					&ec2.InternetGatewayAttachment{VpcId: aws.String(testEvent.VPCID)},
+				},
+			})
+			f.routeTables = append(f.routeTables, &ec2.RouteTable{
+				RouteTableId: aws.String("rtb-existing"),
+				VpcId:        aws.String(testEvent.VPCID),
+				Associations: []*ec2.RouteTableAssociation{
+					&ec2.RouteTableAssociation{
+						RouteTableAssociationId: aws.String("rtbassoc-existing"),
+						SubnetId:                aws.String("subnet-00000001"),
+					},
+				},
+			})
+			err := e.Create()
+
+			Convey("It should tag them as shared", func() {
+				So(err, ShouldBeNil)
+				So(hasTag(f.internetGateways[0].Tags, "ernest_shared", "true"), ShouldBeTrue)
+				So(hasTag(f.routeTables[0].Tags, "ernest_shared", "true"), ShouldBeTrue)
+				So(hasTag(f.routeTables[0].Tags, "ernest_owned", "true"), ShouldBeFalse)
+			})
+
+			Convey("It should report the internet gateway was required and reused", func() {
+				So(*e.InternetGatewayNeeded, ShouldBeTrue)
+				So(*e.InternetGatewayCreated, ShouldBeFalse)
+			})
+
+			Convey("It should keep the shared route table on teardown", func() {
+				payload, _ := json.Marshal(e)
+				d := New("nat.delete.aws", payload)
+				d.Process()
+				d.Teardown = true
+				So(d.Delete(), ShouldBeNil)
+				So(f.Count("DeleteRoute"), ShouldEqual, 1)
+				So(f.Count("DeleteRouteTable"), ShouldEqual, 0)
+				So(len(f.routeTables), ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestNatGatewayTags(t *testing.T) {
+	Convey("Given an update event with nat gateway tags", t, func() {
+		f := newFakeEC2()
+		useFake(f)
+		gw := withNatGateway(f)
+		gw.Tags = []*ec2.Tag{
+			&ec2.Tag{Key: aws.String("Name"), Value: aws.String("test-nat")},
+		}
+
+		// the routed network route table already exists and is tagged
+		f.routeTables = append(f.routeTables, &ec2.RouteTable{
+			RouteTableId: aws.String("rtb-00000001"),
+			VpcId:        aws.String(testEvent.VPCID),
+			Associations: []*ec2.RouteTableAssociation{
+				&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000001")},
+			},
+			Tags: []*ec2.Tag{
+				&ec2.Tag{Key: aws.String("ernest_shared"), Value: aws.String("true")},
+			},
+		})
+
+		update := func(tags map[string]string) error {
+			ev := testEvent
+			ev.Tags = tags
+			data, _ := json.Marshal(ev)
+			e := New("nat.update.aws", data)
+			e.Process()
+			return e.Update()
+		}
+
+		Convey("When the nat gateway already has the tags", func() {
+			err := update(map[string]string{"Name": "test-nat"})
+
+			Convey("It should not tag anything", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateTags"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When tags are missing or changed", func() {
+			var req *ec2.CreateTagsInput
+			f.hooks["CreateTags"] = func(input interface{}) (interface{}, error) {
+				req = input.(*ec2.CreateTagsInput)
+				return &ec2.CreateTagsOutput{}, nil
+			}
+			err := update(map[string]string{"Name": "prod-nat", "env": "prod"})
+
+			Convey("It should only set those tags", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateTags"), ShouldEqual, 1)
+				So(aws.StringValueSlice(req.Resources), ShouldResemble, []string{testEvent.NatGatewayAWSID})
+				So(req.Tags, ShouldResemble, []*ec2.Tag{
+					&ec2.Tag{Key: aws.String("Name"), Value: aws.String("prod-nat")},
+					&ec2.Tag{Key: aws.String("env"), Value: aws.String("prod")},
+				})
+			})
+		})
+	})
+}
+
+func TestTagFailurePolicy(t *testing.T) {
+	Convey("Given aws refuses to tag resources", t, func() {
+		f := newFakeEC2()
+		useFake(f)
+		f.errors["CreateTags"] = awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil)
+
+		create := func(policy string) (Event, error) {
+			ev := testEvent
+			ev.TagFailurePolicy = policy
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+			if err := e.Validate(); err != nil {
+				return e, err
+			}
+			return e, e.Create()
+		}
+
+		Convey("When creating with the default policy", func() {
+			e, err := create("")
+
+			Convey("It should create the resources untagged and warn", func() {
+				So(err, ShouldBeNil)
+				So(e.NatGatewayAWSID, ShouldNotBeEmpty)
+				So(len(e.RouteChanges), ShouldEqual, 1)
+				So(len(e.Warnings), ShouldBeGreaterThan, 0)
+				So(e.Warnings[0], ShouldStartWith, "Could not tag igw-")
+			})
+		})
+
+		Convey("When creating with the fail policy", func() {
+			_, err := create("fail")
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "UnauthorizedOperation")
+			})
+		})
+
+		Convey("When the policy is unknown", func() {
+			_, err := create("retry")
+
+			Convey("It should not validate", func() {
+				So(err, ShouldEqual, ErrTagFailurePolicyInvalid)
+			})
+		})
+	})
+}
+
+func TestBatchOwnership(t *testing.T) {
+	deletePollInterval = time.Millisecond
+	deleteGracePeriod = 0
+
+	Convey("Given a nat gateway created by a batch", t, func() {
+		ev := testEvent
+		ev.BatchID = "batch-a"
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		useFake(f)
+
+		c := New("nat.create.aws", data)
+		c.Process()
+		So(c.Create(), ShouldBeNil)
+
+		Convey("When it is created", func() {
+			Convey("It should tag what it created with the batch", func() {
+				So(hasTag(f.natGateways[0].Tags, "ernest_batch_id", "batch-a"), ShouldBeTrue)
+				So(hasTag(f.internetGateways[0].Tags, "ernest_batch_id", "batch-a"), ShouldBeTrue)
+				So(hasTag(f.routeTables[0].Tags, "ernest_batch_id", "batch-a"), ShouldBeTrue)
+			})
+		})
+
+		del := func(batch string, enforce bool) error {
+			payload, _ := json.Marshal(c)
+			d := New("nat.delete.aws", payload)
+			d.Process()
+			d.BatchID = batch
+			d.EnforceBatchOwnership = enforce
+			return d.Delete()
+		}
+
+		Convey("When the same batch deletes it enforcing ownership", func() {
+			err := del("batch-a", true)
+
+			Convey("It should delete it", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("DeleteNatGateway"), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When another batch deletes it enforcing ownership", func() {
+			err := del("batch-b", true)
+
+			Convey("It should refuse to", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Nat gateway "+c.NatGatewayAWSID+" is owned by batch batch-a")
+				So(f.Count("DeleteNatGateway"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When another batch deletes it without enforcing ownership", func() {
+			err := del("batch-b", false)
+
+			Convey("It should delete it", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("DeleteNatGateway"), ShouldEqual, 1)
+			})
+		})
+	})
+}
@@ -0,0 +1,112 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// permissionCheck is a dry run of a mutating call an action makes
+type permissionCheck struct {
+	name string
+	run  func() error
+}
+
+// permissionChecks returns the dry runs of the mutating calls an action
+// makes that can be dry run before knowing the resources it creates
+func (ev *Event) permissionChecks(svc ec2iface.EC2API, action string) []permissionCheck {
+	var checks []permissionCheck
+
+	switch action {
+	case "create":
+		if !ev.isPrivate() {
+			checks = append(checks,
+				permissionCheck{"AllocateAddress", func() error {
+					_, err := svc.AllocateAddress(&ec2.AllocateAddressInput{DryRun: aws.Bool(true)})
+					return err
+				}},
+				permissionCheck{"CreateInternetGateway", func() error {
+					_, err := svc.CreateInternetGateway(&ec2.CreateInternetGatewayInput{DryRun: aws.Bool(true)})
+					return err
+				}},
+			)
+		}
+		// a private nat gateway needs no allocation, but the permission
+		// to create it is the same
+		checks = append(checks, permissionCheck{"CreateNatGateway", func() error {
+			_, err := svc.CreateNatGateway(&ec2.CreateNatGatewayInput{
+				DryRun:           aws.Bool(true),
+				SubnetId:         aws.String(ev.PublicNetworkAWSID),
+				ConnectivityType: aws.String(ec2.ConnectivityTypePrivate),
+			})
+			return err
+		}})
+		fallthrough
+	case "update":
+		checks = append(checks, permissionCheck{"CreateRouteTable", func() error {
+			_, err := svc.CreateRouteTable(&ec2.CreateRouteTableInput{
+				DryRun: aws.Bool(true),
+				VpcId:  aws.String(ev.VPCID),
+			})
+			return err
+		}})
+	case "delete":
+		checks = append(checks, permissionCheck{"DeleteNatGateway", func() error {
+			_, err := svc.DeleteNatGateway(&ec2.DeleteNatGatewayInput{
+				DryRun:       aws.Bool(true),
+				NatGatewayId: aws.String(ev.NatGatewayAWSID),
+			})
+			return err
+		}})
+		if ev.Teardown && ev.NatGatewayAllocationID != "" {
+			checks = append(checks, permissionCheck{"ReleaseAddress", func() error {
+				_, err := svc.ReleaseAddress(&ec2.ReleaseAddressInput{
+					DryRun:       aws.Bool(true),
+					AllocationId: aws.String(ev.NatGatewayAllocationID),
+				})
+				return err
+			}})
+		}
+	}
+
+	return checks
+}
+
+// checkPermissions dry runs the mutating calls of an action before any
+// of them is made, reporting every call the credentials are not allowed
+// to make at once rather than failing half way through provisioning
+func (ev *Event) checkPermissions(svc ec2iface.EC2API, action string) error {
+	var denied []string
+
+	for _, check := range ev.permissionChecks(svc, action) {
+		err := check.run()
+		switch {
+		case isAWSError(err, "DryRunOperation"):
+		case isAWSError(err, "UnauthorizedOperation"):
+			denied = append(denied, check.name)
+		case err != nil:
+			return err
+		}
+	}
+
+	if len(denied) > 0 {
+		return &PermissionsError{Calls: denied}
+	}
+
+	return nil
+}
+
+// PermissionsError : the aws calls the credentials are not allowed to make
+type PermissionsError struct {
+	Calls []string
+}
+
+func (e *PermissionsError) Error() string {
+	return "Not authorized to call " + strings.Join(e.Calls, ", ")
+}
@@ -0,0 +1,93 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCheckPermissions(t *testing.T) {
+	Convey("Given a create event checking its permissions", t, func() {
+		ev := testEvent
+		ev.CheckPermissions = true
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		useFake(f)
+
+		e := New("nat.create.aws", data)
+		e.Process()
+
+		Convey("When the credentials can make every call", func() {
+			err := e.Create()
+
+			Convey("It should create the nat gateway", func() {
+				So(err, ShouldBeNil)
+				So(len(f.natGateways), ShouldEqual, 1)
+				So(f.Count("AllocateAddress"), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When the credentials can't make some of the calls", func() {
+			unauthorized := func(input interface{}) (interface{}, error) {
+				return nil, awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil)
+			}
+			f.hooks["CreateNatGateway"] = unauthorized
+			f.hooks["CreateRouteTable"] = unauthorized
+			err := e.Create()
+
+			Convey("It should report them all before making any change", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Not authorized to call CreateNatGateway, CreateRouteTable")
+				So(err.(*PermissionsError).Calls, ShouldResemble, []string{"CreateNatGateway", "CreateRouteTable"})
+				So(f.Calls(), ShouldResemble, []string{
+					"DescribeSubnets",
+					"AllocateAddress",
+					"CreateInternetGateway",
+					"CreateNatGateway",
+					"CreateRouteTable",
+				})
+				So(len(f.natGateways), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the public network is picked among candidates", func() {
+			ev.PublicNetworkAWSID = ""
+			ev.PublicNetworkAWSIDs = []string{"subnet-00000000"}
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+
+			var dryRun *ec2.CreateNatGatewayInput
+			f.hooks["CreateNatGateway"] = func(input interface{}) (interface{}, error) {
+				dryRun = input.(*ec2.CreateNatGatewayInput)
+				return nil, awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil)
+			}
+			e.Create()
+
+			Convey("It should dry run the nat gateway on the picked network", func() {
+				So(aws.BoolValue(dryRun.DryRun), ShouldBeTrue)
+				So(aws.StringValue(dryRun.SubnetId), ShouldEqual, "subnet-00000000")
+			})
+		})
+
+		Convey("When the dry run fails for another reason", func() {
+			f.errors["AllocateAddress"] = awserr.New("AddressLimitExceeded", "too many addresses", nil)
+			err := e.Create()
+
+			Convey("It should fail with that error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "AddressLimitExceeded")
+				So(f.Count("CreateInternetGateway"), ShouldEqual, 0)
+			})
+		})
+	})
+}
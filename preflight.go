@@ -0,0 +1,92 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// ValidationReport : the outcome of the pre-flight checks of an event
+type ValidationReport struct {
+	Valid  bool     `json:"valid"`
+	Issues []string `json:"issues,omitempty"`
+}
+
+// Preflight : checks, without changing anything, that the credentials
+// are valid and the vpc and networks of the event exist, reporting every
+// issue found so an operation can be gated on it
+func (ev *Event) Preflight() error {
+	svc, err := ev.client()
+	if err != nil {
+		return err
+	}
+
+	var issues []string
+
+	_, err = svc.DescribeVpcs(&ec2.DescribeVpcsInput{
+		VpcIds: []*string{aws.String(ev.VPCID)},
+	})
+
+	switch {
+	case isAWSError(err, "AuthFailure"), isAWSError(err, "UnauthorizedOperation"):
+		issues = append(issues, "Datacenter credentials are not valid")
+	case isAWSError(err, "InvalidVpcID.NotFound"):
+		issues = append(issues, fmt.Sprintf("VPC %s does not exist", ev.VPCID))
+	case err != nil:
+		return err
+	}
+
+	// nothing else can be checked without valid credentials
+	if err == nil {
+		err = ev.resolvePublicNetwork(svc)
+		if err != nil {
+			return err
+		}
+
+		networks := append([]string{ev.PublicNetworkAWSID}, ev.RoutedNetworkAWSIDs...)
+		for _, network := range networks {
+			issue, err := ev.checkNetwork(svc, network)
+			if err != nil {
+				return err
+			}
+
+			if issue != "" {
+				issues = append(issues, issue)
+			}
+		}
+	}
+
+	ev.Validation = &ValidationReport{
+		Valid:  len(issues) == 0,
+		Issues: issues,
+	}
+
+	return nil
+}
+
+// checkNetwork returns the issue found with a network, if any
+func (ev *Event) checkNetwork(svc ec2iface.EC2API, network string) (string, error) {
+	resp, err := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{
+		SubnetIds: []*string{aws.String(network)},
+	})
+
+	if isAWSError(err, "InvalidSubnetID.NotFound") || (err == nil && len(resp.Subnets) == 0) {
+		return fmt.Sprintf("Network %s does not exist", network), nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if vpc := aws.StringValue(resp.Subnets[0].VpcId); vpc != ev.VPCID {
+		return fmt.Sprintf("Network %s is on vpc %s instead of %s", network, vpc, ev.VPCID), nil
+	}
+
+	return "", nil
+}
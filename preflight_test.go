@@ -0,0 +1,86 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPreflight(t *testing.T) {
+	Convey("Given a validate event", t, func() {
+		ev := testEvent
+		ev.RoutedNetworkAWSIDs = []string{"subnet-00000001", "subnet-00000002"}
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		f.strictSubnets = true
+		useFake(f)
+
+		subnet := func(id, vpc string) {
+			f.subnets[id] = &ec2.Subnet{SubnetId: aws.String(id), VpcId: aws.String(vpc)}
+		}
+
+		e := New("nat.validate.aws", data)
+		e.Process()
+		So(e.Validate(), ShouldBeNil)
+
+		Convey("When the vpc and every network exist", func() {
+			subnet("subnet-00000000", testEvent.VPCID)
+			subnet("subnet-00000001", testEvent.VPCID)
+			subnet("subnet-00000002", testEvent.VPCID)
+			err := e.Execute("validate")
+
+			Convey("It should pass without changing anything", func() {
+				So(err, ShouldBeNil)
+				So(e.Validation, ShouldResemble, &ValidationReport{Valid: true})
+				So(e.APICalls.Mutating, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When networks are missing or on another vpc", func() {
+			subnet("subnet-00000000", testEvent.VPCID)
+			subnet("subnet-00000002", "vpc-other")
+			err := e.Execute("validate")
+
+			Convey("It should fail with every issue found", func() {
+				So(err, ShouldBeNil)
+				So(e.Validation.Valid, ShouldBeFalse)
+				So(e.Validation.Issues, ShouldResemble, []string{
+					"Network subnet-00000001 does not exist",
+					"Network subnet-00000002 is on vpc vpc-other instead of vpc-0000000",
+				})
+			})
+		})
+
+		Convey("When the vpc does not exist", func() {
+			f.errors["DescribeVpcs"] = awserr.New("InvalidVpcID.NotFound", "The vpc ID 'vpc-0000000' does not exist", nil)
+			subnet("subnet-00000000", testEvent.VPCID)
+			subnet("subnet-00000001", testEvent.VPCID)
+			subnet("subnet-00000002", testEvent.VPCID)
+			e.Execute("validate")
+
+			Convey("It should fail", func() {
+				So(e.Validation.Valid, ShouldBeFalse)
+				So(e.Validation.Issues, ShouldResemble, []string{"VPC vpc-0000000 does not exist"})
+			})
+		})
+
+		Convey("When the credentials are not valid", func() {
+			f.errors["DescribeVpcs"] = awserr.New("AuthFailure", "AWS was not able to validate the provided access credentials", nil)
+			e.Execute("validate")
+
+			Convey("It should fail without checking the networks", func() {
+				So(e.Validation.Issues, ShouldResemble, []string{"Datacenter credentials are not valid"})
+				So(f.Count("DescribeSubnets"), ShouldEqual, 0)
+			})
+		})
+	})
+}
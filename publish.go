@@ -0,0 +1,47 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"log"
+	"time"
+)
+
+var (
+	// publishAttempts is how many times a result is published before
+	// giving up on it
+	publishAttempts = 5
+	// publishRetryInterval is the wait before the first publish retry,
+	// doubling with every retry
+	publishRetryInterval = time.Millisecond * 100
+)
+
+// publish sends a message to nats
+var publish = func(subject string, data []byte) error {
+	return nc.Publish(subject, data)
+}
+
+// publishResult publishes the result of an event, retrying while nats is
+// unavailable, as a lost result leaves the operation waiting for it
+func publishResult(subject string, data []byte) error {
+	interval := publishRetryInterval
+
+	var err error
+	for attempt := 1; attempt <= publishAttempts; attempt++ {
+		err = publish(subject, data)
+		if err == nil {
+			return nil
+		}
+
+		if attempt < publishAttempts {
+			sleep(interval)
+			interval *= 2
+		}
+	}
+
+	log.Printf("Error: result lost, could not publish to %s after %d attempts: %s", subject, publishAttempts, err.Error())
+
+	return err
+}
@@ -0,0 +1,74 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPublishRetry(t *testing.T) {
+	Convey("Given a completed event", t, func() {
+		log.SetOutput(ioutil.Discard)
+		data, _ := json.Marshal(testEvent)
+		e := New("nat.create.aws", data)
+		e.Process()
+
+		var waits []time.Duration
+		sleep = func(d time.Duration) { waits = append(waits, d) }
+
+		var published []string
+		failures := 0
+		publish = func(subject string, data []byte) error {
+			if failures > 0 {
+				failures--
+				return errors.New("nats: connection closed")
+			}
+			published = append(published, subject)
+			return nil
+		}
+
+		Convey("When the first publish fails", func() {
+			failures = 1
+			e.Complete()
+
+			Convey("It should retry it", func() {
+				So(published, ShouldResemble, []string{"nat.create.aws.done"})
+				So(waits, ShouldResemble, []time.Duration{publishRetryInterval})
+			})
+		})
+
+		Convey("When every publish fails", func() {
+			failures = publishAttempts
+			err := publishResult("nat.create.aws.done", data)
+
+			Convey("It should give up after backing off", func() {
+				So(err, ShouldNotBeNil)
+				So(published, ShouldBeEmpty)
+				So(waits, ShouldResemble, []time.Duration{
+					publishRetryInterval,
+					publishRetryInterval * 2,
+					publishRetryInterval * 4,
+					publishRetryInterval * 8,
+				})
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stdout)
+			sleep = time.Sleep
+			publish = func(subject string, data []byte) error {
+				return nc.Publish(subject, data)
+			}
+		})
+	})
+}
@@ -0,0 +1,259 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// Region : the resources of an event on one of its regions, as vpc and
+// network ids only exist on a single region, and the outcome of the last
+// operation on it
+type Region struct {
+	VPCID                  string              `json:"vpc_id"`
+	PublicNetworkAWSID     string              `json:"public_network_aws_id"`
+	PublicNetworkAWSIDs    []string            `json:"public_networks_aws_ids,omitempty"`
+	RoutedNetworkAWSIDs    []string            `json:"routed_networks_aws_ids"`
+	NatGatewayAWSID        string              `json:"nat_gateway_aws_id,omitempty"`
+	NatGatewayAWSIDs       []string            `json:"nat_gateways_aws_ids,omitempty"`
+	NatGatewayAllocationID string              `json:"nat_gateway_allocation_id,omitempty"`
+	NatGatewayAllocationIP string              `json:"nat_gateway_allocation_ip,omitempty"`
+	NatGatewayAddresses    []GatewayAddress    `json:"nat_gateway_addresses,omitempty"`
+	PublicIPv4Pool         string              `json:"public_ipv4_pool,omitempty"`
+	ByoipCidr              string              `json:"byoip_cidr,omitempty"`
+	ByoipCidrState         string              `json:"byoip_cidr_state,omitempty"`
+	InternetGatewayID      string              `json:"internet_gateway_id,omitempty"`
+	InternetGatewayState   string              `json:"internet_gateway_state,omitempty"`
+	InternetGatewayNeeded  *bool               `json:"internet_gateway_required,omitempty"`
+	InternetGatewayCreated *bool               `json:"internet_gateway_created,omitempty"`
+	FlowLogIDs             []string            `json:"flow_log_ids,omitempty"`
+	InternetRoute          string              `json:"internet_route,omitempty"`
+	RouteTargetID          string              `json:"route_target_id,omitempty"`
+	ClientToken            string              `json:"client_token,omitempty"`
+	NatGatewayState        string              `json:"nat_gateway_state,omitempty"`
+	FailureCode            string              `json:"failure_code,omitempty"`
+	FailureMessage         string              `json:"failure_message,omitempty"`
+	NatGatewayCreateTime   string              `json:"nat_gateway_create_time,omitempty"`
+	WaitMS                 *int64              `json:"wait_ms,omitempty"`
+	Found                  *bool               `json:"found,omitempty"`
+	VPCDNSSupport          *bool               `json:"vpc_dns_support,omitempty"`
+	VPCDNSHostnames        *bool               `json:"vpc_dns_hostnames,omitempty"`
+	Tags                   map[string]string   `json:"tags,omitempty"`
+	RouteTables            map[string]string   `json:"route_tables,omitempty"`
+	RouteTableSubnets      map[string][]string `json:"route_table_subnets,omitempty"`
+	RouteChanges           []RouteChange       `json:"route_changes,omitempty"`
+	Routes                 *RouteCount         `json:"routes,omitempty"`
+	NatGatewayDeletions    []GatewayDeletion   `json:"nat_gateway_deletions,omitempty"`
+	Rollback               []RollbackStep      `json:"rollback,omitempty"`
+	Verified               *bool               `json:"verified,omitempty"`
+	VerificationFailure    string              `json:"verification_failure,omitempty"`
+	APICalls               *APICallCount       `json:"api_calls,omitempty"`
+	APICallLatencies       []APICallLatency    `json:"api_call_latencies,omitempty"`
+	Validation             *ValidationReport   `json:"validation,omitempty"`
+	AvailabilityZones      map[string][]string `json:"availability_zones,omitempty"`
+	CrossAZNetworks        []string            `json:"cross_az_networks,omitempty"`
+	VPCRouteTables         []VPCRouteTable     `json:"vpc_route_tables,omitempty"`
+	Warnings               []string            `json:"warnings,omitempty"`
+	Status                 string              `json:"status,omitempty"`
+	NoOpReason             string              `json:"no_op_reason,omitempty"`
+	ErrorMessage           string              `json:"error_message,omitempty"`
+	ErrorClass             string              `json:"error_class,omitempty"`
+	Suggestion             string              `json:"suggestion,omitempty"`
+}
+
+// normalizeRegion trims and lowercases a region, as `US-East-1 ` would
+// otherwise only fail once aws is called, and checks aws knows it
+func normalizeRegion(region string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(region))
+
+	if _, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), normalized); !ok {
+		return "", fmt.Errorf("Datacenter region %q is not an aws region", region)
+	}
+
+	return normalized, nil
+}
+
+// validateRegions checks every region of the event has its own resources
+func (ev *Event) validateRegions() error {
+	for _, region := range ev.DatacenterRegions {
+		if _, ok := ev.Regions[region]; !ok {
+			return fmt.Errorf("Region %s resources are missing", region)
+		}
+
+		err := ev.inRegion(region).validateResources()
+		if err != nil {
+			return fmt.Errorf("Region %s: %s", region, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// inRegion returns a copy of the event targeting a single region with the
+// resources of that region
+func (ev *Event) inRegion(region string) *Event {
+	r := *ev
+	r.DatacenterRegion = region
+	r.DatacenterRegions = nil
+	r.Regions = nil
+	r.VPCID = ""
+	r.PublicNetworkAWSID = ""
+	r.PublicNetworkAWSIDs = nil
+	r.RoutedNetworkAWSIDs = nil
+	r.NatGatewayAWSID = ""
+	r.NatGatewayAWSIDs = nil
+	r.NatGatewayAllocationID = ""
+	r.NatGatewayAllocationIP = ""
+	r.NatGatewayAddresses = nil
+	r.PublicIPv4Pool = ""
+	r.ByoipCidr = ""
+	r.ByoipCidrState = ""
+	r.InternetGatewayID = ""
+	r.InternetGatewayState = ""
+	r.InternetGatewayNeeded = nil
+	r.InternetGatewayCreated = nil
+	r.FlowLogIDs = nil
+	r.InternetRoute = ""
+	r.RouteTargetID = ""
+	r.ClientToken = ""
+	r.NatGatewayState = ""
+	r.FailureCode = ""
+	r.FailureMessage = ""
+	r.NatGatewayCreateTime = ""
+	r.WaitMS = nil
+	r.Found = nil
+	r.VPCDNSSupport = nil
+	r.VPCDNSHostnames = nil
+	r.RouteTables = nil
+	r.RouteChanges = nil
+	r.NatGatewayDeletions = nil
+	r.Rollback = nil
+	r.Verified = nil
+	r.VerificationFailure = ""
+	r.AvailabilityZones = nil
+	r.CrossAZNetworks = nil
+	r.VPCRouteTables = nil
+	r.Warnings = nil
+	r.Status = ""
+	r.NoOpReason = ""
+
+	if res, ok := ev.Regions[region]; ok {
+		r.VPCID = res.VPCID
+		r.PublicNetworkAWSID = res.PublicNetworkAWSID
+		r.PublicNetworkAWSIDs = res.PublicNetworkAWSIDs
+		r.RoutedNetworkAWSIDs = res.RoutedNetworkAWSIDs
+		r.NatGatewayAWSID = res.NatGatewayAWSID
+		r.NatGatewayAWSIDs = res.NatGatewayAWSIDs
+		r.NatGatewayAllocationID = res.NatGatewayAllocationID
+		r.NatGatewayAllocationIP = res.NatGatewayAllocationIP
+		r.InternetGatewayID = res.InternetGatewayID
+		r.FlowLogIDs = res.FlowLogIDs
+		r.ClientToken = res.ClientToken
+		r.RouteTargetID = res.RouteTargetID
+	}
+
+	return &r
+}
+
+// fanOut runs an action concurrently on every region of the event, each
+// region with its own ec2 client, and reports the results per region
+func (ev *Event) fanOut(action string) error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	results := make(map[string]*Region)
+	var failed []string
+
+	for _, region := range ev.DatacenterRegions {
+		wg.Add(1)
+		go func(region string) {
+			defer wg.Done()
+
+			r := ev.inRegion(region)
+			err := recovered(func() error {
+				return r.execute(action)
+			})
+
+			res := Region{
+				VPCID:                  r.VPCID,
+				PublicNetworkAWSID:     r.PublicNetworkAWSID,
+				PublicNetworkAWSIDs:    r.PublicNetworkAWSIDs,
+				RoutedNetworkAWSIDs:    r.RoutedNetworkAWSIDs,
+				NatGatewayAWSID:        r.NatGatewayAWSID,
+				NatGatewayAWSIDs:       r.NatGatewayAWSIDs,
+				NatGatewayAllocationID: r.NatGatewayAllocationID,
+				NatGatewayAllocationIP: r.NatGatewayAllocationIP,
+				NatGatewayAddresses:    r.NatGatewayAddresses,
+				PublicIPv4Pool:         r.PublicIPv4Pool,
+				ByoipCidr:              r.ByoipCidr,
+				ByoipCidrState:         r.ByoipCidrState,
+				InternetGatewayID:      r.InternetGatewayID,
+				InternetGatewayState:   r.InternetGatewayState,
+				InternetGatewayNeeded:  r.InternetGatewayNeeded,
+				InternetGatewayCreated: r.InternetGatewayCreated,
+				FlowLogIDs:             r.FlowLogIDs,
+				InternetRoute:          r.InternetRoute,
+				RouteTargetID:          r.RouteTargetID,
+				ClientToken:            r.ClientToken,
+				NatGatewayState:        r.NatGatewayState,
+				FailureCode:            r.FailureCode,
+				FailureMessage:         r.FailureMessage,
+				NatGatewayCreateTime:   r.NatGatewayCreateTime,
+				WaitMS:                 r.WaitMS,
+				Found:                  r.Found,
+				VPCDNSSupport:          r.VPCDNSSupport,
+				VPCDNSHostnames:        r.VPCDNSHostnames,
+				Tags:                   r.Tags,
+				RouteTables:            r.RouteTables,
+				RouteTableSubnets:      r.RouteTableSubnets,
+				RouteChanges:           r.RouteChanges,
+				Routes:                 r.Routes,
+				NatGatewayDeletions:    r.NatGatewayDeletions,
+				Rollback:               r.Rollback,
+				Verified:               r.Verified,
+				VerificationFailure:    r.VerificationFailure,
+				APICalls:               r.APICalls,
+				APICallLatencies:       r.APICallLatencies,
+				Validation:             r.Validation,
+				AvailabilityZones:      r.AvailabilityZones,
+				CrossAZNetworks:        r.CrossAZNetworks,
+				VPCRouteTables:         r.VPCRouteTables,
+				Warnings:               r.Warnings,
+				Status:                 r.Status,
+				NoOpReason:             r.NoOpReason,
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				res.ErrorMessage = err.Error()
+				res.ErrorClass = errorClass(err)
+				if s, ok := err.(suggester); ok {
+					res.Suggestion = s.Suggestion()
+				}
+				failed = append(failed, region+": "+err.Error())
+			}
+			results[region] = &res
+		}(region)
+	}
+
+	wg.Wait()
+
+	ev.Regions = results
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return errors.New("Operation failed on regions " + strings.Join(failed, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,432 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// useRegionFakes gives every region its own fake ec2
+func useRegionFakes(fakes map[string]*fakeEC2) {
+	var mu sync.Mutex
+	ec2Client = func(ev *Event) (ec2iface.EC2API, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return fakes[ev.DatacenterRegion], nil
+	}
+}
+
+// regionFake returns a fake only knowing the given subnets
+func regionFake(vpc string, subnets ...string) *fakeEC2 {
+	f := newFakeEC2()
+	f.strictSubnets = true
+	for _, id := range subnets {
+		f.subnets[id] = &ec2.Subnet{
+			SubnetId: aws.String(id),
+			VpcId:    aws.String(vpc),
+			State:    aws.String(ec2.SubnetStateAvailable),
+		}
+	}
+	return f
+}
+
+func TestRegionFanOut(t *testing.T) {
+	subnetPollInterval = time.Millisecond
+
+	Convey("Given an event targeting two regions", t, func() {
+		ev := testEvent
+		ev.DatacenterRegion = ""
+		ev.VPCID = ""
+		ev.PublicNetworkAWSID = ""
+		ev.RoutedNetworkAWSIDs = nil
+		ev.NatGatewayAWSID = ""
+		ev.DatacenterRegions = []string{"eu-west-1", "us-east-1"}
+		ev.Regions = map[string]*Region{
+			"eu-west-1": &Region{
+				VPCID:               "vpc-eu",
+				PublicNetworkAWSID:  "subnet-eu-public",
+				RoutedNetworkAWSIDs: []string{"subnet-eu-private"},
+			},
+			"us-east-1": &Region{
+				VPCID:               "vpc-us",
+				PublicNetworkAWSID:  "subnet-us-public",
+				RoutedNetworkAWSIDs: []string{"subnet-us-private"},
+			},
+		}
+		data, _ := json.Marshal(ev)
+
+		fakes := map[string]*fakeEC2{
+			"eu-west-1": regionFake("vpc-eu", "subnet-eu-public", "subnet-eu-private"),
+			"us-east-1": regionFake("vpc-us", "subnet-us-public", "subnet-us-private"),
+		}
+		useRegionFakes(fakes)
+
+		Convey("When validating the event", func() {
+			e := New("nat.create.aws", data)
+			e.Process()
+			err := e.Validate()
+
+			Convey("It should not error", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When creating the event", func() {
+			e := New("nat.create.aws", data)
+			e.Process()
+			err := e.Execute("create")
+
+			Convey("It should create a nat gateway on each region with its resources", func() {
+				So(err, ShouldBeNil)
+				So(len(e.Regions), ShouldEqual, 2)
+				for region, f := range fakes {
+					So(f.Count("CreateNatGateway"), ShouldEqual, 1)
+					So(*f.natGateways[0].SubnetId, ShouldEqual, e.Regions[region].PublicNetworkAWSID)
+					So(*f.routeTables[0].VpcId, ShouldEqual, e.Regions[region].VPCID)
+					So(e.Regions[region].NatGatewayAWSID, ShouldEqual, *f.natGateways[0].NatGatewayId)
+					So(e.Regions[region].NatGatewayAllocationIP, ShouldNotBeEmpty)
+					So(e.Regions[region].ErrorMessage, ShouldBeEmpty)
+				}
+				So(e.NatGatewayAWSID, ShouldBeEmpty)
+			})
+
+			Convey("And deleting it from the create result", func() {
+				payload, _ := json.Marshal(e)
+				d := New("nat.delete.aws", payload)
+				d.Process()
+				So(d.Validate(), ShouldBeNil)
+				err := d.Execute("delete")
+
+				Convey("It should delete the nat gateway of each region", func() {
+					So(err, ShouldBeNil)
+					for _, f := range fakes {
+						So(*f.natGateways[0].State, ShouldEqual, "deleted")
+					}
+				})
+			})
+		})
+
+		Convey("When a region uses the networks of another region", func() {
+			ev.Regions["us-east-1"].PublicNetworkAWSID = "subnet-eu-public"
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+			err := e.Execute("create")
+
+			Convey("It should fail on that region", func() {
+				So(err, ShouldNotBeNil)
+				So(e.Regions["eu-west-1"].ErrorMessage, ShouldBeEmpty)
+				So(e.Regions["us-east-1"].ErrorMessage, ShouldEqual, ErrSubnetNotAvailable.Error())
+				So(fakes["us-east-1"].Count("CreateNatGateway"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the operation fails on one region", func() {
+			fakes["us-east-1"].errors["AllocateAddress"] = errors.New("limit exceeded")
+			e := New("nat.create.aws", data)
+			e.Process()
+			err := e.Execute("create")
+
+			Convey("It should report the result of every region", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Operation failed on regions us-east-1: limit exceeded")
+				So(e.Regions["eu-west-1"].NatGatewayAWSID, ShouldNotBeEmpty)
+				So(e.Regions["eu-west-1"].ErrorMessage, ShouldBeEmpty)
+				So(e.Regions["us-east-1"].ErrorMessage, ShouldEqual, "limit exceeded")
+			})
+		})
+
+		Convey("When each region lists its candidate public networks", func() {
+			ev.Regions["eu-west-1"].PublicNetworkAWSID = ""
+			ev.Regions["eu-west-1"].PublicNetworkAWSIDs = []string{"subnet-eu-public"}
+			ev.Regions["us-east-1"].PublicNetworkAWSID = ""
+			ev.Regions["us-east-1"].PublicNetworkAWSIDs = []string{"subnet-us-public"}
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+			So(e.Validate(), ShouldBeNil)
+			err := e.Execute("create")
+
+			Convey("It should place each nat gateway on a network of its region", func() {
+				So(err, ShouldBeNil)
+				So(*fakes["eu-west-1"].natGateways[0].SubnetId, ShouldEqual, "subnet-eu-public")
+				So(*fakes["us-east-1"].natGateways[0].SubnetId, ShouldEqual, "subnet-us-public")
+			})
+		})
+
+		Convey("When each region routes through its own instance", func() {
+			ev.RouteTargetType = "instance"
+			ev.Regions["eu-west-1"].RouteTargetID = "i-eu"
+			ev.Regions["us-east-1"].RouteTargetID = "i-us"
+			data, _ := json.Marshal(ev)
+			e := New("nat.update.aws", data)
+			e.Process()
+
+			Convey("It should route each region through its instance", func() {
+				So(e.Validate(), ShouldBeNil)
+				So(e.inRegion("eu-west-1").RouteTargetID, ShouldEqual, "i-eu")
+				So(e.inRegion("us-east-1").RouteTargetID, ShouldEqual, "i-us")
+			})
+
+			Convey("It should not route a region through the instance of another", func() {
+				e.Regions["us-east-1"].RouteTargetID = ""
+				So(e.Validate().Error(), ShouldEqual, "Region us-east-1: "+ErrRouteTargetInvalid.Error())
+			})
+		})
+
+		Convey("When a region has no resources", func() {
+			delete(ev.Regions, "us-east-1")
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+
+			Convey("It should error", func() {
+				So(e.Validate().Error(), ShouldEqual, "Region us-east-1 resources are missing")
+			})
+		})
+
+		Convey("When a region has no public network", func() {
+			ev.Regions["us-east-1"].PublicNetworkAWSID = ""
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+
+			Convey("It should error", func() {
+				So(e.Validate().Error(), ShouldEqual, "Region us-east-1: Network id invalid")
+			})
+		})
+
+		Convey("When deleting a region without nat gateway", func() {
+			ev.Regions["eu-west-1"].NatGatewayAWSID = "nat-eu"
+			data, _ := json.Marshal(ev)
+			e := New("nat.delete.aws", data)
+			e.Process()
+
+			Convey("It should error", func() {
+				So(e.Validate().Error(), ShouldEqual, "Region us-east-1: Nat Gateway aws id invalid")
+			})
+		})
+	})
+
+	Convey("Given an event with an invalid region list", t, func() {
+		ev := testEvent
+		ev.DatacenterRegion = ""
+
+		Convey("When a region is empty", func() {
+			ev.DatacenterRegions = []string{"eu-west-1", ""}
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+
+			Convey("It should error", func() {
+				So(e.Validate(), ShouldEqual, ErrDatacenterRegionInvalid)
+			})
+		})
+
+		Convey("When a region is repeated", func() {
+			ev.DatacenterRegions = []string{"eu-west-1", "eu-west-1"}
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+
+			Convey("It should error", func() {
+				So(e.Validate(), ShouldEqual, ErrDatacenterRegionInvalid)
+			})
+		})
+
+		Convey("When a region only differs by its casing", func() {
+			ev.DatacenterRegions = []string{"eu-west-1", "EU-West-1"}
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+
+			Convey("It should error", func() {
+				So(e.Validate(), ShouldEqual, ErrDatacenterRegionInvalid)
+			})
+		})
+	})
+}
+
+func TestRegionNormalization(t *testing.T) {
+	Convey("Given an event", t, func() {
+		validate := func(ev Event) (Event, error) {
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+			return e, e.Validate()
+		}
+
+		Convey("When its region has stray whitespace", func() {
+			ev := testEvent
+			ev.DatacenterRegion = " eu-west-1\n"
+			e, err := validate(ev)
+
+			Convey("It should trim it", func() {
+				So(err, ShouldBeNil)
+				So(e.DatacenterRegion, ShouldEqual, "eu-west-1")
+			})
+		})
+
+		Convey("When its region is not lowercase", func() {
+			ev := testEvent
+			ev.DatacenterRegion = "US-East-1"
+			e, err := validate(ev)
+
+			Convey("It should lowercase it", func() {
+				So(err, ShouldBeNil)
+				So(e.DatacenterRegion, ShouldEqual, "us-east-1")
+			})
+		})
+
+		Convey("When its region is not an aws region", func() {
+			ev := testEvent
+			ev.DatacenterRegion = "europe-west1"
+			_, err := validate(ev)
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, `Datacenter region "europe-west1" is not an aws region`)
+			})
+		})
+
+		Convey("When a region of its list is not normalized", func() {
+			ev := testEvent
+			ev.DatacenterRegion = ""
+			ev.DatacenterRegions = []string{"eu-west-1", " US-EAST-1"}
+			ev.Regions = map[string]*Region{
+				"eu-west-1":  &Region{VPCID: "vpc-eu", PublicNetworkAWSID: "subnet-eu", RoutedNetworkAWSIDs: []string{"subnet-eu-1"}},
+				" US-EAST-1": &Region{VPCID: "vpc-us", PublicNetworkAWSID: "subnet-us", RoutedNetworkAWSIDs: []string{"subnet-us-1"}},
+			}
+			e, err := validate(ev)
+
+			Convey("It should normalize it along with its resources", func() {
+				So(err, ShouldBeNil)
+				So(e.DatacenterRegions, ShouldResemble, []string{"eu-west-1", "us-east-1"})
+				So(e.Regions["us-east-1"].VPCID, ShouldEqual, "vpc-us")
+				So(e.Regions, ShouldNotContainKey, " US-EAST-1")
+			})
+		})
+	})
+}
+
+func TestRegionBatchDelete(t *testing.T) {
+	deletePollInterval = time.Millisecond
+	deleteGracePeriod = 0
+
+	Convey("Given nat gateways to delete on two regions", t, func() {
+		fakes := map[string]*fakeEC2{
+			"eu-west-1": newFakeEC2(),
+			"us-east-1": newFakeEC2(),
+		}
+		ids := map[string][]string{
+			"eu-west-1": {"nat-eu-a", "nat-eu-b"},
+			"us-east-1": {"nat-us-a", "nat-us-b"},
+		}
+		for region, f := range fakes {
+			for _, id := range ids[region] {
+				f.natGateways = append(f.natGateways, &ec2.NatGateway{
+					NatGatewayId: aws.String(id),
+					State:        aws.String(ec2.NatGatewayStateAvailable),
+				})
+			}
+		}
+		useRegionFakes(fakes)
+
+		ev := testEvent
+		ev.DatacenterRegion = ""
+		ev.NatGatewayAWSID = ""
+		ev.DatacenterRegions = []string{"eu-west-1", "us-east-1"}
+		ev.Regions = map[string]*Region{
+			"eu-west-1": &Region{VPCID: "vpc-eu", NatGatewayAWSIDs: ids["eu-west-1"]},
+			"us-east-1": &Region{VPCID: "vpc-us", NatGatewayAWSIDs: ids["us-east-1"]},
+		}
+		data, _ := json.Marshal(ev)
+
+		Convey("When deleting them", func() {
+			e := New("nat.delete.aws", data)
+			e.Process()
+			So(e.Validate(), ShouldBeNil)
+			err := e.Execute("delete")
+
+			Convey("It should only delete the nat gateways of each region there", func() {
+				So(err, ShouldBeNil)
+				for region, f := range fakes {
+					So(f.Count("DeleteNatGateway"), ShouldEqual, 2)
+					deletions := e.Regions[region].NatGatewayDeletions
+					So(len(deletions), ShouldEqual, 2)
+					for i, d := range deletions {
+						So(d.NatGatewayAWSID, ShouldEqual, ids[region][i])
+						So(d.ErrorMessage, ShouldBeEmpty)
+					}
+				}
+			})
+		})
+	})
+}
+
+func TestRegionGet(t *testing.T) {
+	Convey("Given nat gateways to get on two regions", t, func() {
+		fakes := map[string]*fakeEC2{
+			"eu-west-1": newFakeEC2(),
+			"us-east-1": newFakeEC2(),
+		}
+		for region, f := range fakes {
+			f.natGateways = append(f.natGateways, &ec2.NatGateway{
+				NatGatewayId: aws.String("nat-" + region),
+				SubnetId:     aws.String("subnet-" + region),
+				State:        aws.String(ec2.NatGatewayStateAvailable),
+				NatGatewayAddresses: []*ec2.NatGatewayAddress{
+					{AllocationId: aws.String("eipalloc-" + region), PublicIp: aws.String("52.0.0.1")},
+				},
+				Tags: []*ec2.Tag{
+					&ec2.Tag{Key: aws.String("Name"), Value: aws.String("nat-" + region)},
+				},
+			})
+		}
+		fakes["us-east-1"].vpcAttributes[ec2.VpcAttributeNameEnableDnsHostnames] = true
+		useRegionFakes(fakes)
+
+		ev := testEvent
+		ev.DatacenterRegion = ""
+		ev.NatGatewayAWSID = ""
+		ev.DatacenterRegions = []string{"eu-west-1", "us-east-1"}
+		ev.Regions = map[string]*Region{
+			"eu-west-1": &Region{VPCID: "vpc-eu", NatGatewayAWSID: "nat-eu-west-1"},
+			"us-east-1": &Region{VPCID: "vpc-us", NatGatewayAWSID: "nat-us-east-1"},
+		}
+		data, _ := json.Marshal(ev)
+
+		Convey("When getting them", func() {
+			e := New("nat.get.aws", data)
+			e.Process()
+			So(e.Validate(), ShouldBeNil)
+			err := e.Execute("get")
+
+			Convey("It should report the tags of each region", func() {
+				So(err, ShouldBeNil)
+				for region := range fakes {
+					So(e.Regions[region].Tags, ShouldResemble, map[string]string{"Name": "nat-" + region})
+				}
+			})
+
+			Convey("It should report the dns attributes of each region vpc", func() {
+				So(*e.Regions["eu-west-1"].VPCDNSSupport, ShouldBeTrue)
+				So(*e.Regions["eu-west-1"].VPCDNSHostnames, ShouldBeFalse)
+				So(*e.Regions["us-east-1"].VPCDNSSupport, ShouldBeTrue)
+				So(*e.Regions["us-east-1"].VPCDNSHostnames, ShouldBeTrue)
+			})
+		})
+	})
+}
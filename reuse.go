@@ -0,0 +1,148 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// liveNatGateway returns the nat gateway of the event, or nothing when it
+// doesn't exist or is deleted, being deleted or failed
+func (ev *Event) liveNatGateway(svc ec2iface.EC2API) (*ec2.NatGateway, error) {
+	gw, err := ev.natGatewayByID(svc, ev.NatGatewayAWSID)
+	if err == ErrNatGatewayNotFound || isAWSError(err, "NatGatewayNotFound") {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch aws.StringValue(gw.State) {
+	case ec2.NatGatewayStateDeleting, ec2.NatGatewayStateDeleted, ec2.NatGatewayStateFailed:
+		return nil, nil
+	}
+
+	return gw, nil
+}
+
+// recreateNatGateway creates a new nat gateway in place of the one of the
+// event, which no longer exists, with a client token of its own so aws
+// doesn't return the gone one
+func (ev *Event) recreateNatGateway(svc ec2iface.EC2API) error {
+	ev.warn(fmt.Sprintf("Nat gateway %s no longer exists, creating a new one", ev.NatGatewayAWSID))
+
+	h := sha256.Sum256([]byte(ev.clientToken() + ev.NatGatewayAWSID))
+	ev.ClientToken = hex.EncodeToString(h[:])
+	ev.NatGatewayAWSID = ""
+
+	return ev.create(svc)
+}
+
+// existingNatGateway returns the nat gateway of the event when it still
+// exists, so a create retried once the gateway was created reuses it.
+// Without its id, the gateway the event batch created on the public
+// network is reused, so re-running a create only converges its routes.
+// A gateway on another network than the requested one is reused with a
+// warning, or refused when the event asks for a strict gateway subnet.
+func (ev *Event) existingNatGateway(svc ec2iface.EC2API) (*ec2.NatGateway, error) {
+	var gw *ec2.NatGateway
+	var err error
+
+	if ev.NatGatewayAWSID == "" {
+		gw, err = ev.batchNatGateway(svc)
+	} else {
+		gw, err = ev.liveNatGateway(svc)
+	}
+
+	if err != nil || gw == nil {
+		return nil, err
+	}
+
+	ev.NatGatewayAWSID = aws.StringValue(gw.NatGatewayId)
+
+	subnet := aws.StringValue(gw.SubnetId)
+	if subnet != ev.PublicNetworkAWSID {
+		msg := fmt.Sprintf("Nat gateway %s is on network %s instead of %s", ev.NatGatewayAWSID, subnet, ev.PublicNetworkAWSID)
+		if ev.StrictGatewaySubnet {
+			return nil, errors.New(msg)
+		}
+		ev.warn(msg)
+	}
+
+	for _, addr := range gw.NatGatewayAddresses {
+		if ev.NatGatewayAllocationID == "" {
+			ev.NatGatewayAllocationID = aws.StringValue(addr.AllocationId)
+			ev.NatGatewayAllocationIP = aws.StringValue(addr.PublicIp)
+		}
+		break
+	}
+
+	ev.NatGatewayCreateTime = timestamp(gw.CreateTime)
+
+	return gw, nil
+}
+
+// checkGatewaySubnet checks the network of a reused public nat gateway
+// routes its egress through an internet gateway, as a gateway on a private
+// network can't reach the internet. It warns, or errors when the event
+// asks for a strict gateway subnet.
+func (ev *Event) checkGatewaySubnet(svc ec2iface.EC2API, gw *ec2.NatGateway) error {
+	subnet := aws.StringValue(gw.SubnetId)
+
+	rt, err := ev.subnetRouteTable(svc, subnet)
+	if err != nil {
+		return err
+	}
+
+	if rt != nil {
+		route := defaultRouteOf(rt)
+		if route != nil && isInternetRoute(route) && aws.StringValue(route.State) != ec2.RouteStateBlackhole {
+			return nil
+		}
+	}
+
+	msg := fmt.Sprintf("Nat gateway %s is on network %s, which has no default route through an internet gateway", aws.StringValue(gw.NatGatewayId), subnet)
+	if ev.StrictGatewaySubnet {
+		return errors.New(msg)
+	}
+	ev.warn(msg)
+
+	return nil
+}
+
+// batchNatGateway returns the live nat gateway the event batch created on
+// the public network, if any
+func (ev *Event) batchNatGateway(svc ec2iface.EC2API) (*ec2.NatGateway, error) {
+	if ev.BatchID == "" || ev.PublicNetworkAWSID == "" {
+		return nil, nil
+	}
+
+	req := ec2.DescribeNatGatewaysInput{
+		Filter: filters(map[string][]string{
+			"subnet-id":          {ev.PublicNetworkAWSID},
+			"state":              {ec2.NatGatewayStatePending, ec2.NatGatewayStateAvailable},
+			"tag:" + batchTagKey: {ev.BatchID},
+		}),
+	}
+
+	resp, err := svc.DescribeNatGateways(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.NatGateways) == 0 {
+		return nil, nil
+	}
+
+	return resp.NatGateways[0], nil
+}
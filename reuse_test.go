@@ -0,0 +1,268 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCreateReusesNatGateway(t *testing.T) {
+	Convey("Given a create event for an existing nat gateway", t, func() {
+		log.SetOutput(ioutil.Discard)
+		f := newFakeEC2()
+		useFake(f)
+
+		gw := &ec2.NatGateway{
+			NatGatewayId: aws.String(testEvent.NatGatewayAWSID),
+			SubnetId:     aws.String(testEvent.PublicNetworkAWSID),
+			State:        aws.String(ec2.NatGatewayStateAvailable),
+			NatGatewayAddresses: []*ec2.NatGatewayAddress{
+				&ec2.NatGatewayAddress{AllocationId: aws.String("eipalloc-00000000"), PublicIp: aws.String("52.0.0.1")},
+			},
+		}
+		f.natGateways = append(f.natGateways, gw)
+		rt := publicRouteTable(f, false, &ec2.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), GatewayId: aws.String("igw-public")})
+		rt.Associations = append(rt.Associations, &ec2.RouteTableAssociation{SubnetId: aws.String("subnet-other")})
+
+		create := func(strict bool) (*Event, error) {
+			ev := testEvent
+			ev.StrictGatewaySubnet = strict
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+			return &e, e.Create()
+		}
+
+		Convey("When it is on the requested network", func() {
+			e, err := create(false)
+
+			Convey("It should reuse it without warnings", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 0)
+				So(f.Count("AllocateAddress"), ShouldEqual, 0)
+				So(e.NatGatewayAllocationID, ShouldEqual, "eipalloc-00000000")
+				So(e.Warnings, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When it is on another network", func() {
+			gw.SubnetId = aws.String("subnet-other")
+			e, err := create(false)
+
+			Convey("It should reuse it with a warning", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 0)
+				So(e.Warnings, ShouldResemble, []string{
+					"Nat gateway nat-00000000 is on network subnet-other instead of subnet-00000000",
+				})
+			})
+		})
+
+		Convey("When it is on another network and the subnet is strict", func() {
+			gw.SubnetId = aws.String("subnet-other")
+			_, err := create(true)
+
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Nat gateway nat-00000000 is on network subnet-other instead of subnet-00000000")
+				So(f.Count("CreateNatGateway"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When it was deleted", func() {
+			gw.State = aws.String(ec2.NatGatewayStateDeleted)
+			e, err := create(false)
+
+			Convey("It should create a new one", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 1)
+				So(e.NatGatewayAWSID, ShouldNotEqual, testEvent.NatGatewayAWSID)
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stdout)
+		})
+	})
+}
+
+func TestUpdateDeletedNatGateway(t *testing.T) {
+	Convey("Given an update event whose nat gateway was deleted", t, func() {
+		log.SetOutput(ioutil.Discard)
+		f := newFakeEC2()
+		useFake(f)
+		gw := withNatGateway(f)
+		gw.State = aws.String(ec2.NatGatewayStateDeleted)
+
+		update := func(recreate bool) (*Event, error) {
+			ev := testEvent
+			ev.RecreateOnUpdate = recreate
+			data, _ := json.Marshal(ev)
+			e := New("nat.update.aws", data)
+			e.Process()
+			return &e, e.Update()
+		}
+
+		Convey("When updating it", func() {
+			_, err := update(false)
+
+			Convey("It should fail without routing to it", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Nat gateway nat-00000000 no longer exists")
+				So(f.Count("CreateRoute"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When updating it and it never existed", func() {
+			f.natGateways = nil
+			_, err := update(false)
+
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Nat gateway nat-00000000 no longer exists")
+			})
+		})
+
+		Convey("When updating it recreating it", func() {
+			e, err := update(true)
+
+			Convey("It should route the networks to a new nat gateway", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 1)
+				So(e.NatGatewayAWSID, ShouldNotEqual, testEvent.NatGatewayAWSID)
+				So(aws.StringValue(defaultRouteOf(f.routeTables[len(f.routeTables)-1]).NatGatewayId), ShouldEqual, e.NatGatewayAWSID)
+				So(e.Warnings, ShouldResemble, []string{"Nat gateway nat-00000000 no longer exists, creating a new one"})
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stdout)
+		})
+	})
+}
+
+func TestCreateConverges(t *testing.T) {
+	Convey("Given a create event that already ran", t, func() {
+		ev := testEvent
+		ev.NatGatewayAWSID = ""
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		useFake(f)
+
+		first := New("nat.create.aws", data)
+		first.Process()
+		So(first.Create(), ShouldBeNil)
+
+		rerun := func(batch string) (Event, error) {
+			ev.BatchID = batch
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+			return e, e.Create()
+		}
+
+		Convey("When it runs again after its routes were removed", func() {
+			f.routeTables[0].Routes = nil
+			e, err := rerun(testEvent.BatchID)
+
+			Convey("It should only add the missing routes", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 1)
+				So(f.Count("AllocateAddress"), ShouldEqual, 1)
+				So(f.Count("CreateInternetGateway"), ShouldEqual, 1)
+				So(f.Count("CreateRouteTable"), ShouldEqual, 1)
+				So(e.NatGatewayAWSID, ShouldEqual, first.NatGatewayAWSID)
+				So(e.NatGatewayAllocationID, ShouldEqual, first.NatGatewayAllocationID)
+				So(len(e.RouteChanges), ShouldEqual, 1)
+				So(e.RouteChanges[0].Action, ShouldEqual, "added")
+			})
+		})
+
+		Convey("When it runs again unchanged", func() {
+			e, err := rerun(testEvent.BatchID)
+
+			Convey("It should change nothing", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 1)
+				So(f.Count("CreateRoute"), ShouldEqual, 1)
+				So(e.RouteChanges, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When another batch creates on the same network", func() {
+			e, err := rerun("other")
+
+			Convey("It should not reuse the nat gateway", func() {
+				So(f.Count("CreateNatGateway"), ShouldEqual, 2)
+				So(e.NatGatewayAWSID, ShouldNotEqual, first.NatGatewayAWSID)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Network subnet-00000001 default route already targets "+first.NatGatewayAWSID)
+			})
+		})
+	})
+}
+
+func TestReusedGatewaySubnet(t *testing.T) {
+	Convey("Given a create event for an existing nat gateway", t, func() {
+		log.SetOutput(ioutil.Discard)
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+
+		create := func(strict bool) (*Event, error) {
+			ev := testEvent
+			ev.StrictGatewaySubnet = strict
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+			return &e, e.Create()
+		}
+
+		Convey("When its network is public", func() {
+			publicRouteTable(f, false, &ec2.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), GatewayId: aws.String("igw-public")})
+			e, err := create(true)
+
+			Convey("It should reuse it without warnings", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 0)
+				So(e.Warnings, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When its network is private", func() {
+			e, err := create(false)
+
+			Convey("It should reuse it with a warning", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 0)
+				So(e.Warnings, ShouldResemble, []string{
+					"Nat gateway nat-00000000 is on network subnet-00000000, which has no default route through an internet gateway",
+				})
+			})
+		})
+
+		Convey("When its network is private and the subnet is strict", func() {
+			_, err := create(true)
+
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Nat gateway nat-00000000 is on network subnet-00000000, which has no default route through an internet gateway")
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stdout)
+		})
+	})
+}
@@ -0,0 +1,141 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// RollbackStep : how undoing a resource a failed create made went
+type RollbackStep struct {
+	Resource   string `json:"resource"`
+	ID         string `json:"id"`
+	RolledBack bool   `json:"rolled_back"`
+	Error      string `json:"error,omitempty"`
+}
+
+// createdResource is a resource the create being processed made, and how
+// to undo it
+type createdResource struct {
+	resource string
+	id       string
+	undo     func(svc ec2iface.EC2API) error
+}
+
+// track records a resource the create made, so it can be rolled back
+func (ev *Event) track(resource, id string, undo func(svc ec2iface.EC2API) error) {
+	ev.created = append(ev.created, createdResource{resource: resource, id: id, undo: undo})
+}
+
+// rollback undoes what a failed create made, latest first so nothing is
+// deleted while what was made after it still depends on it. Every
+// resource is attempted, as the ones left over are what the error reports
+// to clean up.
+func (ev *Event) rollback(svc ec2iface.EC2API) {
+	for i := len(ev.created) - 1; i >= 0; i-- {
+		c := ev.created[i]
+		step := RollbackStep{Resource: c.resource, ID: c.id, RolledBack: true}
+
+		if err := c.undo(svc); err != nil {
+			log.Printf("Could not roll back %s %s: %s", c.resource, c.id, err.Error())
+			step.RolledBack = false
+			step.Error = err.Error()
+		}
+
+		ev.Rollback = append(ev.Rollback, step)
+	}
+
+	ev.created = nil
+}
+
+// rollbackRoute deletes a default route the create added
+func rollbackRoute(rt string) func(svc ec2iface.EC2API) error {
+	return func(svc ec2iface.EC2API) error {
+		req := ec2.DeleteRouteInput{
+			RouteTableId:         aws.String(rt),
+			DestinationCidrBlock: aws.String(defaultRoute),
+		}
+
+		_, err := svc.DeleteRoute(&req)
+		if err != nil && !isAWSError(err, "InvalidRoute.NotFound") {
+			return err
+		}
+
+		return nil
+	}
+}
+
+// rollbackNatGateway deletes the nat gateway the create made, waiting for
+// it to be gone as its elastic ip can't be released before
+func (ev *Event) rollbackNatGateway(svc ec2iface.EC2API) error {
+	req := ec2.DeleteNatGatewayInput{
+		NatGatewayId: aws.String(ev.NatGatewayAWSID),
+	}
+
+	_, err := svc.DeleteNatGateway(&req)
+	if isAWSError(err, "NatGatewayNotFound") {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = ev.waitNatGatewayDeleted(svc, newBackoff(deletePollInterval, deletePollMaxInterval))
+	if err != nil {
+		return err
+	}
+
+	ev.NatGatewayAWSID = ""
+
+	return nil
+}
+
+// rollbackInternetGateway detaches and deletes the internet gateway the
+// create made
+func (ev *Event) rollbackInternetGateway(id string) func(svc ec2iface.EC2API) error {
+	return func(svc ec2iface.EC2API) error {
+		dreq := ec2.DetachInternetGatewayInput{
+			InternetGatewayId: aws.String(id),
+			VpcId:             aws.String(ev.VPCID),
+		}
+
+		_, err := svc.DetachInternetGateway(&dreq)
+		if err != nil && !isAWSError(err, "Gateway.NotAttached") {
+			return err
+		}
+
+		req := ec2.DeleteInternetGatewayInput{
+			InternetGatewayId: aws.String(id),
+		}
+
+		_, err = svc.DeleteInternetGateway(&req)
+		if err != nil && !isAWSError(err, "InvalidInternetGatewayID.NotFound") {
+			return err
+		}
+
+		ev.InternetGatewayID = ""
+		ev.InternetGatewayState = ""
+		ev.InternetGatewayCreated = nil
+
+		return nil
+	}
+}
+
+// rollbackAddress releases the elastic ip the create allocated
+func (ev *Event) rollbackAddress(svc ec2iface.EC2API) error {
+	err := ev.releaseAddress(svc)
+	if err != nil {
+		return err
+	}
+
+	ev.NatGatewayAllocationID = ""
+	ev.NatGatewayAllocationIP = ""
+
+	return nil
+}
@@ -0,0 +1,101 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCreateRollback(t *testing.T) {
+	deletePollInterval = time.Millisecond
+	deleteGracePeriod = 0
+
+	Convey("Given a create that fails once it created resources", t, func() {
+		f := newFakeEC2()
+		useFake(f)
+		f.errors["CreateRoute"] = awserr.New("RouteLimitExceeded", "The maximum number of routes has been reached.", nil)
+
+		create := func(rollback bool) (Event, error) {
+			ev := testEvent
+			ev.NatGatewayAWSID = ""
+			ev.RollbackOnFailure = rollback
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+			return e, e.Create()
+		}
+
+		resources := func(steps []RollbackStep) []string {
+			var names []string
+			for _, s := range steps {
+				names = append(names, s.Resource)
+			}
+			return names
+		}
+
+		Convey("When it is not asked to roll back", func() {
+			e, err := create(false)
+
+			Convey("It should leave the resources for a retry", func() {
+				So(err, ShouldNotBeNil)
+				So(e.Rollback, ShouldBeNil)
+				So(f.Count("DeleteNatGateway"), ShouldEqual, 0)
+				So(f.Count("ReleaseAddress"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When every resource can be rolled back", func() {
+			e, err := create(true)
+
+			Convey("It should undo them latest first and report it", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "RouteLimitExceeded")
+				So(resources(e.Rollback), ShouldResemble, []string{"route_table", "nat_gateway", "internet_gateway", "elastic_ip"})
+				for _, s := range e.Rollback {
+					So(s.ID, ShouldNotBeEmpty)
+					So(s.RolledBack, ShouldBeTrue)
+					So(s.Error, ShouldBeEmpty)
+				}
+				So(len(f.routeTables), ShouldEqual, 0)
+				So(len(f.internetGateways), ShouldEqual, 0)
+				So(aws.StringValue(f.natGateways[0].State), ShouldEqual, "deleted")
+				So(f.Count("ReleaseAddress"), ShouldEqual, 1)
+				So(e.NatGatewayAWSID, ShouldBeEmpty)
+				So(e.NatGatewayAllocationID, ShouldBeEmpty)
+			})
+
+			Convey("It should report the rollback with the error", func() {
+				payload, _ := json.Marshal(e)
+				So(string(payload), ShouldContainSubstring, `"rollback":[{"resource":"route_table"`)
+			})
+		})
+
+		Convey("When the elastic ip can't be released", func() {
+			f.errors["ReleaseAddress"] = awserr.New("AuthFailure", "You do not have permission to access the specified resource.", nil)
+			e, err := create(true)
+
+			Convey("It should report it is left over", func() {
+				So(err, ShouldNotBeNil)
+				So(len(e.Rollback), ShouldEqual, 4)
+				for _, s := range e.Rollback[:3] {
+					So(s.RolledBack, ShouldBeTrue)
+				}
+				eip := e.Rollback[3]
+				So(eip.Resource, ShouldEqual, "elastic_ip")
+				So(eip.RolledBack, ShouldBeFalse)
+				So(strings.HasPrefix(eip.Error, "AuthFailure"), ShouldBeTrue)
+				So(e.NatGatewayAllocationID, ShouldEqual, eip.ID)
+			})
+		})
+	})
+}
@@ -0,0 +1,664 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// defaultRoute is the destination of the routes managed by the connector
+const defaultRoute = "0.0.0.0/0"
+
+var (
+	// ErrPublicRouteTableNotFound ...
+	ErrPublicRouteTableNotFound = errors.New("Could not find the public network route table")
+	// ErrPublicRouteConflict ...
+	ErrPublicRouteConflict = errors.New("Public network default route does not target an internet gateway")
+	// ErrRouteConflictPolicyInvalid ...
+	ErrRouteConflictPolicyInvalid = errors.New("Route conflict policy must be fail, replace or skip")
+	// ErrNoRouteTablePolicyInvalid ...
+	ErrNoRouteTablePolicyInvalid = errors.New("No route table policy must be fail or create")
+	// ErrPublicRouteMainTable ...
+	ErrPublicRouteMainTable = errors.New("Public network has no internet route and uses the vpc main route table, associate it with its own route table")
+	// ErrRouteTargetInvalid ...
+	ErrRouteTargetInvalid = errors.New("Route target type must be nat_gateway, network_interface or instance, the last two with a route target id")
+	// ErrTaggedRouteTableNotFound ...
+	ErrTaggedRouteTableNotFound = errors.New("Could not find a route table with the given route table tag")
+	// ErrTaggedRouteTableAmbiguous ...
+	ErrTaggedRouteTableAmbiguous = errors.New("Several route tables have the given route table tag")
+)
+
+// Policies for routed networks whose default route targets something
+// other than the nat gateway
+const (
+	routeConflictFail    = "fail"
+	routeConflictReplace = "replace"
+	routeConflictSkip    = "skip"
+)
+
+// Policies for routed networks with neither an associated route table nor
+// a vpc main route table to fall back on
+const (
+	noRouteTableFail   = "fail"
+	noRouteTableCreate = "create"
+)
+
+// Types of resources the routed networks default routes can target
+const (
+	routeTargetNatGateway       = "nat_gateway"
+	routeTargetNetworkInterface = "network_interface"
+	routeTargetInstance         = "instance"
+)
+
+// routeTargetSpec describes what the routed networks default routes target
+type routeTargetSpec struct {
+	kind string
+	id   string
+}
+
+// natRouteTarget returns what the routed networks default routes target,
+// the nat gateway unless the event routes through a nat instance
+func (ev *Event) natRouteTarget() routeTargetSpec {
+	switch ev.RouteTargetType {
+	case routeTargetNetworkInterface, routeTargetInstance:
+		return routeTargetSpec{kind: ev.RouteTargetType, id: ev.RouteTargetID}
+	}
+
+	return routeTargetSpec{kind: routeTargetNatGateway, id: ev.NatGatewayAWSID}
+}
+
+// validateRouteTarget checks the route target type and id of the event
+func (ev *Event) validateRouteTarget() error {
+	switch ev.RouteTargetType {
+	case "", routeTargetNatGateway:
+		return nil
+	case routeTargetNetworkInterface, routeTargetInstance:
+		if ev.RouteTargetID != "" {
+			return nil
+		}
+	}
+
+	return ErrRouteTargetInvalid
+}
+
+// fields returns the route fields to set for the target, as nat gateway,
+// network interface and instance ids
+func (t routeTargetSpec) fields() (*string, *string, *string) {
+	switch t.kind {
+	case routeTargetNetworkInterface:
+		return nil, aws.String(t.id), nil
+	case routeTargetInstance:
+		return nil, nil, aws.String(t.id)
+	}
+
+	return aws.String(t.id), nil, nil
+}
+
+// matches checks if a route goes to the target
+func (t routeTargetSpec) matches(route *ec2.Route) bool {
+	switch t.kind {
+	case routeTargetNetworkInterface:
+		return aws.StringValue(route.NetworkInterfaceId) == t.id
+	case routeTargetInstance:
+		return aws.StringValue(route.InstanceId) == t.id
+	}
+
+	return aws.StringValue(route.NatGatewayId) == t.id
+}
+
+// Actions of the route changes reported on the event
+const (
+	routeAdded    = "added"
+	routeReplaced = "replaced"
+	routeRemoved  = "removed"
+)
+
+// RouteChange : a change made to the default route of a routed network
+type RouteChange struct {
+	NetworkAWSID   string `json:"network_aws_id"`
+	RouteTableID   string `json:"route_table_id"`
+	Action         string `json:"action"`
+	Target         string `json:"target,omitempty"`
+	PreviousTarget string `json:"previous_target,omitempty"`
+}
+
+// RouteCount : how many routed networks default routes an operation added
+// and skipped, as they already went through the nat gateway or kept their
+// own route under the skip route conflict policy
+type RouteCount struct {
+	Added   int `json:"added"`
+	Skipped int `json:"skipped"`
+}
+
+// routeTableTagKey is the tag identifying centrally managed route tables
+const routeTableTagKey = "ernest_route_table"
+
+const (
+	// internetRoutePresent reports the internet route already existed
+	internetRoutePresent = "present"
+	// internetRouteCreated reports the internet route was created
+	internetRouteCreated = "created"
+	// internetRouteMissing reports the public network has no internet route
+	internetRouteMissing = "missing"
+	// internetRouteBlackhole reports the internet route can't be used
+	internetRouteBlackhole = "blackhole"
+)
+
+// subnetRouteTable returns the route table used by a subnet, which is the
+// main route table of the vpc when the subnet has no explicit association
+func (ev *Event) subnetRouteTable(svc ec2iface.EC2API, subnet string) (*ec2.RouteTable, error) {
+	rt, err := ev.routingTableBySubnetID(svc, subnet)
+	if err != nil || rt != nil {
+		return rt, err
+	}
+
+	return ev.mainRouteTable(svc)
+}
+
+// mainRouteTable returns the main route table of the vpc, if it has one
+func (ev *Event) mainRouteTable(svc ec2iface.EC2API) (*ec2.RouteTable, error) {
+	req := ec2.DescribeRouteTablesInput{
+		Filters: filters(map[string][]string{
+			"vpc-id":           {ev.VPCID},
+			"association.main": {"true"},
+		}),
+	}
+
+	resp, err := svc.DescribeRouteTables(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.RouteTables) == 0 {
+		return nil, nil
+	}
+
+	return resp.RouteTables[0], nil
+}
+
+// taggedRouteTable returns the route table of the vpc tagged with the
+// event route table tag, associating the subnet with it when needed, so
+// every routed network shares a centrally managed table. The tag key can
+// be any the caller standardized on, but must only match a single table.
+func (ev *Event) taggedRouteTable(svc ec2iface.EC2API, subnet string) (*ec2.RouteTable, error) {
+	key := ev.RouteTableTagKey
+	if key == "" {
+		key = routeTableTagKey
+	}
+
+	req := ec2.DescribeRouteTablesInput{
+		Filters: filters(map[string][]string{
+			"vpc-id":     {ev.VPCID},
+			"tag:" + key: {ev.RouteTableTag},
+		}),
+	}
+
+	resp, err := svc.DescribeRouteTables(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(resp.RouteTables) {
+	case 0:
+		return nil, ErrTaggedRouteTableNotFound
+	case 1:
+	default:
+		return nil, ErrTaggedRouteTableAmbiguous
+	}
+
+	rt := resp.RouteTables[0]
+	ev.setRouteTable(subnet, rt)
+	ev.reportRouteTableSubnets(rt)
+
+	err = ev.tagOwnership(svc, rt.RouteTableId, rt.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := ev.routingTableBySubnetID(svc, subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	if current == nil {
+		_, err = svc.AssociateRouteTable(&ec2.AssociateRouteTableInput{
+			RouteTableId: rt.RouteTableId,
+			SubnetId:     aws.String(subnet),
+		})
+		return rt, err
+	}
+
+	if aws.StringValue(current.RouteTableId) == aws.StringValue(rt.RouteTableId) {
+		return rt, nil
+	}
+
+	for _, a := range current.Associations {
+		if aws.StringValue(a.SubnetId) != subnet {
+			continue
+		}
+
+		_, err = svc.ReplaceRouteTableAssociation(&ec2.ReplaceRouteTableAssociationInput{
+			AssociationId: a.RouteTableAssociationId,
+			RouteTableId:  rt.RouteTableId,
+		})
+		return rt, err
+	}
+
+	return rt, nil
+}
+
+// isMainRouteTable checks if a route table is the main one of its vpc
+func isMainRouteTable(rt *ec2.RouteTable) bool {
+	for _, a := range rt.Associations {
+		if aws.BoolValue(a.Main) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reportInternetRoute reports whether the public network routes its
+// egress through an internet gateway, which the nat gateway needs to
+// reach the internet
+func (ev *Event) reportInternetRoute(svc ec2iface.EC2API) error {
+	rt, err := ev.subnetRouteTable(svc, ev.PublicNetworkAWSID)
+	if err != nil {
+		return err
+	}
+
+	ev.InternetRoute = internetRouteMissing
+	if rt == nil {
+		return nil
+	}
+
+	route := defaultRouteOf(rt)
+	if route == nil || !isInternetRoute(route) {
+		return nil
+	}
+
+	ev.InternetRoute = internetRoutePresent
+	if aws.StringValue(route.State) == ec2.RouteStateBlackhole {
+		ev.InternetRoute = internetRouteBlackhole
+	}
+
+	return nil
+}
+
+// ensureInternetRoute makes sure the public network routes its egress
+// through the internet gateway, otherwise the nat gateway placed on it
+// can't reach the internet. The vpc main route table is never modified,
+// as that would route every subnet implicitly using it to the internet.
+func (ev *Event) ensureInternetRoute(svc ec2iface.EC2API) error {
+	rt, err := ev.subnetRouteTable(svc, ev.PublicNetworkAWSID)
+	if err != nil {
+		return err
+	}
+
+	if rt == nil {
+		return ErrPublicRouteTableNotFound
+	}
+
+	for _, route := range rt.Routes {
+		if aws.StringValue(route.DestinationCidrBlock) != defaultRoute {
+			continue
+		}
+
+		if isInternetRoute(route) {
+			ev.InternetRoute = internetRoutePresent
+			return nil
+		}
+
+		return ErrPublicRouteConflict
+	}
+
+	if isMainRouteTable(rt) {
+		return ErrPublicRouteMainTable
+	}
+
+	req := ec2.CreateRouteInput{
+		RouteTableId:         rt.RouteTableId,
+		DestinationCidrBlock: aws.String(defaultRoute),
+		GatewayId:            aws.String(ev.InternetGatewayID),
+	}
+
+	_, err = svc.CreateRoute(&req)
+	if err != nil {
+		return err
+	}
+
+	ev.InternetRoute = internetRouteCreated
+
+	return nil
+}
+
+// defaultRouteOf returns the default route of a route table, if any
+func defaultRouteOf(rt *ec2.RouteTable) *ec2.Route {
+	for _, route := range rt.Routes {
+		if aws.StringValue(route.DestinationCidrBlock) == defaultRoute {
+			return route
+		}
+	}
+
+	return nil
+}
+
+// planRoute decides the change a network default route needs to go
+// through the nat gateway, if any. When the network default route already
+// targets something else, the route conflict policy decides whether to
+// fail, replace it or skip it.
+func (ev *Event) planRoute(subnet string, rt *ec2.RouteTable) (*RouteChange, error) {
+	target := ev.natRouteTarget()
+	change := RouteChange{
+		NetworkAWSID: subnet,
+		RouteTableID: aws.StringValue(rt.RouteTableId),
+		Target:       target.id,
+	}
+
+	route := defaultRouteOf(rt)
+	if route == nil {
+		change.Action = routeAdded
+		return &change, nil
+	}
+
+	if target.matches(route) {
+		return nil, nil
+	}
+
+	switch ev.RouteConflictPolicy {
+	case routeConflictSkip:
+		return nil, nil
+	case routeConflictReplace:
+		change.Action = routeReplaced
+		change.PreviousTarget = routeTarget(route)
+		return &change, nil
+	}
+
+	return nil, fmt.Errorf("Network %s default route already targets %s", subnet, routeTarget(route))
+}
+
+// routeNetwork routes the egress of a network through the nat gateway,
+// reporting the change made to its default route and counting it as added
+// or skipped. The route count has to be set.
+func (ev *Event) routeNetwork(svc ec2iface.EC2API, subnet string) error {
+	rt, err := ev.createRouteTable(svc, subnet)
+	if err != nil {
+		return err
+	}
+
+	ev.warnShadowedRoutes(subnet, rt)
+	ev.warnPublicRoute(subnet, rt)
+
+	change, err := ev.planRoute(subnet, rt)
+	if err != nil {
+		return err
+	}
+
+	if change == nil {
+		ev.Routes.Skipped++
+		return nil
+	}
+
+	switch change.Action {
+	case routeAdded:
+		err = ev.createNatGatewayRoutes(svc, subnet, rt, ev.natRouteTarget())
+		// the route table is rediscovered when it disappeared meanwhile
+		change.RouteTableID = ev.RouteTables[subnet]
+	case routeReplaced:
+		req := ec2.ReplaceRouteInput{
+			RouteTableId:         rt.RouteTableId,
+			DestinationCidrBlock: aws.String(defaultRoute),
+		}
+		req.NatGatewayId, req.NetworkInterfaceId, req.InstanceId = ev.natRouteTarget().fields()
+		_, err = svc.ReplaceRoute(&req)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	ev.RouteChanges = append(ev.RouteChanges, *change)
+	if change.Action == routeAdded {
+		ev.Routes.Added++
+		ev.track("route", change.RouteTableID, rollbackRoute(change.RouteTableID))
+	}
+
+	return nil
+}
+
+// natGatewayNotNeeded explains why a nat gateway would be unused, when
+// every routed network already has its own default route to another
+// target and the route conflict policy keeps them. It returns no reason
+// when any network would route through the nat gateway.
+func (ev *Event) natGatewayNotNeeded(svc ec2iface.EC2API) (string, error) {
+	// tagged route tables may be shared with networks that need it
+	if ev.RouteConflictPolicy != routeConflictSkip || ev.RouteTableTag != "" {
+		return "", nil
+	}
+
+	target := ev.natRouteTarget()
+
+	var routes []string
+	for _, subnet := range ev.RoutedNetworkAWSIDs {
+		rt, err := ev.routingTableBySubnetID(svc, subnet)
+		if err != nil || rt == nil {
+			return "", err
+		}
+
+		route := defaultRouteOf(rt)
+		if route == nil || (target.id != "" && target.matches(route)) {
+			return "", nil
+		}
+
+		routes = append(routes, subnet+" through "+routeTarget(route))
+	}
+
+	if len(routes) == 0 {
+		return "", nil
+	}
+
+	return "No nat gateway needed, routed networks already have a default route: " + strings.Join(routes, ", "), nil
+}
+
+// verifyRoutes checks, when asked to, that the default route of every
+// routed network through the nat gateway is active. A blackhole route
+// means the gateway can't be reached. The networks routed by this event
+// must also still be associated with the route table they were routed on
+// and have the route, as associations can change concurrently.
+func (ev *Event) verifyRoutes(svc ec2iface.EC2API) error {
+	if !ev.VerifyRoutes {
+		return nil
+	}
+
+	target := ev.natRouteTarget()
+
+	changed := make(map[string]RouteChange)
+	for _, change := range ev.RouteChanges {
+		if change.Action == routeAdded || change.Action == routeReplaced {
+			changed[change.NetworkAWSID] = change
+		}
+	}
+
+	for _, subnet := range ev.RoutedNetworkAWSIDs {
+		rt, err := ev.routingTableBySubnetID(svc, subnet)
+		if err != nil {
+			return err
+		}
+
+		if change, ok := changed[subnet]; ok {
+			if rt == nil || aws.StringValue(rt.RouteTableId) != change.RouteTableID {
+				return fmt.Errorf("Network %s is no longer associated with route table %s", subnet, change.RouteTableID)
+			}
+
+			route := defaultRouteOf(rt)
+			if route == nil || !target.matches(route) {
+				return fmt.Errorf("Network %s route table %s has no default route through %s", subnet, change.RouteTableID, target.id)
+			}
+		}
+
+		if rt == nil {
+			continue
+		}
+
+		route := defaultRouteOf(rt)
+		if route == nil || !target.matches(route) {
+			continue
+		}
+
+		if aws.StringValue(route.State) == ec2.RouteStateBlackhole {
+			return fmt.Errorf("Network %s default route through %s is a blackhole", subnet, target.id)
+		}
+	}
+
+	return nil
+}
+
+// VPCRouteTable : a route table of the event vpc, as reported by get
+type VPCRouteTable struct {
+	RouteTableID       string   `json:"route_table_id"`
+	Main               bool     `json:"main,omitempty"`
+	Networks           []string `json:"networks,omitempty"`
+	RoutesToNatGateway bool     `json:"routes_to_nat_gateway"`
+}
+
+// routeTablesPageSize is how many route tables are described at once
+var routeTablesPageSize int64 = 100
+
+// vpcRouteTables lists every route table of the event vpc, with the
+// networks associated to it and whether any of its routes goes through
+// the nat gateway
+func (ev *Event) vpcRouteTables(svc ec2iface.EC2API) ([]VPCRouteTable, error) {
+	req := ec2.DescribeRouteTablesInput{
+		Filters: filters(map[string][]string{
+			"vpc-id": {ev.VPCID},
+		}),
+		MaxResults: aws.Int64(routeTablesPageSize),
+	}
+
+	tables := []VPCRouteTable{}
+
+	for {
+		resp, err := svc.DescribeRouteTables(&req)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rt := range resp.RouteTables {
+			table := VPCRouteTable{
+				RouteTableID: aws.StringValue(rt.RouteTableId),
+			}
+
+			for _, a := range rt.Associations {
+				if aws.BoolValue(a.Main) {
+					table.Main = true
+				}
+				if a.SubnetId != nil {
+					table.Networks = append(table.Networks, aws.StringValue(a.SubnetId))
+				}
+			}
+
+			for _, route := range rt.Routes {
+				if aws.StringValue(route.NatGatewayId) == ev.NatGatewayAWSID {
+					table.RoutesToNatGateway = true
+				}
+			}
+
+			tables = append(tables, table)
+		}
+
+		if aws.StringValue(resp.NextToken) == "" {
+			return tables, nil
+		}
+
+		req.NextToken = resp.NextToken
+	}
+}
+
+// warnShadowedRoutes warns about the blackhole routes of a network route
+// table, as aws routes the longest prefix first, so the traffic they match
+// is dropped instead of going through the nat gateway
+func (ev *Event) warnShadowedRoutes(subnet string, rt *ec2.RouteTable) {
+	for _, route := range rt.Routes {
+		if aws.StringValue(route.DestinationCidrBlock) == defaultRoute {
+			continue
+		}
+
+		if aws.StringValue(route.State) == ec2.RouteStateBlackhole {
+			ev.warn(fmt.Sprintf("Network %s route to %s is a blackhole and shadows the nat gateway route", subnet, routeDestination(route)))
+		}
+	}
+}
+
+// reportRouteTableSubnets reports the networks associated with a reused
+// route table, as the routes added to it apply to every one of them
+func (ev *Event) reportRouteTableSubnets(rt *ec2.RouteTable) {
+	subnets := []string{}
+	for _, a := range rt.Associations {
+		if id := aws.StringValue(a.SubnetId); id != "" {
+			subnets = append(subnets, id)
+		}
+	}
+	sort.Strings(subnets)
+
+	if ev.RouteTableSubnets == nil {
+		ev.RouteTableSubnets = make(map[string][]string)
+	}
+	ev.RouteTableSubnets[aws.StringValue(rt.RouteTableId)] = subnets
+}
+
+// warnPublicRoute warns when a routed network default route targets an
+// internet gateway, which makes the network public, so it is likely not
+// meant to be routed through the nat gateway
+func (ev *Event) warnPublicRoute(subnet string, rt *ec2.RouteTable) {
+	route := defaultRouteOf(rt)
+	if route != nil && isInternetRoute(route) {
+		ev.warn(fmt.Sprintf("Network %s default route targets internet gateway %s, so it is a public network", subnet, aws.StringValue(route.GatewayId)))
+	}
+}
+
+// isInternetRoute checks if a route targets an internet gateway
+func isInternetRoute(route *ec2.Route) bool {
+	return strings.HasPrefix(aws.StringValue(route.GatewayId), "igw-")
+}
+
+// routeDestination returns the destination of a route, a cidr block or a
+// prefix list
+func routeDestination(route *ec2.Route) string {
+	if route.DestinationCidrBlock != nil {
+		return aws.StringValue(route.DestinationCidrBlock)
+	}
+	if route.DestinationIpv6CidrBlock != nil {
+		return aws.StringValue(route.DestinationIpv6CidrBlock)
+	}
+
+	return aws.StringValue(route.DestinationPrefixListId)
+}
+
+// routeTarget returns the id of whatever a route targets
+func routeTarget(route *ec2.Route) string {
+	targets := []*string{
+		route.NatGatewayId,
+		route.GatewayId,
+		route.InstanceId,
+		route.NetworkInterfaceId,
+		route.TransitGatewayId,
+		route.VpcPeeringConnectionId,
+	}
+
+	for _, t := range targets {
+		if t != nil {
+			return *t
+		}
+	}
+
+	return "unknown"
+}
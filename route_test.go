@@ -0,0 +1,1222 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// publicRouteTable adds a route table for the test event's public network
+func publicRouteTable(f *fakeEC2, main bool, routes ...*ec2.Route) *ec2.RouteTable {
+	assoc := &ec2.RouteTableAssociation{Main: aws.Bool(main)}
+	if !main {
+		assoc.SubnetId = aws.String(testEvent.PublicNetworkAWSID)
+	}
+
+	rt := &ec2.RouteTable{
+		RouteTableId: aws.String("rtb-public"),
+		VpcId:        aws.String(testEvent.VPCID),
+		Associations: []*ec2.RouteTableAssociation{assoc},
+		Routes:       routes,
+	}
+	f.routeTables = append(f.routeTables, rt)
+
+	return rt
+}
+
+func internetRoutes(rt *ec2.RouteTable) int {
+	n := 0
+	for _, r := range rt.Routes {
+		if aws.StringValue(r.DestinationCidrBlock) == defaultRoute && r.GatewayId != nil {
+			n++
+		}
+	}
+	return n
+}
+
+func TestEnsureInternetRoute(t *testing.T) {
+	subject := "nat.create.aws"
+
+	Convey("Given a create event ensuring the internet route", t, func() {
+		ev := testEvent
+		ev.EnsureInternetRoute = true
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		useFake(f)
+
+		e := New(subject, data)
+		e.Process()
+
+		Convey("When the public network already routes to an internet gateway", func() {
+			rt := publicRouteTable(f, false, &ec2.Route{
+				DestinationCidrBlock: aws.String(defaultRoute),
+				GatewayId:            aws.String("igw-00000001"),
+			})
+			err := e.Create()
+
+			Convey("It should not add another route", func() {
+				So(err, ShouldBeNil)
+				So(e.InternetRoute, ShouldEqual, "present")
+				So(internetRoutes(rt), ShouldEqual, 1)
+				So(f.Count("CreateRoute"), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the public network has no internet route", func() {
+			rt := publicRouteTable(f, false)
+			err := e.Create()
+
+			Convey("It should route it through the internet gateway", func() {
+				So(err, ShouldBeNil)
+				So(internetRoutes(rt), ShouldEqual, 1)
+				So(*rt.Routes[0].GatewayId, ShouldEqual, e.InternetGatewayID)
+				So(e.InternetRoute, ShouldEqual, "created")
+			})
+		})
+
+		Convey("When the public network uses a vpc main route table without internet route", func() {
+			rt := publicRouteTable(f, true)
+			err := e.Create()
+
+			Convey("It should error without modifying the main table", func() {
+				So(err, ShouldEqual, ErrPublicRouteMainTable)
+				So(internetRoutes(rt), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the public network uses a vpc main route table with internet route", func() {
+			publicRouteTable(f, true, &ec2.Route{
+				DestinationCidrBlock: aws.String(defaultRoute),
+				GatewayId:            aws.String("igw-00000001"),
+			})
+			err := e.Create()
+
+			Convey("It should report the route as present", func() {
+				So(err, ShouldBeNil)
+				So(e.InternetRoute, ShouldEqual, "present")
+			})
+		})
+
+		Convey("When the public network default route targets something else", func() {
+			publicRouteTable(f, false, &ec2.Route{
+				DestinationCidrBlock: aws.String(defaultRoute),
+				GatewayId:            aws.String("vgw-00000001"),
+			})
+			err := e.Create()
+
+			Convey("It should error", func() {
+				So(err, ShouldEqual, ErrPublicRouteConflict)
+			})
+		})
+
+		Convey("When the public network route table can't be found", func() {
+			f.noMainRouteTable = true
+			err := e.Create()
+
+			Convey("It should error", func() {
+				So(err, ShouldEqual, ErrPublicRouteTableNotFound)
+			})
+		})
+	})
+
+	Convey("Given a create event not ensuring the internet route", t, func() {
+		data, _ := json.Marshal(testEvent)
+		f := newFakeEC2()
+		useFake(f)
+		rt := publicRouteTable(f, false)
+
+		Convey("When creating the event", func() {
+			e := New(subject, data)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should leave the public network routes untouched", func() {
+				So(err, ShouldBeNil)
+				So(internetRoutes(rt), ShouldEqual, 0)
+				So(e.InternetRoute, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestRouteTableDisappearing(t *testing.T) {
+	subject := "nat.update.aws"
+
+	Convey("Given an update event", t, func() {
+		data, _ := json.Marshal(testEvent)
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+
+		f.routeTables = append(f.routeTables, &ec2.RouteTable{
+			RouteTableId: aws.String("rtb-removed"),
+			VpcId:        aws.String(testEvent.VPCID),
+			Associations: []*ec2.RouteTableAssociation{
+				&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000001")},
+			},
+		})
+
+		e := New(subject, data)
+		e.Process()
+
+		Convey("When the route table is deleted before the route is created", func() {
+			f.hooks["CreateRoute"] = func(input interface{}) (interface{}, error) {
+				f.routeTables = nil
+				delete(f.hooks, "CreateRoute")
+				return nil, awserr.New("InvalidRouteTableID.NotFound", "The routeTable ID 'rtb-removed' does not exist", nil)
+			}
+			err := e.Update()
+
+			Convey("It should recreate the route table and retry the route", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateRoute"), ShouldEqual, 2)
+				So(f.Count("CreateRouteTable"), ShouldEqual, 1)
+				So(len(f.routeTables), ShouldEqual, 1)
+				So(*f.routeTables[0].RouteTableId, ShouldNotEqual, "rtb-removed")
+				So(*f.routeTables[0].Routes[0].NatGatewayId, ShouldEqual, testEvent.NatGatewayAWSID)
+			})
+		})
+
+		Convey("When the route table keeps disappearing", func() {
+			f.errors["CreateRoute"] = awserr.New("InvalidRouteTableID.NotFound", "not found", nil)
+			err := e.Update()
+
+			Convey("It should only retry once", func() {
+				So(err, ShouldNotBeNil)
+				So(f.Count("CreateRoute"), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When creating the route fails for another reason", func() {
+			f.errors["CreateRoute"] = awserr.New("RouteAlreadyExists", "exists", nil)
+			err := e.Update()
+
+			Convey("It should not retry", func() {
+				So(err, ShouldNotBeNil)
+				So(f.Count("CreateRoute"), ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestRouteTablesReport(t *testing.T) {
+	Convey("Given an event routing two networks", t, func() {
+		ev := testEvent
+		ev.RoutedNetworkAWSIDs = []string{"subnet-00000001", "subnet-00000002"}
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		useFake(f)
+
+		// subnet-00000001 already has a route table, subnet-00000002 has none
+		f.routeTables = append(f.routeTables, &ec2.RouteTable{
+			RouteTableId: aws.String("rtb-existing"),
+			VpcId:        aws.String(testEvent.VPCID),
+			Associations: []*ec2.RouteTableAssociation{
+				&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000001")},
+			},
+		})
+
+		Convey("When creating the event", func() {
+			e := New("nat.create.aws", data)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should report the route table of every routed network", func() {
+				So(err, ShouldBeNil)
+				So(len(e.RouteTables), ShouldEqual, 2)
+				So(e.RouteTables["subnet-00000001"], ShouldEqual, "rtb-existing")
+				So(e.RouteTables["subnet-00000002"], ShouldEqual, *f.routeTables[1].RouteTableId)
+
+				payload, _ := json.Marshal(e)
+				So(string(payload), ShouldContainSubstring, `"route_tables":{"subnet-00000001":"rtb-existing"`)
+			})
+		})
+
+		Convey("When updating the event and a reused table already routes through the nat gateway", func() {
+			withNatGateway(f)
+			f.routeTables[0].Routes = []*ec2.Route{
+				&ec2.Route{
+					DestinationCidrBlock: aws.String(defaultRoute),
+					NatGatewayId:         aws.String(testEvent.NatGatewayAWSID),
+				},
+			}
+
+			e := New("nat.update.aws", data)
+			e.Process()
+			err := e.Update()
+
+			Convey("It should still report the reused route table", func() {
+				So(err, ShouldBeNil)
+				So(e.RouteTables["subnet-00000001"], ShouldEqual, "rtb-existing")
+				So(e.RouteChanges, ShouldHaveLength, 1)
+				So(e.RouteChanges[0].NetworkAWSID, ShouldEqual, "subnet-00000002")
+			})
+		})
+	})
+}
+
+func TestRouteConflictPolicy(t *testing.T) {
+	Convey("Given an update event for a network routed elsewhere", t, func() {
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+		rt := &ec2.RouteTable{
+			RouteTableId: aws.String("rtb-00000001"),
+			VpcId:        aws.String(testEvent.VPCID),
+			Associations: []*ec2.RouteTableAssociation{
+				&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000001")},
+			},
+			Routes: []*ec2.Route{
+				&ec2.Route{DestinationCidrBlock: aws.String(defaultRoute), NatGatewayId: aws.String("nat-other")},
+			},
+		}
+		f.routeTables = append(f.routeTables, rt)
+
+		update := func(policy string) (*Event, error) {
+			ev := testEvent
+			ev.RouteConflictPolicy = policy
+			data, _ := json.Marshal(ev)
+			e := New("nat.update.aws", data)
+			e.Process()
+			if err := e.Validate(); err != nil {
+				return &e, err
+			}
+			return &e, e.Update()
+		}
+
+		Convey("When the policy is not set", func() {
+			_, err := update("")
+
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Network subnet-00000001 default route already targets nat-other")
+				So(*rt.Routes[0].NatGatewayId, ShouldEqual, "nat-other")
+			})
+		})
+
+		Convey("When the policy is fail", func() {
+			_, err := update("fail")
+
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+				So(f.Count("ReplaceRoute"), ShouldEqual, 0)
+				So(f.Count("CreateRoute"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the policy is replace", func() {
+			_, err := update("replace")
+
+			Convey("It should point the route to the nat gateway", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("ReplaceRoute"), ShouldEqual, 1)
+				So(*rt.Routes[0].NatGatewayId, ShouldEqual, testEvent.NatGatewayAWSID)
+			})
+		})
+
+		Convey("When the policy is skip", func() {
+			_, err := update("skip")
+
+			Convey("It should leave the route untouched", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("ReplaceRoute"), ShouldEqual, 0)
+				So(f.Count("CreateRoute"), ShouldEqual, 0)
+				So(*rt.Routes[0].NatGatewayId, ShouldEqual, "nat-other")
+			})
+		})
+
+		Convey("When the route already targets the nat gateway", func() {
+			rt.Routes[0].NatGatewayId = aws.String(testEvent.NatGatewayAWSID)
+			_, err := update("")
+
+			Convey("It should not change anything", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("ReplaceRoute"), ShouldEqual, 0)
+				So(f.Count("CreateRoute"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the policy is unknown", func() {
+			_, err := update("overwrite")
+
+			Convey("It should fail validation", func() {
+				So(err, ShouldEqual, ErrRouteConflictPolicyInvalid)
+			})
+		})
+	})
+}
+
+func TestTaggedRouteTable(t *testing.T) {
+	Convey("Given an update event using a tagged route table", t, func() {
+		ev := testEvent
+		ev.RouteTableTag = "private"
+		ev.RoutedNetworkAWSIDs = []string{"subnet-00000001", "subnet-00000002"}
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+
+		// subnet-00000002 is explicitly associated with another table
+		f.routeTables = append(f.routeTables, &ec2.RouteTable{
+			RouteTableId: aws.String("rtb-other"),
+			VpcId:        aws.String(testEvent.VPCID),
+			Associations: []*ec2.RouteTableAssociation{
+				&ec2.RouteTableAssociation{
+					RouteTableAssociationId: aws.String("rtbassoc-other"),
+					SubnetId:                aws.String("subnet-00000002"),
+				},
+			},
+		})
+
+		e := New("nat.update.aws", data)
+		e.Process()
+
+		Convey("When the tagged route table exists", func() {
+			rt := &ec2.RouteTable{
+				RouteTableId: aws.String("rtb-private"),
+				VpcId:        aws.String(testEvent.VPCID),
+				Tags: []*ec2.Tag{
+					&ec2.Tag{Key: aws.String(routeTableTagKey), Value: aws.String("private")},
+				},
+			}
+			f.routeTables = append(f.routeTables, rt)
+			err := e.Update()
+
+			Convey("It should route every network through it", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateRouteTable"), ShouldEqual, 0)
+				So(f.Count("AssociateRouteTable"), ShouldEqual, 1)
+				So(f.Count("ReplaceRouteTableAssociation"), ShouldEqual, 1)
+				So(len(rt.Associations), ShouldEqual, 2)
+				So(e.RouteTables, ShouldResemble, map[string]string{
+					"subnet-00000001": "rtb-private",
+					"subnet-00000002": "rtb-private",
+				})
+				So(len(rt.Routes), ShouldEqual, 1)
+				So(*rt.Routes[0].NatGatewayId, ShouldEqual, testEvent.NatGatewayAWSID)
+			})
+		})
+
+		Convey("When no route table has the tag", func() {
+			err := e.Update()
+
+			Convey("It should fail without creating a route table", func() {
+				So(err, ShouldEqual, ErrTaggedRouteTableNotFound)
+				So(f.Count("CreateRouteTable"), ShouldEqual, 0)
+				So(f.Count("CreateRoute"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When several route tables have the tag", func() {
+			for _, id := range []string{"rtb-private-a", "rtb-private-b"} {
+				f.routeTables = append(f.routeTables, &ec2.RouteTable{
+					RouteTableId: aws.String(id),
+					VpcId:        aws.String(testEvent.VPCID),
+					Tags: []*ec2.Tag{
+						&ec2.Tag{Key: aws.String(routeTableTagKey), Value: aws.String("private")},
+					},
+				})
+			}
+			err := e.Update()
+
+			Convey("It should fail without routing anything", func() {
+				So(err, ShouldEqual, ErrTaggedRouteTableAmbiguous)
+				So(f.Count("AssociateRouteTable"), ShouldEqual, 0)
+				So(f.Count("CreateRoute"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the route table is tagged with a custom tag key", func() {
+			rt := &ec2.RouteTable{
+				RouteTableId: aws.String("rtb-team"),
+				VpcId:        aws.String(testEvent.VPCID),
+				Tags: []*ec2.Tag{
+					&ec2.Tag{Key: aws.String("team/routing"), Value: aws.String("private")},
+				},
+			}
+			f.routeTables = append(f.routeTables, rt)
+			e.RouteTableTagKey = "team/routing"
+			err := e.Update()
+
+			Convey("It should route every network through it", func() {
+				So(err, ShouldBeNil)
+				So(e.RouteTables, ShouldResemble, map[string]string{
+					"subnet-00000001": "rtb-team",
+					"subnet-00000002": "rtb-team",
+				})
+				So(len(rt.Routes), ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestRouteChanges(t *testing.T) {
+	Convey("Given an update event routing two networks", t, func() {
+		ev := testEvent
+		ev.RoutedNetworkAWSIDs = []string{"subnet-00000001", "subnet-00000002", "subnet-00000003"}
+		ev.RouteConflictPolicy = "replace"
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+
+		// subnet-00000001 routes elsewhere, subnet-00000002 has no default
+		// route and subnet-00000003 already routes to the nat gateway
+		f.routeTables = append(f.routeTables,
+			&ec2.RouteTable{
+				RouteTableId: aws.String("rtb-00000001"),
+				VpcId:        aws.String(testEvent.VPCID),
+				Associations: []*ec2.RouteTableAssociation{
+					&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000001")},
+				},
+				Routes: []*ec2.Route{
+					&ec2.Route{DestinationCidrBlock: aws.String(defaultRoute), NatGatewayId: aws.String("nat-other")},
+				},
+			},
+			&ec2.RouteTable{
+				RouteTableId: aws.String("rtb-00000002"),
+				VpcId:        aws.String(testEvent.VPCID),
+				Associations: []*ec2.RouteTableAssociation{
+					&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000002")},
+				},
+			},
+			&ec2.RouteTable{
+				RouteTableId: aws.String("rtb-00000003"),
+				VpcId:        aws.String(testEvent.VPCID),
+				Associations: []*ec2.RouteTableAssociation{
+					&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000003")},
+				},
+				Routes: []*ec2.Route{
+					&ec2.Route{DestinationCidrBlock: aws.String(defaultRoute), NatGatewayId: aws.String(testEvent.NatGatewayAWSID)},
+				},
+			},
+		)
+
+		Convey("When updating it", func() {
+			e := New("nat.update.aws", data)
+			e.Process()
+			err := e.Execute("update")
+
+			Convey("It should report the changes applied", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("ReplaceRoute"), ShouldEqual, 1)
+				So(f.Count("CreateRoute"), ShouldEqual, 1)
+				So(e.RouteChanges, ShouldResemble, []RouteChange{
+					{NetworkAWSID: "subnet-00000001", RouteTableID: "rtb-00000001", Action: "replaced", Target: testEvent.NatGatewayAWSID, PreviousTarget: "nat-other"},
+					{NetworkAWSID: "subnet-00000002", RouteTableID: "rtb-00000002", Action: "added", Target: testEvent.NatGatewayAWSID},
+				})
+			})
+
+			Convey("It should count the routes added and skipped", func() {
+				So(*e.Routes, ShouldResemble, RouteCount{Added: 1, Skipped: 1})
+
+				payload, _ := json.Marshal(e)
+				So(string(payload), ShouldContainSubstring, `"routes":{"added":1,"skipped":1}`)
+			})
+
+			Convey("It should report nothing when updated again", func() {
+				payload, _ := json.Marshal(e)
+				again := New("nat.update.aws", payload)
+				again.Process()
+				So(again.Execute("update"), ShouldBeNil)
+				So(again.RouteChanges, ShouldBeEmpty)
+				So(*again.Routes, ShouldResemble, RouteCount{Added: 0, Skipped: 3})
+			})
+		})
+	})
+}
+
+func TestRouteTargetTypes(t *testing.T) {
+	Convey("Given an update event routing a network", t, func() {
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+
+		update := func(kind, id string) (*Event, error) {
+			ev := testEvent
+			ev.RouteTargetType = kind
+			ev.RouteTargetID = id
+			data, _ := json.Marshal(ev)
+			e := New("nat.update.aws", data)
+			e.Process()
+			if err := e.Validate(); err != nil {
+				return &e, err
+			}
+			return &e, e.Update()
+		}
+
+		route := func() *ec2.Route {
+			return defaultRouteOf(f.routeTables[0])
+		}
+
+		Convey("When the target is the nat gateway", func() {
+			_, err := update("nat_gateway", "")
+
+			Convey("It should route to the nat gateway", func() {
+				So(err, ShouldBeNil)
+				So(aws.StringValue(route().NatGatewayId), ShouldEqual, testEvent.NatGatewayAWSID)
+				So(route().NetworkInterfaceId, ShouldBeNil)
+			})
+		})
+
+		Convey("When the target is a network interface", func() {
+			e, err := update("network_interface", "eni-00000001")
+
+			Convey("It should route to the network interface", func() {
+				So(err, ShouldBeNil)
+				So(aws.StringValue(route().NetworkInterfaceId), ShouldEqual, "eni-00000001")
+				So(route().NatGatewayId, ShouldBeNil)
+				So(e.RouteChanges[0].Target, ShouldEqual, "eni-00000001")
+			})
+		})
+
+		Convey("When the target is an instance", func() {
+			_, err := update("instance", "i-00000001")
+
+			Convey("It should route to the instance", func() {
+				So(err, ShouldBeNil)
+				So(aws.StringValue(route().InstanceId), ShouldEqual, "i-00000001")
+				So(route().NatGatewayId, ShouldBeNil)
+			})
+		})
+
+		Convey("When the network already routes to the instance", func() {
+			update("instance", "i-00000001")
+			_, err := update("instance", "i-00000001")
+
+			Convey("It should not change anything", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateRoute"), ShouldEqual, 1)
+				So(f.Count("ReplaceRoute"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the target has no id", func() {
+			_, err := update("network_interface", "")
+
+			Convey("It should fail validation", func() {
+				So(err, ShouldEqual, ErrRouteTargetInvalid)
+			})
+		})
+
+		Convey("When the target type is unknown", func() {
+			_, err := update("vpn_gateway", "vgw-00000001")
+
+			Convey("It should fail validation", func() {
+				So(err, ShouldEqual, ErrRouteTargetInvalid)
+			})
+		})
+	})
+}
+
+func TestVerifyRoutes(t *testing.T) {
+	Convey("Given an event verifying its routes", t, func() {
+		ev := testEvent
+		ev.VerifyRoutes = true
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		useFake(f)
+
+		Convey("When creating the event and the routes are active", func() {
+			e := New("nat.create.aws", data)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should succeed", func() {
+				So(err, ShouldBeNil)
+				So(len(e.RouteChanges), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When updating the event and a route through the nat gateway is a blackhole", func() {
+			withNatGateway(f)
+			f.routeTables = append(f.routeTables, &ec2.RouteTable{
+				RouteTableId: aws.String("rtb-existing"),
+				VpcId:        aws.String(testEvent.VPCID),
+				Associations: []*ec2.RouteTableAssociation{
+					&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000001")},
+				},
+				Routes: []*ec2.Route{
+					&ec2.Route{
+						DestinationCidrBlock: aws.String(defaultRoute),
+						NatGatewayId:         aws.String(testEvent.NatGatewayAWSID),
+						State:                aws.String(ec2.RouteStateBlackhole),
+					},
+				},
+			})
+
+			e := New("nat.update.aws", data)
+			e.Process()
+			err := e.Update()
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Network subnet-00000001 default route through nat-00000000 is a blackhole")
+			})
+		})
+	})
+}
+
+func TestVPCRouteTables(t *testing.T) {
+	Convey("Given a get event listing the vpc route tables", t, func() {
+		ev := testEvent
+		ev.ListRouteTables = true
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+
+		natRoute := &ec2.Route{
+			DestinationCidrBlock: aws.String(defaultRoute),
+			NatGatewayId:         aws.String(testEvent.NatGatewayAWSID),
+		}
+		f.routeTables = append(f.routeTables,
+			&ec2.RouteTable{
+				RouteTableId: aws.String("rtb-main"),
+				VpcId:        aws.String(testEvent.VPCID),
+				Associations: []*ec2.RouteTableAssociation{
+					&ec2.RouteTableAssociation{Main: aws.Bool(true)},
+				},
+			},
+			&ec2.RouteTable{
+				RouteTableId: aws.String("rtb-routed"),
+				VpcId:        aws.String(testEvent.VPCID),
+				Associations: []*ec2.RouteTableAssociation{
+					&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000001")},
+					&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000002")},
+				},
+				Routes: []*ec2.Route{natRoute},
+			},
+			&ec2.RouteTable{
+				RouteTableId: aws.String("rtb-other-vpc"),
+				VpcId:        aws.String("vpc-other"),
+				Routes:       []*ec2.Route{natRoute},
+			},
+		)
+
+		expected := []VPCRouteTable{
+			VPCRouteTable{RouteTableID: "rtb-main", Main: true},
+			VPCRouteTable{RouteTableID: "rtb-routed", Networks: []string{"subnet-00000001", "subnet-00000002"}, RoutesToNatGateway: true},
+		}
+
+		Convey("When getting the event", func() {
+			e := New("nat.get.aws", data)
+			e.Process()
+			err := e.Get()
+
+			Convey("It should report every route table of the vpc", func() {
+				So(err, ShouldBeNil)
+				So(e.VPCRouteTables, ShouldResemble, expected)
+			})
+		})
+
+		Convey("When the route tables span several pages", func() {
+			var tokens []string
+			f.hooks["DescribeRouteTables"] = func(in interface{}) (interface{}, error) {
+				req := in.(*ec2.DescribeRouteTablesInput)
+				// the public network route table lookups aren't paginated
+				if req.MaxResults == nil {
+					return &ec2.DescribeRouteTablesOutput{}, nil
+				}
+				tokens = append(tokens, aws.StringValue(req.NextToken))
+				if req.NextToken == nil {
+					return &ec2.DescribeRouteTablesOutput{
+						RouteTables: f.routeTables[:1],
+						NextToken:   aws.String("page-2"),
+					}, nil
+				}
+				return &ec2.DescribeRouteTablesOutput{RouteTables: f.routeTables[1:2]}, nil
+			}
+
+			e := New("nat.get.aws", data)
+			e.Process()
+			err := e.Get()
+
+			Convey("It should report the route tables of every page", func() {
+				So(err, ShouldBeNil)
+				So(tokens, ShouldResemble, []string{"", "page-2"})
+				So(e.VPCRouteTables, ShouldResemble, expected)
+			})
+		})
+
+		Convey("When getting the event without asking for them", func() {
+			ev.ListRouteTables = false
+			data, _ := json.Marshal(ev)
+			e := New("nat.get.aws", data)
+			e.Process()
+			err := e.Get()
+
+			Convey("It should not list the route tables", func() {
+				So(err, ShouldBeNil)
+				So(e.VPCRouteTables, ShouldBeNil)
+				// only the public network route table is looked up
+				So(f.Count("DescribeRouteTables"), ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func TestShadowedRoutes(t *testing.T) {
+	Convey("Given a routed network with a more specific blackhole route", t, func() {
+		data, _ := json.Marshal(testEvent)
+		f := newFakeEC2()
+		useFake(f)
+
+		f.routeTables = append(f.routeTables, &ec2.RouteTable{
+			RouteTableId: aws.String("rtb-existing"),
+			VpcId:        aws.String(testEvent.VPCID),
+			Associations: []*ec2.RouteTableAssociation{
+				&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000001")},
+			},
+			Routes: []*ec2.Route{
+				&ec2.Route{
+					DestinationCidrBlock: aws.String("10.0.0.0/16"),
+					GatewayId:            aws.String("local"),
+					State:                aws.String(ec2.RouteStateActive),
+				},
+				&ec2.Route{
+					DestinationCidrBlock: aws.String("52.0.0.0/8"),
+					NatGatewayId:         aws.String("nat-deleted"),
+					State:                aws.String(ec2.RouteStateBlackhole),
+				},
+			},
+		})
+
+		Convey("When creating the event", func() {
+			e := New("nat.create.aws", data)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should route the network and warn about the shadowing route", func() {
+				So(err, ShouldBeNil)
+				So(len(e.RouteChanges), ShouldEqual, 1)
+				So(e.Warnings, ShouldContain, "Network subnet-00000001 route to 52.0.0.0/8 is a blackhole and shadows the nat gateway route")
+				So(e.Warnings, ShouldHaveLength, 1)
+			})
+		})
+	})
+}
+
+func TestPublicRoutedNetwork(t *testing.T) {
+	Convey("Given a routed network whose default route targets an internet gateway", t, func() {
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+
+		f.routeTables = append(f.routeTables, &ec2.RouteTable{
+			RouteTableId: aws.String("rtb-existing"),
+			VpcId:        aws.String(testEvent.VPCID),
+			Associations: []*ec2.RouteTableAssociation{
+				&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000001")},
+			},
+			Routes: []*ec2.Route{
+				&ec2.Route{
+					DestinationCidrBlock: aws.String(defaultRoute),
+					GatewayId:            aws.String("igw-00000001"),
+					State:                aws.String(ec2.RouteStateActive),
+				},
+			},
+		})
+
+		update := func(policy string) (Event, error) {
+			ev := testEvent
+			ev.RouteConflictPolicy = policy
+			data, _ := json.Marshal(ev)
+			e := New("nat.update.aws", data)
+			e.Process()
+			return e, e.Update()
+		}
+
+		Convey("When replacing its default route", func() {
+			e, err := update("replace")
+
+			Convey("It should route it through the nat gateway and warn it was public", func() {
+				So(err, ShouldBeNil)
+				So(len(e.RouteChanges), ShouldEqual, 1)
+				So(e.RouteChanges[0].PreviousTarget, ShouldEqual, "igw-00000001")
+				So(e.Warnings, ShouldResemble, []string{"Network subnet-00000001 default route targets internet gateway igw-00000001, so it is a public network"})
+			})
+		})
+
+		Convey("When its default route targets a nat gateway instead", func() {
+			f.routeTables[0].Routes[0].GatewayId = nil
+			f.routeTables[0].Routes[0].NatGatewayId = aws.String(testEvent.NatGatewayAWSID)
+			e, err := update("")
+
+			Convey("It should not warn", func() {
+				So(err, ShouldBeNil)
+				So(e.Warnings, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestNatGatewayNotNeeded(t *testing.T) {
+	Convey("Given a create event keeping the existing default routes", t, func() {
+		ev := testEvent
+		ev.NatGatewayAWSID = ""
+		ev.RouteConflictPolicy = "skip"
+		ev.RoutedNetworkAWSIDs = []string{"subnet-00000001", "subnet-00000002"}
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		useFake(f)
+
+		routed := func(subnet, target string) *ec2.RouteTable {
+			return &ec2.RouteTable{
+				RouteTableId: aws.String("rtb-" + subnet),
+				VpcId:        aws.String(testEvent.VPCID),
+				Associations: []*ec2.RouteTableAssociation{
+					&ec2.RouteTableAssociation{SubnetId: aws.String(subnet)},
+				},
+				Routes: []*ec2.Route{
+					&ec2.Route{
+						DestinationCidrBlock: aws.String(defaultRoute),
+						TransitGatewayId:     aws.String(target),
+					},
+				},
+			}
+		}
+
+		Convey("When every routed network already routes its egress elsewhere", func() {
+			f.routeTables = append(f.routeTables,
+				routed("subnet-00000001", "tgw-00000001"),
+				routed("subnet-00000002", "tgw-00000001"),
+			)
+			e := New("nat.create.aws", data)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should not create a nat gateway and explain why", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("AllocateAddress"), ShouldEqual, 0)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 0)
+				So(e.NoOpReason, ShouldEqual, "No nat gateway needed, routed networks already have a default route: subnet-00000001 through tgw-00000001, subnet-00000002 through tgw-00000001")
+			})
+		})
+
+		Convey("When a routed network has no default route", func() {
+			f.routeTables = append(f.routeTables, routed("subnet-00000001", "tgw-00000001"))
+			e := New("nat.create.aws", data)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should create the nat gateway", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 1)
+				So(e.NoOpReason, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When existing default routes are replaced", func() {
+			f.routeTables = append(f.routeTables,
+				routed("subnet-00000001", "tgw-00000001"),
+				routed("subnet-00000002", "tgw-00000001"),
+			)
+			ev.RouteConflictPolicy = "replace"
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should create the nat gateway", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 1)
+				So(e.NoOpReason, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestReportInternetRoute(t *testing.T) {
+	Convey("Given a get event", t, func() {
+		data, _ := json.Marshal(testEvent)
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+
+		public := &ec2.RouteTable{
+			RouteTableId: aws.String("rtb-public"),
+			VpcId:        aws.String(testEvent.VPCID),
+			Associations: []*ec2.RouteTableAssociation{
+				&ec2.RouteTableAssociation{SubnetId: aws.String(testEvent.PublicNetworkAWSID)},
+			},
+		}
+		f.routeTables = append(f.routeTables, public)
+
+		get := func() (Event, error) {
+			e := New("nat.get.aws", data)
+			e.Process()
+			return e, e.Get()
+		}
+
+		Convey("When the public network routes through an internet gateway", func() {
+			public.Routes = []*ec2.Route{
+				&ec2.Route{
+					DestinationCidrBlock: aws.String(defaultRoute),
+					GatewayId:            aws.String("igw-00000000"),
+					State:                aws.String(ec2.RouteStateActive),
+				},
+			}
+			e, err := get()
+
+			Convey("It should report the internet route as present", func() {
+				So(err, ShouldBeNil)
+				So(e.InternetRoute, ShouldEqual, "present")
+			})
+		})
+
+		Convey("When the internet gateway route is a blackhole", func() {
+			public.Routes = []*ec2.Route{
+				&ec2.Route{
+					DestinationCidrBlock: aws.String(defaultRoute),
+					GatewayId:            aws.String("igw-deleted"),
+					State:                aws.String(ec2.RouteStateBlackhole),
+				},
+			}
+			e, err := get()
+
+			Convey("It should report it", func() {
+				So(err, ShouldBeNil)
+				So(e.InternetRoute, ShouldEqual, "blackhole")
+			})
+		})
+
+		Convey("When the public network has no internet route", func() {
+			e, err := get()
+
+			Convey("It should report the internet route as missing", func() {
+				So(err, ShouldBeNil)
+				So(e.InternetRoute, ShouldEqual, "missing")
+			})
+		})
+
+		Convey("When the nat gateway is private", func() {
+			f.natGateways[0].ConnectivityType = aws.String(ec2.ConnectivityTypePrivate)
+			e, err := get()
+
+			Convey("It should not report an internet route", func() {
+				So(err, ShouldBeNil)
+				So(e.InternetRoute, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestNoRouteTablePolicy(t *testing.T) {
+	Convey("Given a vpc with neither a routed network nor a main route table", t, func() {
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+		f.noMainRouteTable = true
+
+		update := func(policy string) (Event, error) {
+			ev := testEvent
+			ev.NoRouteTablePolicy = policy
+			data, _ := json.Marshal(ev)
+			e := New("nat.update.aws", data)
+			e.Process()
+			if err := e.Validate(); err != nil {
+				return e, err
+			}
+			return e, e.Update()
+		}
+
+		Convey("When updating with the default policy", func() {
+			_, err := update("")
+
+			Convey("It should error without creating a route table", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Network subnet-00000001 has no associated route table and vpc "+testEvent.VPCID+" has no main route table")
+				So(f.Count("CreateRouteTable"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When updating with the create policy", func() {
+			e, err := update("create")
+
+			Convey("It should create and associate a route table", func() {
+				So(err, ShouldBeNil)
+				So(f.Count("CreateRouteTable"), ShouldEqual, 1)
+				So(f.Count("AssociateRouteTable"), ShouldEqual, 1)
+				So(e.RouteTables["subnet-00000001"], ShouldEqual, *f.routeTables[0].RouteTableId)
+			})
+		})
+
+		Convey("When the policy is unknown", func() {
+			_, err := update("ignore")
+
+			Convey("It should not validate", func() {
+				So(err, ShouldEqual, ErrNoRouteTablePolicyInvalid)
+			})
+		})
+	})
+}
+
+func TestVerifyRouteAssociations(t *testing.T) {
+	Convey("Given an update event verifying its routes", t, func() {
+		ev := testEvent
+		ev.VerifyRoutes = true
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+
+		rt := &ec2.RouteTable{
+			RouteTableId: aws.String("rtb-existing"),
+			VpcId:        aws.String(testEvent.VPCID),
+			Associations: []*ec2.RouteTableAssociation{
+				&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000001")},
+			},
+		}
+		f.routeTables = append(f.routeTables, rt)
+
+		update := func() (Event, error) {
+			e := New("nat.update.aws", data)
+			e.Process()
+			return e, e.Update()
+		}
+
+		Convey("When the network is still associated with the route table", func() {
+			e, err := update()
+
+			Convey("It should succeed", func() {
+				So(err, ShouldBeNil)
+				So(len(e.RouteChanges), ShouldEqual, 1)
+				So(e.RouteChanges[0].RouteTableID, ShouldEqual, "rtb-existing")
+			})
+		})
+
+		Convey("When the network is associated with another route table meanwhile", func() {
+			f.hooks["CreateRoute"] = func(input interface{}) (interface{}, error) {
+				f.mu.Lock()
+				defer f.mu.Unlock()
+				f.routeTables = append(f.routeTables, &ec2.RouteTable{
+					RouteTableId: aws.String("rtb-moved"),
+					VpcId:        aws.String(testEvent.VPCID),
+					Associations: rt.Associations,
+				})
+				rt.Associations = nil
+				return &ec2.CreateRouteOutput{Return: aws.Bool(true)}, nil
+			}
+			_, err := update()
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Network subnet-00000001 is no longer associated with route table rtb-existing")
+			})
+		})
+
+		Convey("When the route is gone from the route table", func() {
+			f.hooks["CreateRoute"] = func(input interface{}) (interface{}, error) {
+				return &ec2.CreateRouteOutput{Return: aws.Bool(true)}, nil
+			}
+			_, err := update()
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Network subnet-00000001 route table rtb-existing has no default route through "+testEvent.NatGatewayAWSID)
+			})
+		})
+	})
+}
+
+func TestRouteTableMatches(t *testing.T) {
+	Convey("Given a routed network route table lookup", t, func() {
+		f := newFakeEC2()
+		associated := func(id string) *ec2.RouteTable {
+			return &ec2.RouteTable{
+				RouteTableId: aws.String(id),
+				VpcId:        aws.String(testEvent.VPCID),
+				Associations: []*ec2.RouteTableAssociation{
+					&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000001")},
+				},
+			}
+		}
+
+		Convey("When a single route table matches", func() {
+			f.routeTables = append(f.routeTables, associated("rtb-b"))
+
+			Convey("It should return it", func() {
+				e := testEvent
+				rt, err := e.routingTableBySubnetID(f, "subnet-00000001")
+				So(err, ShouldBeNil)
+				So(aws.StringValue(rt.RouteTableId), ShouldEqual, "rtb-b")
+			})
+
+			Convey("It should return it in strict mode", func() {
+				e := testEvent
+				e.StrictRouteTables = true
+				rt, err := e.routingTableBySubnetID(f, "subnet-00000001")
+				So(err, ShouldBeNil)
+				So(aws.StringValue(rt.RouteTableId), ShouldEqual, "rtb-b")
+			})
+		})
+
+		Convey("When several route tables match", func() {
+			f.routeTables = append(f.routeTables, associated("rtb-b"), associated("rtb-a"))
+
+			Convey("It should always choose the same one", func() {
+				e := testEvent
+				rt, err := e.routingTableBySubnetID(f, "subnet-00000001")
+				So(err, ShouldBeNil)
+				So(aws.StringValue(rt.RouteTableId), ShouldEqual, "rtb-a")
+			})
+
+			Convey("It should error in strict mode", func() {
+				e := testEvent
+				e.StrictRouteTables = true
+				_, err := e.routingTableBySubnetID(f, "subnet-00000001")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Network subnet-00000001 matches more than one route table: rtb-a, rtb-b")
+			})
+		})
+	})
+}
+
+func TestRouteTableSubnets(t *testing.T) {
+	Convey("Given an update event routing networks", t, func() {
+		ev := testEvent
+		ev.RoutedNetworkAWSIDs = []string{"subnet-00000001", "subnet-00000002"}
+		data, _ := json.Marshal(ev)
+		f := newFakeEC2()
+		useFake(f)
+		withNatGateway(f)
+
+		// subnet-00000001 shares its route table with other networks and
+		// subnet-00000002 has none
+		f.routeTables = append(f.routeTables, &ec2.RouteTable{
+			RouteTableId: aws.String("rtb-00000001"),
+			VpcId:        aws.String(testEvent.VPCID),
+			Associations: []*ec2.RouteTableAssociation{
+				&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000009")},
+				&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000001")},
+				&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000005")},
+			},
+		})
+
+		Convey("When updating it", func() {
+			e := New("nat.update.aws", data)
+			e.Process()
+			err := e.Execute("update")
+
+			Convey("It should report every network of the reused route table", func() {
+				So(err, ShouldBeNil)
+				So(e.RouteTableSubnets, ShouldResemble, map[string][]string{
+					"rtb-00000001": {"subnet-00000001", "subnet-00000005", "subnet-00000009"},
+				})
+
+				payload, _ := json.Marshal(e)
+				So(string(payload), ShouldContainSubstring, `"route_table_subnets":{"rtb-00000001":["subnet-00000001","subnet-00000005","subnet-00000009"]}`)
+			})
+		})
+	})
+}
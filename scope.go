@@ -0,0 +1,52 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ErrScopeTagInvalid ...
+var ErrScopeTagInvalid = errors.New("Scope tag must be formatted as key=value")
+
+// scopeTag restricts the resources the connector describes to the ones
+// with this tag, so it can't touch anything else on shared accounts
+var scopeTag *ec2.Tag
+
+// parseScopeTag parses a key=value scope tag. It returns no tag when
+// none is configured.
+func parseScopeTag(s string) (*ec2.Tag, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, ErrScopeTagInvalid
+	}
+
+	return &ec2.Tag{Key: aws.String(parts[0]), Value: aws.String(parts[1])}, nil
+}
+
+// withScopeTag returns the tags to set on a resource the connector
+// manages, with the scope tag so it stays visible to the connector
+func withScopeTag(tags map[string]string) map[string]string {
+	if scopeTag == nil {
+		return tags
+	}
+
+	scoped := map[string]string{
+		aws.StringValue(scopeTag.Key): aws.StringValue(scopeTag.Value),
+	}
+	for k, v := range tags {
+		scoped[k] = v
+	}
+
+	return scoped
+}
@@ -0,0 +1,97 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseScopeTag(t *testing.T) {
+	Convey("Given scope tag settings", t, func() {
+		Convey("When none is configured", func() {
+			tag, err := parseScopeTag("")
+
+			Convey("It should not scope anything", func() {
+				So(err, ShouldBeNil)
+				So(tag, ShouldBeNil)
+			})
+		})
+
+		Convey("When it is a key and value", func() {
+			tag, err := parseScopeTag("ernest=true")
+
+			Convey("It should return the tag", func() {
+				So(err, ShouldBeNil)
+				So(aws.StringValue(tag.Key), ShouldEqual, "ernest")
+				So(aws.StringValue(tag.Value), ShouldEqual, "true")
+			})
+		})
+
+		Convey("When it has no value", func() {
+			_, err := parseScopeTag("ernest")
+
+			Convey("It should error", func() {
+				So(err, ShouldEqual, ErrScopeTagInvalid)
+			})
+		})
+	})
+}
+
+func TestScopeTag(t *testing.T) {
+	Convey("Given the connector is scoped to a tag", t, func() {
+		scopeTag = &ec2.Tag{Key: aws.String("ernest"), Value: aws.String("true")}
+
+		Convey("When building the ec2 filters", func() {
+			ef := filters(map[string][]string{"vpc-id": {"vpc-0000000"}})
+
+			Convey("It should append the scope tag filter", func() {
+				So(len(ef), ShouldEqual, 2)
+				So(*ef[0].Name, ShouldEqual, "tag:ernest")
+				So(aws.StringValueSlice(ef[0].Values), ShouldResemble, []string{"true"})
+				So(*ef[1].Name, ShouldEqual, "vpc-id")
+			})
+		})
+
+		Convey("When creating an event on a network with an untagged route table", func() {
+			data, _ := json.Marshal(testEvent)
+			f := newFakeEC2()
+			useFake(f)
+
+			f.routeTables = append(f.routeTables, &ec2.RouteTable{
+				RouteTableId: aws.String("rtb-unscoped"),
+				VpcId:        aws.String(testEvent.VPCID),
+				Associations: []*ec2.RouteTableAssociation{
+					&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000001")},
+				},
+			})
+
+			e := New("nat.create.aws", data)
+			e.Process()
+			err := e.Create()
+
+			Convey("It should not see the untagged route table", func() {
+				So(err, ShouldBeNil)
+				So(e.RouteTables["subnet-00000001"], ShouldNotEqual, "rtb-unscoped")
+				So(f.routeTables[0].Routes, ShouldBeEmpty)
+			})
+
+			Convey("It should tag the resources it creates with the scope tag", func() {
+				So(hasTag(f.routeTables[1].Tags, "ernest", "true"), ShouldBeTrue)
+				So(hasTag(f.internetGateways[0].Tags, "ernest", "true"), ShouldBeTrue)
+				So(hasTag(f.natGateways[0].Tags, "ernest", "true"), ShouldBeTrue)
+			})
+		})
+
+		Reset(func() {
+			scopeTag = nil
+		})
+	})
+}
@@ -0,0 +1,35 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+// Statuses of the operations changing nat gateways, so consumers can branch
+// on a single field instead of the subject of the result
+const (
+	statusCreated = "created"
+	statusUpdated = "updated"
+	statusDeleted = "deleted"
+	statusNoOp    = "no_op"
+	statusFailed  = "failed"
+)
+
+// actionStatuses are the statuses of the actions that succeeded
+var actionStatuses = map[string]string{
+	"create": statusCreated,
+	"update": statusUpdated,
+	"delete": statusDeleted,
+}
+
+// operationStatus returns the status of an action given its outcome. The
+// actions not changing nat gateways have none.
+func (ev *Event) operationStatus(action string, err error) string {
+	switch {
+	case err != nil:
+		return statusFailed
+	case ev.NoOpReason != "":
+		return statusNoOp
+	}
+
+	return actionStatuses[action]
+}
@@ -0,0 +1,113 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOperationStatus(t *testing.T) {
+	deletePollInterval = time.Millisecond
+	deleteGracePeriod = 0
+
+	Convey("Given a nat gateway event", t, func() {
+		log.SetOutput(ioutil.Discard)
+		f := newFakeEC2()
+		useFake(f)
+
+		execute := func(action string, ev Event) *Event {
+			data, _ := json.Marshal(ev)
+			e := New("nat."+action+".aws", data)
+			e.Process()
+			e.Execute(action)
+			return &e
+		}
+
+		Convey("When creating it", func() {
+			e := execute("create", testEvent)
+
+			Convey("It should report it as created", func() {
+				So(e.Status, ShouldEqual, "created")
+				payload, _ := json.Marshal(e)
+				So(string(payload), ShouldContainSubstring, `"status":"created"`)
+			})
+		})
+
+		Convey("When updating it", func() {
+			withNatGateway(f)
+			e := execute("update", testEvent)
+
+			Convey("It should report it as updated", func() {
+				So(e.Status, ShouldEqual, "updated")
+			})
+		})
+
+		Convey("When deleting it", func() {
+			withNatGateway(f)
+			e := execute("delete", testEvent)
+
+			Convey("It should report it as deleted", func() {
+				So(e.Status, ShouldEqual, "deleted")
+			})
+		})
+
+		Convey("When no nat gateway is needed", func() {
+			ev := testEvent
+			ev.NatGatewayAWSID = ""
+			ev.RouteConflictPolicy = "skip"
+			f.routeTables = append(f.routeTables, &ec2.RouteTable{
+				RouteTableId: aws.String("rtb-00000001"),
+				VpcId:        aws.String(testEvent.VPCID),
+				Associations: []*ec2.RouteTableAssociation{
+					&ec2.RouteTableAssociation{SubnetId: aws.String("subnet-00000001")},
+				},
+				Routes: []*ec2.Route{
+					&ec2.Route{
+						DestinationCidrBlock: aws.String(defaultRoute),
+						TransitGatewayId:     aws.String("tgw-00000001"),
+					},
+				},
+			})
+			e := execute("create", ev)
+
+			Convey("It should report nothing was done", func() {
+				So(e.Status, ShouldEqual, "no_op")
+			})
+		})
+
+		Convey("When the operation fails", func() {
+			f.errors["AllocateAddress"] = errors.New("allocation failed")
+			e := execute("create", testEvent)
+
+			Convey("It should report it as failed", func() {
+				So(e.Status, ShouldEqual, "failed")
+			})
+		})
+
+		Convey("When getting it", func() {
+			withNatGateway(f)
+			e := execute("get", testEvent)
+
+			Convey("It should report no status", func() {
+				So(e.Status, ShouldBeEmpty)
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stdout)
+		})
+	})
+}
@@ -0,0 +1,96 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// resultsStream is the subject every operation result is appended to.
+// It is expected to be captured by a JetStream stream, and publishing
+// is disabled when it is empty.
+var resultsStream = os.Getenv("NAT_RESULTS_STREAM")
+
+var (
+	streamMu  sync.Mutex
+	streamSeq uint64
+	// streamProcess identifies the records published by this process,
+	// as sequences restart with every process
+	streamProcess = processID()
+)
+
+// StreamRecord : an entry on the results stream
+type StreamRecord struct {
+	Process   string          `json:"process"`
+	Sequence  uint64          `json:"sequence"`
+	Subject   string          `json:"subject"`
+	Status    string          `json:"status"`
+	UUID      string          `json:"_uuid"`
+	BatchID   string          `json:"_batch_id"`
+	Timestamp time.Time       `json:"timestamp"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// stream appends the result of the current event to the results stream.
+// Records are sequenced and published while holding the lock, so records
+// of the same process are in the order their results were produced. The
+// order across processes is the one of the JetStream stream sequence.
+// Credentials are never written to the stream, as it is kept for replay.
+func (ev *Event) stream(status string) {
+	if resultsStream == "" {
+		return
+	}
+
+	redacted := *ev
+	redacted.DatacenterAccessKey = ""
+	redacted.DatacenterAccessToken = ""
+
+	data, err := json.Marshal(redacted)
+	if err != nil {
+		log.Printf("Error: could not encode stream event: %s", err.Error())
+		return
+	}
+
+	streamMu.Lock()
+	defer streamMu.Unlock()
+
+	streamSeq++
+	r := StreamRecord{
+		Process:   streamProcess,
+		Sequence:  streamSeq,
+		Subject:   ev.subject,
+		Status:    status,
+		UUID:      ev.UUID,
+		BatchID:   ev.BatchID,
+		Timestamp: time.Now().UTC(),
+		Event:     data,
+	}
+
+	rd, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("Error: could not encode stream record: %s", err.Error())
+		return
+	}
+
+	if err := nc.Publish(resultsStream, rd); err != nil {
+		log.Printf("Error: could not publish to %s: %s", resultsStream, err.Error())
+	}
+}
+
+// processID returns a random identifier for the running process
+func processID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
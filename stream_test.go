@@ -0,0 +1,107 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/nats-io/nats"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestResultsStream(t *testing.T) {
+	subject := "nat.create.aws"
+	testSetup(subject)
+
+	records := make(chan *nats.Msg, 10)
+	nc.ChanSubscribe("nat.results.test", records)
+
+	Convey("Given a configured results stream", t, func() {
+		resultsStream = "nat.results.test"
+		valid, _ := json.Marshal(testEvent)
+
+		Convey("When completing an event", func() {
+			e := New(subject, valid)
+			e.Process()
+			e.Complete()
+
+			Convey("It should append a done record to the stream", func() {
+				msg, timeout := waitMsg(records)
+				So(timeout, ShouldBeNil)
+
+				var r StreamRecord
+				So(json.Unmarshal(msg.Data, &r), ShouldBeNil)
+				So(r.Process, ShouldEqual, streamProcess)
+				So(len(r.Process), ShouldEqual, 16)
+				So(r.Sequence, ShouldBeGreaterThan, 0)
+				So(r.Subject, ShouldEqual, subject)
+				So(r.Status, ShouldEqual, "done")
+				So(r.UUID, ShouldEqual, "test")
+				So(r.BatchID, ShouldEqual, "test")
+				So(r.Timestamp.IsZero(), ShouldBeFalse)
+
+				var ev Event
+				So(json.Unmarshal(r.Event, &ev), ShouldBeNil)
+				So(ev.NatGatewayAWSID, ShouldEqual, testEvent.NatGatewayAWSID)
+			})
+
+			Convey("It should not write the credentials to the stream", func() {
+				msg, timeout := waitMsg(records)
+				So(timeout, ShouldBeNil)
+				So(string(msg.Data), ShouldNotContainSubstring, `"key"`)
+				So(string(msg.Data), ShouldNotContainSubstring, `"token"`)
+				So(string(msg.Data), ShouldContainSubstring, `"datacenter_secret":""`)
+				So(string(msg.Data), ShouldContainSubstring, `"datacenter_token":""`)
+			})
+		})
+
+		Convey("When erroring consecutive events", func() {
+			log.SetOutput(ioutil.Discard)
+			e := New(subject, valid)
+			e.Process()
+			e.Error(errors.New("first"))
+			e.Error(errors.New("second"))
+			log.SetOutput(os.Stdout)
+
+			Convey("It should append ordered error records to the stream", func() {
+				var first, second StreamRecord
+				msg, timeout := waitMsg(records)
+				So(timeout, ShouldBeNil)
+				So(json.Unmarshal(msg.Data, &first), ShouldBeNil)
+				msg, timeout = waitMsg(records)
+				So(timeout, ShouldBeNil)
+				So(json.Unmarshal(msg.Data, &second), ShouldBeNil)
+
+				So(first.Status, ShouldEqual, "error")
+				So(second.Sequence, ShouldEqual, first.Sequence+1)
+				So(string(first.Event), ShouldContainSubstring, `"error_message":"first"`)
+				So(string(second.Event), ShouldContainSubstring, `"error_message":"second"`)
+			})
+		})
+
+		Convey("When the stream is disabled", func() {
+			resultsStream = ""
+			e := New(subject, valid)
+			e.Process()
+			e.Complete()
+
+			Convey("It should not publish to the stream", func() {
+				msg, timeout := waitMsg(records)
+				So(msg, ShouldBeNil)
+				So(timeout, ShouldNotBeNil)
+			})
+		})
+
+		Reset(func() {
+			resultsStream = ""
+		})
+	})
+}
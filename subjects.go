@@ -0,0 +1,82 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// actions are the operations a subject verb can ask for
+var actions = map[string]bool{
+	"create":   true,
+	"update":   true,
+	"delete":   true,
+	"get":      true,
+	"igw":      true,
+	"validate": true,
+}
+
+// subjectActions maps the subject verbs of deployments naming the
+// operations otherwise, as nat.provision.aws, to the action they ask for
+var subjectActions map[string]string
+
+// parseSubjectActions parses the configured mapping, a json object of
+// subject verbs to actions like {"provision": "create"}
+func parseSubjectActions(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var mapping map[string]string
+	if err := json.Unmarshal([]byte(s), &mapping); err != nil {
+		return nil, fmt.Errorf("Subject actions must be a json object of subject verbs to actions: %s", err.Error())
+	}
+
+	for verb, action := range mapping {
+		if verb == "" || strings.Contains(verb, ".") {
+			return nil, fmt.Errorf("Subject verb %q is invalid", verb)
+		}
+
+		// the connector already listens on the subjects of its actions
+		if actions[verb] {
+			return nil, fmt.Errorf("Subject verb %s is already the %s action", verb, verb)
+		}
+
+		if !actions[action] {
+			return nil, fmt.Errorf("Subject verb %s maps to unknown action %s", verb, action)
+		}
+	}
+
+	return mapping, nil
+}
+
+// subjectAction returns the action a subject asks for, its verb unless
+// the verb is mapped to another action
+func subjectAction(subject string) string {
+	verb := strings.Split(subject, ".")[1]
+
+	if action, ok := subjectActions[verb]; ok {
+		return action
+	}
+
+	return verb
+}
+
+// mappedSubjects returns the subjects of the mapped verbs the connector
+// doesn't already listen on
+func mappedSubjects() []string {
+	var subjects []string
+	for verb := range subjectActions {
+		if !actions[verb] {
+			subjects = append(subjects, "nat."+verb+".aws")
+		}
+	}
+	sort.Strings(subjects)
+
+	return subjects
+}
@@ -0,0 +1,125 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/nats-io/nats"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseSubjectActions(t *testing.T) {
+	Convey("Given a configured subject mapping", t, func() {
+		Convey("When it is not set", func() {
+			mapping, err := parseSubjectActions("")
+
+			Convey("It should map nothing", func() {
+				So(err, ShouldBeNil)
+				So(mapping, ShouldBeNil)
+			})
+		})
+
+		Convey("When it maps verbs to actions", func() {
+			mapping, err := parseSubjectActions(`{"provision": "create", "destroy": "delete"}`)
+
+			Convey("It should parse them", func() {
+				So(err, ShouldBeNil)
+				So(mapping, ShouldResemble, map[string]string{"provision": "create", "destroy": "delete"})
+			})
+		})
+
+		Convey("When it maps a verb to an unknown action", func() {
+			_, err := parseSubjectActions(`{"provision": "build"}`)
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Subject verb provision maps to unknown action build")
+			})
+		})
+
+		Convey("When it maps a verb of an action", func() {
+			_, err := parseSubjectActions(`{"get": "validate"}`)
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Subject verb get is already the get action")
+			})
+		})
+
+		Convey("When a verb spans subject tokens", func() {
+			_, err := parseSubjectActions(`{"provision.now": "create"}`)
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, `Subject verb "provision.now" is invalid`)
+			})
+		})
+
+		Convey("When it is not a json object", func() {
+			_, err := parseSubjectActions("provision=create")
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, "Subject actions must be a json object of subject verbs to actions")
+			})
+		})
+	})
+}
+
+func TestSubjectActions(t *testing.T) {
+	Convey("Given a subject mapping", t, func() {
+		log.SetOutput(ioutil.Discard)
+		subjectActions = map[string]string{"provision": "create", "create": "create"}
+
+		published := make(map[string][]byte)
+		publish = func(subject string, data []byte) error {
+			published[subject] = data
+			return nil
+		}
+
+		Convey("When listing the subjects to listen on", func() {
+			Convey("It should include the mapped ones once", func() {
+				So(mappedSubjects(), ShouldResemble, []string{"nat.provision.aws"})
+			})
+		})
+
+		Convey("When resolving the action of a subject", func() {
+			Convey("It should map the mapped verbs only", func() {
+				So(subjectAction("nat.provision.aws"), ShouldEqual, "create")
+				So(subjectAction("nat.create.aws"), ShouldEqual, "create")
+				So(subjectAction("nat.get.aws"), ShouldEqual, "get")
+			})
+		})
+
+		Convey("When handling an event on a mapped subject", func() {
+			f := newFakeEC2()
+			useFake(f)
+			ev := testEvent
+			ev.NatGatewayAWSID = ""
+			data, _ := json.Marshal(ev)
+			eventHandler(&nats.Msg{Subject: "nat.provision.aws", Data: data})
+
+			Convey("It should run the mapped action and answer on the subject", func() {
+				So(f.Count("CreateNatGateway"), ShouldEqual, 1)
+				So(published, ShouldContainKey, "nat.provision.aws.done")
+				So(string(published["nat.provision.aws.done"]), ShouldContainSubstring, `"action":"create"`)
+			})
+		})
+
+		Reset(func() {
+			subjectActions = nil
+			log.SetOutput(os.Stdout)
+			publish = func(subject string, data []byte) error {
+				return nc.Publish(subject, data)
+			}
+		})
+	})
+}
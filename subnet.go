@@ -0,0 +1,122 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+var (
+	// subnetPollInterval is how often a pending subnet is checked
+	subnetPollInterval = time.Second * 3
+	// subnetPollAttempts bounds how many times a pending subnet is checked
+	subnetPollAttempts = 20
+)
+
+// maxSecondaryPrivateIPAddresses is how many secondary private addresses
+// a nat gateway can have
+const maxSecondaryPrivateIPAddresses = 31
+
+var (
+	// ErrSubnetNotAvailable ...
+	ErrSubnetNotAvailable = errors.New("Network did not become available")
+	// ErrPrivateIPAddressInvalid ...
+	ErrPrivateIPAddressInvalid = errors.New("Private ip address must be an ipv4 address")
+	// ErrSecondaryIPCountInvalid ...
+	ErrSecondaryIPCountInvalid = errors.New("Secondary private ip address count must be between 0 and 31")
+)
+
+// waitForSubnet waits for a subnet that may still be being created to be
+// available, as creating a nat gateway on it would fail otherwise
+func (ev *Event) waitForSubnet(svc ec2iface.EC2API, subnet string) error {
+	req := ec2.DescribeSubnetsInput{
+		SubnetIds: []*string{aws.String(subnet)},
+	}
+
+	for i := 0; i < subnetPollAttempts; i++ {
+		if i > 0 {
+			sleep(subnetPollInterval)
+		}
+
+		resp, err := svc.DescribeSubnets(&req)
+		if isAWSError(err, "InvalidSubnetID.NotFound") {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if len(resp.Subnets) > 0 && aws.StringValue(resp.Subnets[0].State) == ec2.SubnetStateAvailable {
+			return nil
+		}
+	}
+
+	return ErrSubnetNotAvailable
+}
+
+// validatePrivateAddresses checks the nat gateway private addresses
+// requested are well formed
+func (ev *Event) validatePrivateAddresses() error {
+	if ev.PrivateIPAddress != "" {
+		ip := net.ParseIP(ev.PrivateIPAddress)
+		if ip == nil || ip.To4() == nil {
+			return ErrPrivateIPAddressInvalid
+		}
+	}
+
+	if ev.SecondaryIPCount < 0 || ev.SecondaryIPCount > maxSecondaryPrivateIPAddresses {
+		return ErrSecondaryIPCountInvalid
+	}
+
+	return nil
+}
+
+// checkPrivateAddresses checks the nat gateway network can give it the
+// private addresses requested: the primary one must be on the network,
+// and it must have enough free addresses for the primary and secondary
+// ones, as aws would only fail the nat gateway once it was created
+func (ev *Event) checkPrivateAddresses(svc ec2iface.EC2API) error {
+	if ev.PrivateIPAddress == "" && ev.SecondaryIPCount == 0 {
+		return nil
+	}
+
+	resp, err := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{
+		SubnetIds: []*string{aws.String(ev.PublicNetworkAWSID)},
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(resp.Subnets) == 0 {
+		return missingField("subnet")
+	}
+	subnet := resp.Subnets[0]
+
+	if ev.PrivateIPAddress != "" {
+		_, cidr, err := net.ParseCIDR(aws.StringValue(subnet.CidrBlock))
+		if err != nil {
+			return err
+		}
+
+		if !cidr.Contains(net.ParseIP(ev.PrivateIPAddress)) {
+			return fmt.Errorf("Private ip address %s is not on network %s (%s)", ev.PrivateIPAddress, ev.PublicNetworkAWSID, cidr.String())
+		}
+	}
+
+	needed := 1 + ev.SecondaryIPCount
+	if free := aws.Int64Value(subnet.AvailableIpAddressCount); free < needed {
+		return fmt.Errorf("Network %s has %d free addresses, the nat gateway needs %d", ev.PublicNetworkAWSID, free, needed)
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWaitForSubnet(t *testing.T) {
+	subnetPollInterval = time.Millisecond
+	subject := "nat.create.aws"
+
+	Convey("Given a create event", t, func() {
+		data, _ := json.Marshal(testEvent)
+		f := newFakeEC2()
+		useFake(f)
+
+		e := New(subject, data)
+		e.Process()
+
+		Convey("When the public network is pending and then becomes available", func() {
+			f.subnets[testEvent.PublicNetworkAWSID] = &ec2.Subnet{
+				SubnetId: aws.String(testEvent.PublicNetworkAWSID),
+				VpcId:    aws.String(testEvent.VPCID),
+				State:    aws.String(ec2.SubnetStatePending),
+			}
+			polls := 0
+			f.hooks["DescribeSubnets"] = func(input interface{}) (interface{}, error) {
+				in := input.(*ec2.DescribeSubnetsInput)
+				s := f.subnet(aws.StringValue(in.SubnetIds[0]))
+				if aws.StringValue(s.SubnetId) == testEvent.PublicNetworkAWSID {
+					polls++
+					if polls > 2 {
+						s.State = aws.String(ec2.SubnetStateAvailable)
+					}
+				}
+				return &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{s}}, nil
+			}
+			err := e.Create()
+
+			Convey("It should wait for it before creating the nat gateway", func() {
+				So(err, ShouldBeNil)
+				So(polls, ShouldEqual, 3)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the public network is not yet visible", func() {
+			polls := 0
+			f.hooks["DescribeSubnets"] = func(input interface{}) (interface{}, error) {
+				in := input.(*ec2.DescribeSubnetsInput)
+				id := aws.StringValue(in.SubnetIds[0])
+				if id == testEvent.PublicNetworkAWSID {
+					polls++
+					if polls == 1 {
+						return nil, awserr.New("InvalidSubnetID.NotFound", "not found", nil)
+					}
+				}
+				return &ec2.DescribeSubnetsOutput{
+					Subnets: []*ec2.Subnet{f.subnet(id)},
+				}, nil
+			}
+			err := e.Create()
+
+			Convey("It should keep checking until it is available", func() {
+				So(err, ShouldBeNil)
+				So(polls, ShouldEqual, 2)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the public network never becomes available", func() {
+			f.subnets[testEvent.PublicNetworkAWSID] = &ec2.Subnet{
+				SubnetId: aws.String(testEvent.PublicNetworkAWSID),
+				State:    aws.String(ec2.SubnetStatePending),
+			}
+			err := e.Create()
+
+			Convey("It should give up before allocating any resource", func() {
+				So(err, ShouldEqual, ErrSubnetNotAvailable)
+				// besides the polls, the routed networks' vpc is checked
+				So(f.Count("DescribeSubnets"), ShouldEqual, subnetPollAttempts+1)
+				So(f.Count("AllocateAddress"), ShouldEqual, 0)
+				So(f.Count("CreateInternetGateway"), ShouldEqual, 0)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestPrivateAddresses(t *testing.T) {
+	subject := "nat.create.aws"
+
+	Convey("Given a create event with private addresses", t, func() {
+		ev := testEvent
+		ev.PrivateIPAddress = "10.0.0.10"
+		ev.SecondaryIPCount = 2
+		f := newFakeEC2()
+		useFake(f)
+
+		public := &ec2.Subnet{
+			SubnetId:                aws.String(testEvent.PublicNetworkAWSID),
+			VpcId:                   aws.String(testEvent.VPCID),
+			State:                   aws.String(ec2.SubnetStateAvailable),
+			CidrBlock:               aws.String("10.0.0.0/24"),
+			AvailableIpAddressCount: aws.Int64(3),
+		}
+		f.subnets[testEvent.PublicNetworkAWSID] = public
+
+		var req *ec2.CreateNatGatewayInput
+		f.hooks["CreateNatGateway"] = func(input interface{}) (interface{}, error) {
+			req = input.(*ec2.CreateNatGatewayInput)
+			return &ec2.CreateNatGatewayOutput{NatGateway: &ec2.NatGateway{
+				NatGatewayId: aws.String("nat-00000001"),
+				State:        aws.String(ec2.NatGatewayStatePending),
+			}}, nil
+		}
+		f.natGateways = append(f.natGateways, &ec2.NatGateway{
+			NatGatewayId: aws.String("nat-00000001"),
+			State:        aws.String(ec2.NatGatewayStateAvailable),
+			NatGatewayAddresses: []*ec2.NatGatewayAddress{
+				{AllocationId: aws.String("eipalloc-00000001"), PublicIp: aws.String("52.0.0.1")},
+			},
+		})
+
+		create := func(ev Event) (Event, error) {
+			data, _ := json.Marshal(ev)
+			e := New(subject, data)
+			e.Process()
+			if err := e.Validate(); err != nil {
+				return e, err
+			}
+			return e, e.Create()
+		}
+
+		Convey("When the network has enough free addresses", func() {
+			_, err := create(ev)
+
+			Convey("It should create the nat gateway with them", func() {
+				So(err, ShouldBeNil)
+				So(req, ShouldNotBeNil)
+				So(aws.StringValue(req.PrivateIpAddress), ShouldEqual, "10.0.0.10")
+				So(aws.Int64Value(req.SecondaryPrivateIpAddressCount), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When the network doesn't have enough free addresses", func() {
+			public.AvailableIpAddressCount = aws.Int64(2)
+			_, err := create(ev)
+
+			Convey("It should fail before creating anything", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Network subnet-00000000 has 2 free addresses, the nat gateway needs 3")
+				So(f.Count("AllocateAddress"), ShouldEqual, 0)
+				So(f.Count("CreateNatGateway"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the private address is not on the network", func() {
+			ev.PrivateIPAddress = "10.0.1.10"
+			_, err := create(ev)
+
+			Convey("It should fail before creating anything", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "Private ip address 10.0.1.10 is not on network subnet-00000000 (10.0.0.0/24)")
+				So(f.Count("CreateNatGateway"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the private address is not an ipv4 address", func() {
+			ev.PrivateIPAddress = "fd00::10"
+			_, err := create(ev)
+
+			Convey("It should not validate", func() {
+				So(err, ShouldEqual, ErrPrivateIPAddressInvalid)
+			})
+		})
+
+		Convey("When too many secondary addresses are requested", func() {
+			ev.SecondaryIPCount = 32
+			_, err := create(ev)
+
+			Convey("It should not validate", func() {
+				So(err, ShouldEqual, ErrSecondaryIPCountInvalid)
+			})
+		})
+	})
+}
@@ -0,0 +1,59 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/nats-io/nats"
+)
+
+// pendingLimits parses the configured maximum of messages and bytes a
+// subscription keeps pending, the nats defaults when not configured
+func pendingLimits(msgs, bytes string) (int, int, error) {
+	maxMsgs, maxBytes := nats.DefaultSubPendingMsgsLimit, nats.DefaultSubPendingBytesLimit
+
+	var err error
+	if msgs != "" {
+		maxMsgs, err = strconv.Atoi(msgs)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if bytes != "" {
+		maxBytes, err = strconv.Atoi(bytes)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return maxMsgs, maxBytes, nil
+}
+
+// subscribe handles the events of a subject, keeping at most the given
+// messages and bytes pending while they are processed
+func subscribe(subject string, maxMsgs, maxBytes int) (*nats.Subscription, error) {
+	sub, err := nc.Subscribe(subject, eventHandler)
+	if err != nil {
+		return nil, err
+	}
+
+	return sub, sub.SetPendingLimits(maxMsgs, maxBytes)
+}
+
+// asyncErrorHandler logs the errors nats reports asynchronously, as the
+// events a slow consumer drops would otherwise go unnoticed
+func asyncErrorHandler(c *nats.Conn, sub *nats.Subscription, err error) {
+	if err != nats.ErrSlowConsumer || sub == nil {
+		log.Printf("Error: nats: %s", err.Error())
+		return
+	}
+
+	msgs, bytes, _ := sub.Pending()
+	dropped, _ := sub.Dropped()
+	log.Printf("Error: events on %s are arriving faster than they are processed, %d events dropped, %d events (%d bytes) pending", sub.Subject, dropped, msgs, bytes)
+}
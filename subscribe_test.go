@@ -0,0 +1,62 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPendingLimits(t *testing.T) {
+	Convey("Given pending limit settings", t, func() {
+		Convey("When none is configured", func() {
+			msgs, bytes, err := pendingLimits("", "")
+
+			Convey("It should use the nats defaults", func() {
+				So(err, ShouldBeNil)
+				So(msgs, ShouldEqual, nats.DefaultSubPendingMsgsLimit)
+				So(bytes, ShouldEqual, nats.DefaultSubPendingBytesLimit)
+			})
+		})
+
+		Convey("When they are configured", func() {
+			msgs, bytes, err := pendingLimits("100", "1048576")
+
+			Convey("It should use them", func() {
+				So(err, ShouldBeNil)
+				So(msgs, ShouldEqual, 100)
+				So(bytes, ShouldEqual, 1048576)
+			})
+		})
+
+		Convey("When they aren't numbers", func() {
+			_, _, err := pendingLimits("many", "")
+
+			Convey("It should error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestSubscribePendingLimits(t *testing.T) {
+	Convey("Given configured pending limits", t, func() {
+		Convey("When subscribing to a subject", func() {
+			sub, err := subscribe("nat.test.aws", 100, 1048576)
+			So(err, ShouldBeNil)
+			defer sub.Unsubscribe()
+
+			Convey("It should set them on the subscription", func() {
+				msgs, bytes, err := sub.PendingLimits()
+				So(err, ShouldBeNil)
+				So(msgs, ShouldEqual, 100)
+				So(bytes, ShouldEqual, 1048576)
+			})
+		})
+	})
+}
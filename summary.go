@@ -0,0 +1,50 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// summaryVerbs describe what each action did to the nat gateway
+var summaryVerbs = map[string]string{
+	"create":   "created",
+	"update":   "updated",
+	"delete":   "deleted",
+	"get":      "found",
+	"igw":      "attached",
+	"validate": "validated",
+}
+
+// summary returns a single human readable line describing the outcome of
+// a completed operation, for operators scanning the logs
+func (ev *Event) summary(elapsed time.Duration) string {
+	verb, ok := summaryVerbs[ev.Action]
+	if !ok {
+		verb = ev.Action
+	}
+
+	var s string
+	switch {
+	case ev.NoOpReason != "":
+		return "no nat gateway needed in " + elapsed.Round(time.Second).String()
+	case len(ev.Regions) > 0:
+		s = fmt.Sprintf("%s nat gateways in %d regions", verb, len(ev.Regions))
+	case ev.Action == "igw":
+		s = fmt.Sprintf("%s %s to %s", verb, ev.InternetGatewayID, ev.VPCID)
+	default:
+		s = fmt.Sprintf("%s %s", verb, ev.NatGatewayAWSID)
+		if ev.NatGatewayAllocationIP != "" {
+			s += fmt.Sprintf(" (eip %s)", ev.NatGatewayAllocationIP)
+		}
+	}
+
+	if len(ev.Regions) == 0 && (ev.Action == "create" || ev.Action == "update") {
+		s += fmt.Sprintf(" routed %d subnets", len(ev.RoutedNetworkAWSIDs))
+	}
+
+	return s + " in " + elapsed.Round(time.Second).String()
+}
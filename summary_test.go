@@ -0,0 +1,64 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSummary(t *testing.T) {
+	Convey("Given a completed operation", t, func() {
+		e := testEvent
+		e.NatGatewayAWSID = "nat-abc"
+		e.NatGatewayAllocationIP = "1.2.3.4"
+		e.RoutedNetworkAWSIDs = []string{"subnet-00000001", "subnet-00000002", "subnet-00000003"}
+
+		Convey("When it created the nat gateway", func() {
+			e.Action = "create"
+
+			Convey("It should summarize the gateway, its address and the networks routed", func() {
+				So(e.summary(47*time.Second+300*time.Millisecond), ShouldEqual, "created nat-abc (eip 1.2.3.4) routed 3 subnets in 47s")
+			})
+		})
+
+		Convey("When it deleted the nat gateway", func() {
+			e.Action = "delete"
+
+			Convey("It should not mention the networks", func() {
+				So(e.summary(2*time.Minute), ShouldEqual, "deleted nat-abc (eip 1.2.3.4) in 2m0s")
+			})
+		})
+
+		Convey("When it attached an internet gateway", func() {
+			e.Action = "igw"
+			e.InternetGatewayID = "igw-abc"
+
+			Convey("It should summarize the internet gateway", func() {
+				So(e.summary(time.Second), ShouldEqual, "attached igw-abc to vpc-0000000 in 1s")
+			})
+		})
+
+		Convey("When no nat gateway was needed", func() {
+			e.Action = "create"
+			e.NoOpReason = "No nat gateway needed"
+
+			Convey("It should say nothing was created", func() {
+				So(e.summary(time.Second), ShouldEqual, "no nat gateway needed in 1s")
+			})
+		})
+
+		Convey("When it ran on several regions", func() {
+			e.Action = "update"
+			e.Regions = map[string]*Region{"eu-west-1": &Region{}, "us-east-1": &Region{}}
+
+			Convey("It should summarize the regions", func() {
+				So(e.summary(5*time.Second), ShouldEqual, "updated nat gateways in 2 regions in 5s")
+			})
+		})
+	})
+}
@@ -0,0 +1,103 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// deleteNatGatewayRoutes removes the routed networks default routes that
+// target the nat gateway. Route tables are kept unless the connector
+// created them for the routed network alone, and the internet gateway is
+// always kept, as they may be shared with resources it doesn't manage.
+func (ev *Event) deleteNatGatewayRoutes(svc ec2iface.EC2API) error {
+	for _, subnet := range ev.RoutedNetworkAWSIDs {
+		rt, err := ev.routingTableBySubnetID(svc, subnet)
+		if err != nil {
+			return err
+		}
+
+		if rt == nil {
+			continue
+		}
+
+		route := defaultRouteOf(rt)
+		if route == nil || !ev.natRouteTarget().matches(route) {
+			continue
+		}
+
+		req := ec2.DeleteRouteInput{
+			RouteTableId:         rt.RouteTableId,
+			DestinationCidrBlock: aws.String(defaultRoute),
+		}
+
+		_, err = svc.DeleteRoute(&req)
+		if err != nil && !isAWSError(err, "InvalidRoute.NotFound") {
+			return err
+		}
+
+		ev.RouteChanges = append(ev.RouteChanges, RouteChange{
+			NetworkAWSID:   subnet,
+			RouteTableID:   aws.StringValue(rt.RouteTableId),
+			Action:         routeRemoved,
+			PreviousTarget: ev.natRouteTarget().id,
+		})
+
+		if isOwned(rt.Tags) && len(rt.Associations) == 1 {
+			err = ev.deleteRouteTable(svc, rt)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleteRouteTable removes a route table the connector created, once it
+// is no longer associated with its routed network
+func (ev *Event) deleteRouteTable(svc ec2iface.EC2API, rt *ec2.RouteTable) error {
+	for _, a := range rt.Associations {
+		req := ec2.DisassociateRouteTableInput{
+			AssociationId: a.RouteTableAssociationId,
+		}
+
+		_, err := svc.DisassociateRouteTable(&req)
+		if err != nil && !isAWSError(err, "InvalidAssociationID.NotFound") {
+			return err
+		}
+	}
+
+	req := ec2.DeleteRouteTableInput{
+		RouteTableId: rt.RouteTableId,
+	}
+
+	_, err := svc.DeleteRouteTable(&req)
+	if err != nil && !isAWSError(err, "InvalidRouteTableID.NotFound") {
+		return err
+	}
+
+	return nil
+}
+
+// releaseAddress releases the nat gateway elastic ip
+func (ev *Event) releaseAddress(svc ec2iface.EC2API) error {
+	if ev.NatGatewayAllocationID == "" {
+		return nil
+	}
+
+	req := ec2.ReleaseAddressInput{
+		AllocationId: aws.String(ev.NatGatewayAllocationID),
+	}
+
+	_, err := svc.ReleaseAddress(&req)
+	if err != nil && !isAWSError(err, "InvalidAllocationID.NotFound") {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeleteTeardownOrder(t *testing.T) {
+	deletePollInterval = time.Millisecond
+	deleteGracePeriod = 0
+
+	Convey("Given a nat gateway created by the connector", t, func() {
+		data, _ := json.Marshal(testEvent)
+		f := newFakeEC2()
+		useFake(f)
+
+		c := New("nat.create.aws", data)
+		c.Process()
+		So(c.Create(), ShouldBeNil)
+		payload, _ := json.Marshal(c)
+
+		Convey("When deleting it with teardown", func() {
+			var ev Event
+			json.Unmarshal(payload, &ev)
+			ev.Teardown = true
+			data, _ := json.Marshal(ev)
+
+			// the nat gateway takes a poll to be deleted
+			polls := 0
+			f.hooks["DescribeNatGateways"] = func(input interface{}) (interface{}, error) {
+				polls++
+				state := ec2.NatGatewayStateDeleting
+				if polls > 1 {
+					state = ec2.NatGatewayStateDeleted
+				}
+				return &ec2.DescribeNatGatewaysOutput{NatGateways: []*ec2.NatGateway{
+					&ec2.NatGateway{NatGatewayId: aws.String(c.NatGatewayAWSID), State: aws.String(state)},
+				}}, nil
+			}
+
+			before := len(f.Calls())
+			e := New("nat.delete.aws", data)
+			e.Process()
+			err := e.Delete()
+
+			Convey("It should release resources in dependency order", func() {
+				So(err, ShouldBeNil)
+				So(f.Calls()[before:], ShouldResemble, []string{
+					"DescribeRouteTables",
+					"DeleteRoute",
+					"DisassociateRouteTable",
+					"DeleteRouteTable",
+					"DeleteNatGateway",
+					"DescribeNatGateways",
+					"DescribeNatGateways",
+					"ReleaseAddress",
+				})
+				// the route table was created for the routed network alone
+				So(f.routeTables, ShouldBeEmpty)
+				So(e.RouteChanges[len(e.RouteChanges)-1].Action, ShouldEqual, "removed")
+			})
+		})
+
+		Convey("When deleting it without teardown", func() {
+			before := len(f.Calls())
+			e := New("nat.delete.aws", payload)
+			e.Process()
+			err := e.Delete()
+
+			Convey("It should only delete the nat gateway", func() {
+				So(err, ShouldBeNil)
+				So(f.Calls()[before:], ShouldResemble, []string{
+					"DeleteNatGateway",
+					"DescribeNatGateways",
+				})
+				So(defaultRouteOf(f.routeTables[0]), ShouldNotBeNil)
+			})
+		})
+	})
+}
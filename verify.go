@@ -0,0 +1,112 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// verifyReachability checks, when asked to, that the routed networks can
+// logically reach the internet once created: the nat gateway is
+// available, its network routes through an internet gateway attached to
+// the vpc, and every routed network routes through the nat gateway. Only
+// the chain is described, no traffic is sent. A broken chain is reported
+// with why, as aws may still be converging.
+func (ev *Event) verifyReachability(svc ec2iface.EC2API) error {
+	if !ev.VerifyReachability {
+		return nil
+	}
+
+	failure, err := ev.reachabilityFailure(svc)
+	if err != nil {
+		return err
+	}
+
+	ev.Verified = aws.Bool(failure == "")
+	ev.VerificationFailure = failure
+	if failure != "" {
+		ev.warn(failure)
+	}
+
+	return nil
+}
+
+// reachabilityFailure returns the first broken link of the chain from the
+// routed networks to the internet, none when it is consistent
+func (ev *Event) reachabilityFailure(svc ec2iface.EC2API) (string, error) {
+	gw, err := ev.natGatewayByID(svc, ev.NatGatewayAWSID)
+	if err == ErrNatGatewayNotFound || isAWSError(err, "NatGatewayNotFound") {
+		return fmt.Sprintf("Nat gateway %s was not found", ev.NatGatewayAWSID), nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if state := aws.StringValue(gw.State); state != ec2.NatGatewayStateAvailable {
+		return fmt.Sprintf("Nat gateway %s is %s instead of available", ev.NatGatewayAWSID, state), nil
+	}
+
+	// private nat gateways only reach other networks, through their routes
+	if !ev.isPrivate() {
+		failure, err := ev.internetRouteFailure(svc, aws.StringValue(gw.SubnetId))
+		if failure != "" || err != nil {
+			return failure, err
+		}
+	}
+
+	target := ev.natRouteTarget()
+	for _, subnet := range ev.RoutedNetworkAWSIDs {
+		rt, err := ev.subnetRouteTable(svc, subnet)
+		if err != nil {
+			return "", err
+		}
+
+		var route *ec2.Route
+		if rt != nil {
+			route = defaultRouteOf(rt)
+		}
+
+		if route == nil || !target.matches(route) || aws.StringValue(route.State) == ec2.RouteStateBlackhole {
+			return fmt.Sprintf("Network %s has no active default route through %s", subnet, target.id), nil
+		}
+	}
+
+	return "", nil
+}
+
+// internetRouteFailure returns why the nat gateway network doesn't route
+// through an internet gateway attached to the vpc, if it doesn't
+func (ev *Event) internetRouteFailure(svc ec2iface.EC2API, subnet string) (string, error) {
+	rt, err := ev.subnetRouteTable(svc, subnet)
+	if err != nil {
+		return "", err
+	}
+
+	var route *ec2.Route
+	if rt != nil {
+		route = defaultRouteOf(rt)
+	}
+
+	if route == nil || !isInternetRoute(route) || aws.StringValue(route.State) == ec2.RouteStateBlackhole {
+		return fmt.Sprintf("Network %s has no active default route through an internet gateway", subnet), nil
+	}
+
+	id := aws.StringValue(route.GatewayId)
+	ig, err := ev.internetGatewayByID(svc, id)
+	if err != nil {
+		return "", err
+	}
+
+	// an internet gateway attachment is available once attached
+	if ig == nil || ev.attachmentState(ig) != "available" {
+		return fmt.Sprintf("Internet gateway %s is not attached to vpc %s", id, ev.VPCID), nil
+	}
+
+	return "", nil
+}
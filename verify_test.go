@@ -0,0 +1,116 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVerifyReachability(t *testing.T) {
+	Convey("Given a create event verifying reachability", t, func() {
+		log.SetOutput(ioutil.Discard)
+		f := newFakeEC2()
+		useFake(f)
+		f.internetGateways = append(f.internetGateways, &ec2.InternetGateway{
+			InternetGatewayId: aws.String("igw-existing"),
+			Attachments: []*ec2.InternetGatewayAttachment{
+				&ec2.InternetGatewayAttachment{VpcId: aws.String(testEvent.VPCID), State: aws.String("available")},
+			},
+		})
+
+		create := func(verify bool) (*Event, error) {
+			ev := testEvent
+			ev.NatGatewayAWSID = ""
+			ev.VerifyReachability = verify
+			data, _ := json.Marshal(ev)
+			e := New("nat.create.aws", data)
+			e.Process()
+			return &e, e.Execute("create")
+		}
+
+		Convey("When the chain to the internet is consistent", func() {
+			publicRouteTable(f, false, &ec2.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), GatewayId: aws.String("igw-existing")})
+			e, err := create(true)
+
+			Convey("It should report it verified", func() {
+				So(err, ShouldBeNil)
+				So(*e.Verified, ShouldBeTrue)
+				So(e.VerificationFailure, ShouldBeEmpty)
+				So(e.Warnings, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the nat gateway network has no internet route", func() {
+			publicRouteTable(f, false)
+			e, err := create(true)
+
+			Convey("It should report why it is not verified", func() {
+				So(err, ShouldBeNil)
+				So(*e.Verified, ShouldBeFalse)
+				So(e.VerificationFailure, ShouldEqual, "Network subnet-00000000 has no active default route through an internet gateway")
+				So(e.Warnings, ShouldContain, e.VerificationFailure)
+			})
+		})
+
+		Convey("When the internet route goes through a detached internet gateway", func() {
+			publicRouteTable(f, false, &ec2.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), GatewayId: aws.String("igw-detached")})
+			f.internetGateways = append(f.internetGateways, &ec2.InternetGateway{InternetGatewayId: aws.String("igw-detached")})
+			e, err := create(true)
+
+			Convey("It should report why it is not verified", func() {
+				So(err, ShouldBeNil)
+				So(*e.Verified, ShouldBeFalse)
+				So(e.VerificationFailure, ShouldEqual, "Internet gateway igw-detached is not attached to vpc "+testEvent.VPCID)
+			})
+		})
+
+		Convey("When a routed network doesn't route through the nat gateway", func() {
+			publicRouteTable(f, false, &ec2.Route{DestinationCidrBlock: aws.String("0.0.0.0/0"), GatewayId: aws.String("igw-existing")})
+			f.hooks["CreateRoute"] = func(input interface{}) (interface{}, error) {
+				return &ec2.CreateRouteOutput{}, nil
+			}
+			e, err := create(true)
+
+			Convey("It should report why it is not verified", func() {
+				So(err, ShouldBeNil)
+				So(*e.Verified, ShouldBeFalse)
+				So(e.VerificationFailure, ShouldEqual, "Network subnet-00000001 has no active default route through "+e.NatGatewayAWSID)
+			})
+		})
+
+		Convey("When the nat gateway is gone by the time it is verified", func() {
+			ev := testEvent
+			ev.NatGatewayAWSID = "nat-gone"
+			failure, err := ev.reachabilityFailure(f)
+
+			Convey("It should report it was not found", func() {
+				So(err, ShouldBeNil)
+				So(failure, ShouldEqual, "Nat gateway nat-gone was not found")
+			})
+		})
+
+		Convey("When it is not asked to verify", func() {
+			e, err := create(false)
+
+			Convey("It should not report it", func() {
+				So(err, ShouldBeNil)
+				So(e.Verified, ShouldBeNil)
+			})
+		})
+
+		Reset(func() {
+			log.SetOutput(os.Stdout)
+		})
+	})
+}